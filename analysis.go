@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// analysis is what the editor tooling knows about a script without running
+// it: its tokens and syntax tree, the first error found, and where each
+// variable is declared.
+type analysis struct {
+	file     string
+	tokens   []token
+	comments []token
+	stmts    []stmt
+	// err is the first scan, parse or resolution error, if any.
+	err *syntaxError
+	// parsed reports whether the script was parsed successfully, so that
+	// stmts is complete even if resolution failed.
+	parsed bool
+	// refs maps every variable reference and declaration to the token that
+	// declared the variable.
+	refs map[token]token
+	// symbols are the functions, classes and methods of the script, nested
+	// as they are in the source.
+	symbols []*symbol
+	// decls are the declarations of the script by their name token.
+	decls map[token]*symbol
+}
+
+type symbolKind int
+
+const (
+	symbolVariable symbolKind = iota
+	symbolConstant
+	symbolParameter
+	symbolFunction
+	symbolClass
+	symbolMethod
+)
+
+// symbol is a declaration. start and end delimit the whole declaration.
+type symbol struct {
+	name       token
+	kind       symbolKind
+	start, end token
+	// params are the parameters of a function or method, or of the
+	// initializer of a class.
+	params     []token
+	paramTypes []*typeAnnotation
+	// typ is the annotated type of a variable or parameter, or the return
+	// type of a function or method.
+	typ *typeAnnotation
+	// superClass is the reference to the superclass of a class, if it has
+	// one.
+	superClass *token
+	children   []*symbol
+}
+
+// signature returns the declaration as it would be written, without the
+// body.
+func (s *symbol) signature() string {
+	ps := make([]string, len(s.params))
+	for k, p := range s.params {
+		ps[k] = annotated(p, s.paramTypes[k])
+	}
+	result := ""
+	if s.typ != nil && (s.kind == symbolFunction || s.kind == symbolMethod) {
+		result = ": " + s.typ.String()
+	}
+	switch s.kind {
+	case symbolFunction:
+		return "fun " + s.name.lexeme + "(" + strings.Join(ps, ", ") + ")" + result
+	case symbolMethod:
+		return s.name.lexeme + "(" + strings.Join(ps, ", ") + ")" + result
+	case symbolClass:
+		return "class " + s.name.lexeme
+	case symbolParameter:
+		return "parameter " + annotated(s.name, s.typ)
+	case symbolConstant:
+		return "const " + annotated(s.name, s.typ)
+	}
+	return "var " + annotated(s.name, s.typ)
+}
+
+// analyze scans, parses and resolves source without printing errors.
+func analyze(file, source string) *analysis {
+	a := &analysis{file: file, refs: map[token]token{}, decls: map[token]*symbol{}}
+	sc := &scanner{source: source, file: file, keepComments: true, quiet: true}
+	a.tokens = sc.scanTokens()
+	a.comments = sc.comments
+	if a.err = sc.err; a.err != nil {
+		return a
+	}
+	p := &parser{tokens: a.tokens, quiet: true}
+	a.stmts = p.parse()
+	if a.err = p.err; a.err != nil {
+		return a
+	}
+	a.parsed = true
+	r := &resolver{inter: newInterpreter(), refs: a.refs, quiet: true}
+	r.resolve(a.stmts)
+	a.err = r.err
+	a.symbols = a.collect(a.stmts)
+	return a
+}
+
+// collect records the declarations in ss and returns the functions and
+// classes among them, with the ones nested in them as children.
+func (a *analysis) collect(ss []stmt) []*symbol {
+	var ret []*symbol
+	for _, s := range ss {
+		ret = append(ret, a.collectStmt(s)...)
+	}
+	return ret
+}
+
+func (a *analysis) collectStmt(s stmt) []*symbol {
+	switch s := s.(type) {
+	case stmtVar:
+		kind := symbolVariable
+		if s.constant() {
+			kind = symbolConstant
+		}
+		a.declare(&symbol{name: s.name, kind: kind, start: s.keyword, end: stmtEnd(s), typ: s.typ})
+	case stmtBlock:
+		return a.collect(s.statements)
+	case stmtIf:
+		ret := a.collectStmt(s.thenBranch)
+		if s.elseBranch != nil {
+			ret = append(ret, a.collectStmt(s.elseBranch)...)
+		}
+		return ret
+	case stmtWhile:
+		return a.collectStmt(s.body)
+	case stmtForIn:
+		a.declare(&symbol{name: s.name, kind: symbolVariable, start: s.name, end: s.name})
+		return a.collectStmt(s.body)
+	case stmtFor:
+		if s.initializer != nil {
+			a.collectStmt(s.initializer)
+		}
+		return a.collectStmt(s.body)
+	case stmtFunction:
+		return []*symbol{a.function(s, symbolFunction)}
+	case stmtClass:
+		c := &symbol{name: s.name, kind: symbolClass, start: s.keyword, end: s.close}
+		if s.superClass != nil {
+			c.superClass = &s.superClass.name
+		}
+		for _, m := range s.methods {
+			ms := a.function(m, symbolMethod)
+			if m.name.lexeme == "init" {
+				c.params, c.paramTypes = m.params, m.paramTypes
+			}
+			c.children = append(c.children, ms)
+		}
+		a.declare(c)
+		return []*symbol{c}
+	}
+	return nil
+}
+
+func (a *analysis) function(s stmtFunction, kind symbolKind) *symbol {
+	f := &symbol{name: s.name, kind: kind, start: stmtStart(s), end: s.body.close,
+		params: s.params, paramTypes: s.paramTypes, typ: s.returnType}
+	if kind == symbolFunction {
+		a.declare(f)
+	}
+	for k, p := range s.params {
+		a.declare(&symbol{name: p, kind: symbolParameter, start: p, end: p, typ: s.paramTypes[k]})
+	}
+	f.children = a.collect(s.body.statements)
+	return f
+}
+
+func (a *analysis) declare(s *symbol) {
+	a.decls[s.name] = s
+}
+
+// tokenAt returns the identifier, this or super token at the given 1-based
+// position. A position just past the end of a token also selects it.
+func (a *analysis) tokenAt(line, column int) (token, bool) {
+	for _, t := range a.tokens {
+		if t.tt != tokenTypeIdentifier && t.tt != tokenTypeThis && t.tt != tokenTypeSuper {
+			continue
+		}
+		endLine, endColumn := tokenEnd(t)
+		if t.line == line && t.column <= column && line == endLine && column <= endColumn {
+			return t, true
+		}
+	}
+	return token{}, false
+}
+
+// declaration returns the token that declared the variable t refers to.
+func (a *analysis) declaration(t token) (token, bool) {
+	d, ok := a.refs[t]
+	return d, ok
+}
+
+// references returns the references to the variable declared by decl in
+// source order, including decl itself.
+func (a *analysis) references(decl token) []token {
+	var ret []token
+	for ref, d := range a.refs {
+		if d == decl {
+			ret = append(ret, ref)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return before(ret[i], ret[j].line, ret[j].column)
+	})
+	return ret
+}
+
+// describe returns a description of what t refers to, including the
+// number of arguments it takes if it is callable.
+func (a *analysis) describe(t token) (string, bool) {
+	if d, ok := a.refs[t]; ok {
+		s := a.decls[d]
+		if s == nil {
+			return "", false
+		}
+		switch s.kind {
+		case symbolFunction, symbolMethod, symbolClass:
+			if n, ok := a.arity(s, nil); ok {
+				return s.signature() + "\n" + arityText(n), true
+			}
+		}
+		return s.signature(), true
+	}
+	if n, ok := nativeNamed(t.file, t.lexeme); ok && t.tt == tokenTypeIdentifier {
+		return "native fun " + n.name + "\n" + arityText(n.params), true
+	}
+	return "", false
+}
+
+// arity returns the number of arguments a function, method or class takes.
+// A class takes the parameters of its init method, which may be inherited.
+// ok is false if a superclass cannot be resolved statically, because it is
+// not a class declaration or because the variable naming it is in assigned.
+func (a *analysis) arity(s *symbol, assigned map[token]bool) (n int, ok bool) {
+	seen := map[*symbol]bool{}
+	for s.kind == symbolClass {
+		seen[s] = true
+		for _, m := range s.children {
+			if m.name.lexeme == "init" {
+				return len(m.params), true
+			}
+		}
+		if s.superClass == nil {
+			return 0, true
+		}
+		d, ok := a.refs[*s.superClass]
+		if !ok || assigned[d] {
+			return 0, false
+		}
+		if s = a.decls[d]; s == nil || s.kind != symbolClass || seen[s] {
+			return 0, false
+		}
+	}
+	return len(s.params), true
+}
+
+func arityText(n int) string {
+	return "Takes " + countOf(n, "argument") + "."
+}
+
+// countOf returns n followed by noun, made plural unless n is 1.
+func countOf(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// visibleNames returns the names that may be referred to at the given
+// position: the natives, the globals of the script and the locals in scope
+// there, each with the symbol that declared it if it is part of the script.
+func (a *analysis) visibleNames(line, column int) map[string]*symbol {
+	names := map[string]*symbol{}
+	for name := range natives {
+		names[name] = nil
+	}
+	if strings.HasSuffix(a.file, testFileSuffix) {
+		for name := range assertions {
+			names[name] = nil
+		}
+	}
+	for _, s := range a.stmts {
+		if name := declaredName(s); name.lexeme != "" {
+			names[name.lexeme] = a.decls[name]
+		}
+	}
+	a.scopeNames(a.stmts, line, column, names)
+	return names
+}
+
+func declaredName(s stmt) token {
+	switch s := s.(type) {
+	case stmtVar:
+		return s.name
+	case stmtFunction:
+		return s.name
+	case stmtClass:
+		return s.name
+	}
+	return token{}
+}
+
+// scopeNames adds to names the locals declared in ss, or in the statements
+// nested in them, that are in scope at the given position.
+func (a *analysis) scopeNames(ss []stmt, line, column int, names map[string]*symbol) {
+	for _, s := range ss {
+		if !before(stmtStart(s), line, column) {
+			return
+		}
+		if name := declaredName(s); name.lexeme != "" {
+			names[name.lexeme] = a.decls[name]
+		}
+		endLine, endColumn := tokenEnd(stmtEnd(s))
+		if line > endLine || (line == endLine && column > endColumn) {
+			continue
+		}
+		switch s := s.(type) {
+		case stmtBlock:
+			a.scopeNames(s.statements, line, column, names)
+		case stmtIf:
+			a.scopeNames([]stmt{s.thenBranch}, line, column, names)
+			if s.elseBranch != nil {
+				a.scopeNames([]stmt{s.elseBranch}, line, column, names)
+			}
+		case stmtWhile:
+			a.scopeNames([]stmt{s.body}, line, column, names)
+		case stmtForIn:
+			names[s.name.lexeme] = a.decls[s.name]
+			a.scopeNames([]stmt{s.body}, line, column, names)
+		case stmtFor:
+			if s.initializer != nil {
+				a.scopeNames([]stmt{s.initializer}, line, column, names)
+			}
+			a.scopeNames([]stmt{s.body}, line, column, names)
+		case stmtFunction:
+			a.functionNames(s, line, column, names)
+		case stmtClass:
+			for _, m := range s.methods {
+				if before(m.name, line, column) && !before(m.body.close, line, column) {
+					names["this"] = nil
+					if s.superClass != nil {
+						names["super"] = nil
+					}
+					a.functionNames(m, line, column, names)
+				}
+			}
+		}
+	}
+}
+
+func (a *analysis) functionNames(s stmtFunction, line, column int, names map[string]*symbol) {
+	if !before(s.body.open, line, column) {
+		return
+	}
+	for _, p := range s.params {
+		names[p.lexeme] = a.decls[p]
+	}
+	a.scopeNames(s.body.statements, line, column, names)
+}
+
+// before reports whether t starts before the given position.
+func before(t token, line, column int) bool {
+	return t.line < line || (t.line == line && t.column < column)
+}
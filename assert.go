@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// assertions are the natives tests check their results with. Only the
+// interpreters that run tests define them.
+var assertions = map[string]nativeFunction{
+	"assert":       {name: "assert", params: 1, fn: nativeAssert},
+	"assertEqual":  {name: "assertEqual", params: 2, fn: nativeAssertEqual},
+	"assertThrows": {name: "assertThrows", params: 1, fn: nativeAssertThrows},
+}
+
+// withAssertions defines the assertion natives in the global environment.
+func withAssertions() option {
+	return func(i *interpreter) {
+		for name, n := range assertions {
+			i.globals.define(name, n)
+		}
+	}
+}
+
+// nativeNamed returns the native called name that a script in file can
+// refer to. Test files can also refer to the assertions.
+func nativeNamed(file, name string) (nativeFunction, bool) {
+	if n, ok := natives[name]; ok {
+		return n, true
+	}
+	if !strings.HasSuffix(file, testFileSuffix) {
+		return nativeFunction{}, false
+	}
+	n, ok := assertions[name]
+	return n, ok
+}
+
+// nativeAssert fails with a runtime error unless its argument is truthy.
+func nativeAssert(i *interpreter, paren token, args []interface{}) interface{} {
+	if !i.isTruthy(args[0]) {
+		reportRuntimeError(paren, "Assertion failed.")
+	}
+	return nil
+}
+
+// nativeAssertEqual fails with a runtime error unless its arguments, the
+// actual and the expected value, are equal. Lists and maps are compared
+// element by element.
+func nativeAssertEqual(i *interpreter, paren token, args []interface{}) interface{} {
+	if !i.deepEqual(args[0], args[1]) {
+		reportRuntimeError(paren, fmt.Sprintf("Expected %s but got %s.", debugValue(args[1]), debugValue(args[0])))
+	}
+	return nil
+}
+
+// nativeAssertThrows calls a function without arguments and fails with a
+// runtime error unless that call fails. It returns the message of the
+// error the call raised.
+func nativeAssertThrows(i *interpreter, paren token, args []interface{}) (message interface{}) {
+	f, ok := args[0].(callable)
+	if !ok || f.arity() != 0 {
+		reportRuntimeError(paren, "assertThrows() expects a function without parameters.")
+	}
+	failed := hadRuntimeError
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err, ok := r.(*runtimeError)
+				if !ok {
+					panic(r)
+				}
+				hadRuntimeError = failed
+				message = err.message
+			}
+		}()
+		f.call(i, paren, nil)
+	}()
+	if message == nil {
+		reportRuntimeError(paren, "Expected an error but none was raised.")
+	}
+	return message
+}
+
+func (i *interpreter) deepEqual(a, b interface{}) bool {
+	switch l := a.(type) {
+	case *loxList:
+		r, ok := b.(*loxList)
+		if !ok || len(l.elements) != len(r.elements) {
+			return false
+		}
+		for k := range l.elements {
+			if !i.deepEqual(l.elements[k], r.elements[k]) {
+				return false
+			}
+		}
+		return true
+	case *loxMap:
+		r, ok := b.(*loxMap)
+		if !ok || len(l.keys) != len(r.keys) {
+			return false
+		}
+		for _, k := range l.keys {
+			if _, ok := r.entries[k]; !ok || !i.deepEqual(l.get(k), r.get(k)) {
+				return false
+			}
+		}
+		return true
+	}
+	return i.isEqual(a, b)
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// astNode is a generic view of a syntax tree node used to dump it. Fields
+// and children keep the order in which they are added.
+type astNode struct {
+	kind       string
+	start, end token
+	// endLine and endColumn are just past the last token of the node.
+	endLine, endColumn int
+	fields             []astField
+}
+
+// astField is either a scalar attribute (value) or one or more children.
+type astField struct {
+	name     string
+	value    interface{}
+	children []*astNode
+	list     bool
+}
+
+func newASTNode(kind string, start, end token) *astNode {
+	n := &astNode{kind: kind, start: start, end: end}
+	n.endLine, n.endColumn = tokenEnd(end)
+	return n
+}
+
+func (n *astNode) attr(name string, v interface{}) *astNode {
+	n.fields = append(n.fields, astField{name: name, value: v})
+	return n
+}
+
+func (n *astNode) child(name string, c *astNode) *astNode {
+	if c != nil {
+		n.fields = append(n.fields, astField{name: name, children: []*astNode{c}})
+	}
+	return n
+}
+
+func (n *astNode) childList(name string, cs []*astNode) *astNode {
+	n.fields = append(n.fields, astField{name: name, children: cs, list: true})
+	return n
+}
+
+// astString is a string literal, which unlike names and operators is always
+// quoted in S-expressions.
+type astString string
+
+// astBuilder converts statements and expressions to astNodes. locals are
+// the scope distances recorded by the resolver.
+type astBuilder struct {
+	locals map[token]int
+}
+
+// depth returns the resolved scope distance of a variable reference, or
+// "global" for references the resolver left to the global environment.
+func (b *astBuilder) depth(name token) interface{} {
+	if d, ok := b.locals[name]; ok {
+		return d
+	}
+	return "global"
+}
+
+func (b *astBuilder) stmts(ss []stmt) []*astNode {
+	ret := make([]*astNode, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, b.stmt(s))
+	}
+	return ret
+}
+
+func (b *astBuilder) stmt(s stmt) *astNode {
+	if s == nil {
+		return nil
+	}
+	n := newASTNode("", stmtStart(s), stmtEnd(s))
+	switch s := s.(type) {
+	case stmtExpression:
+		n.kind = "Expression"
+		n.child("expr", b.expr(s.e))
+	case stmtPrint:
+		n.kind = "Print"
+		n.child("expr", b.expr(s.e))
+	case stmtVar:
+		n.kind = "Var"
+		n.attr("name", s.name.lexeme)
+		if s.constant() {
+			n.attr("const", true)
+		}
+		if s.typ != nil {
+			n.attr("type", s.typ.String())
+		}
+		n.child("init", b.expr(s.initializer))
+	case stmtBlock:
+		n.kind = "Block"
+		n.childList("statements", b.stmts(s.statements))
+	case stmtIf:
+		n.kind = "If"
+		n.child("condition", b.expr(s.condition))
+		n.child("then", b.stmt(s.thenBranch))
+		n.child("else", b.stmt(s.elseBranch))
+	case stmtWhile:
+		n.kind = "While"
+		n.child("condition", b.expr(s.condition))
+		n.child("body", b.stmt(s.body))
+	case stmtFor:
+		n.kind = "For"
+		n.child("init", b.stmt(s.initializer))
+		n.child("condition", b.expr(s.condition))
+		n.child("increment", b.expr(s.increment))
+		n.child("body", b.stmt(s.body))
+	case stmtForIn:
+		n.kind = "ForIn"
+		n.attr("name", s.name.lexeme)
+		n.child("iterable", b.expr(s.iterable))
+		n.child("body", b.stmt(s.body))
+	case stmtFunction:
+		n.kind = "Function"
+		b.function(n, s)
+	case stmtYield:
+		n.kind = "Yield"
+		n.child("value", b.expr(s.value))
+	case stmtReturn:
+		n.kind = "Return"
+		n.child("value", b.expr(s.value))
+	case stmtClass:
+		n.kind = "Class"
+		n.attr("name", s.name.lexeme)
+		if s.superClass != nil {
+			n.child("superclass", b.expr(*s.superClass))
+		}
+		ms := make([]*astNode, len(s.methods))
+		for k, m := range s.methods {
+			ms[k] = newASTNode("Method", m.name, m.body.close)
+			b.function(ms[k], m)
+		}
+		n.childList("methods", ms)
+	}
+	return n
+}
+
+func (b *astBuilder) function(n *astNode, s stmtFunction) {
+	ps := make([]interface{}, len(s.params))
+	for k, p := range s.params {
+		ps[k] = annotated(p, s.paramTypes[k])
+	}
+	n.attr("name", s.name.lexeme)
+	if s.generator {
+		n.attr("generator", true)
+	}
+	n.attr("params", ps)
+	if s.returnType != nil {
+		n.attr("returns", s.returnType.String())
+	}
+	n.childList("body", b.stmts(s.body.statements))
+}
+
+func (b *astBuilder) expr(e expr) *astNode {
+	if e == nil {
+		return nil
+	}
+	n := newASTNode("", exprStart(e), exprEnd(e))
+	switch e := e.(type) {
+	case exprBinary:
+		n.kind = "Binary"
+		n.attr("operator", e.operator.lexeme)
+		n.child("left", b.expr(e.left))
+		n.child("right", b.expr(e.right))
+	case exprLogical:
+		n.kind = "Logical"
+		n.attr("operator", e.operator.lexeme)
+		n.child("left", b.expr(e.left))
+		n.child("right", b.expr(e.right))
+	case exprGrouping:
+		n.kind = "Grouping"
+		n.child("expr", b.expr(e.exp))
+	case exprLiteral:
+		n.kind = "Literal"
+		if str, ok := e.value.(string); ok {
+			n.attr("value", astString(str))
+		} else {
+			n.attr("value", e.value)
+		}
+	case exprUnary:
+		n.kind = "Unary"
+		n.attr("operator", e.operator.lexeme)
+		n.child("right", b.expr(e.right))
+	case exprVariable:
+		n.kind = "Variable"
+		n.attr("name", e.name.lexeme)
+		n.attr("depth", b.depth(e.name))
+	case exprAssign:
+		n.kind = "Assign"
+		n.attr("name", e.name.lexeme)
+		n.attr("depth", b.depth(e.name))
+		n.child("value", b.expr(e.value))
+	case exprCall:
+		n.kind = "Call"
+		n.child("callee", b.expr(e.callee))
+		n.childList("args", b.exprs(e.args))
+	case exprSpawn:
+		n.kind = "Spawn"
+		n.child("call", b.expr(e.call))
+	case exprGet:
+		n.kind = "Get"
+		n.attr("name", e.name.lexeme)
+		n.child("object", b.expr(e.obj))
+	case exprSet:
+		n.kind = "Set"
+		n.attr("name", e.name.lexeme)
+		n.child("object", b.expr(e.obj))
+		n.child("value", b.expr(e.value))
+	case exprThis:
+		n.kind = "This"
+		n.attr("depth", b.depth(e.name))
+	case exprSuper:
+		n.kind = "Super"
+		n.attr("method", e.method.lexeme)
+		n.attr("depth", b.depth(e.keyword))
+	case exprIndex:
+		n.kind = "Index"
+		n.child("object", b.expr(e.obj))
+		n.child("index", b.expr(e.index))
+	case exprIndexSet:
+		n.kind = "IndexSet"
+		n.child("object", b.expr(e.obj))
+		n.child("index", b.expr(e.index))
+		n.child("value", b.expr(e.value))
+	case exprList:
+		n.kind = "List"
+		n.childList("elements", b.exprs(e.elements))
+	case exprMap:
+		n.kind = "Map"
+		n.childList("keys", b.exprs(e.keys))
+		n.childList("values", b.exprs(e.values))
+	}
+	return n
+}
+
+func (b *astBuilder) exprs(es []expr) []*astNode {
+	ret := make([]*astNode, len(es))
+	for k, e := range es {
+		ret[k] = b.expr(e)
+	}
+	return ret
+}
+
+func (n *astNode) span() string {
+	return fmt.Sprintf("%d:%d-%d:%d", n.start.line, n.start.column, n.endLine, n.endColumn)
+}
+
+// writeSExpr writes n as an indented S-expression such as
+//
+//	(Var @1:1-1:10 name=x
+//	  init: (Literal @1:9-1:10 value=1))
+func (n *astNode) writeSExpr(b *strings.Builder, indent int) {
+	b.WriteString("(" + n.kind + " @" + n.span())
+	for _, f := range n.fields {
+		if f.children == nil && !f.list {
+			b.WriteString(" " + f.name + "=" + sexprAtom(f.value))
+		}
+	}
+	for _, f := range n.fields {
+		if f.children == nil && !f.list {
+			continue
+		}
+		b.WriteString("\n" + strings.Repeat("  ", indent+1) + f.name + ":")
+		if f.list {
+			b.WriteString(" [")
+			for _, c := range f.children {
+				b.WriteString("\n" + strings.Repeat("  ", indent+2))
+				c.writeSExpr(b, indent+2)
+			}
+			b.WriteString("]")
+			continue
+		}
+		b.WriteString(" ")
+		f.children[0].writeSExpr(b, indent+1)
+	}
+	b.WriteString(")")
+}
+
+func sexprAtom(v interface{}) string {
+	switch v := v.(type) {
+	case astString:
+		return strconv.Quote(string(v))
+	case string:
+		if v == "" || strings.ContainsAny(v, " ()\"\n") {
+			return strconv.Quote(v)
+		}
+		return v
+	case []interface{}:
+		ss := make([]string, len(v))
+		for k, e := range v {
+			ss[k] = sexprAtom(e)
+		}
+		return "[" + strings.Join(ss, " ") + "]"
+	case int:
+		return strconv.Itoa(v)
+	}
+	return stringify(v)
+}
+
+// MarshalJSON encodes n as an object whose first keys are "kind" and
+// "span", followed by its fields in order.
+func (n *astNode) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(`{"kind":`)
+	writeJSON(&b, n.kind)
+	fmt.Fprintf(&b, `,"span":{"start":{"line":%d,"column":%d},"end":{"line":%d,"column":%d}}`,
+		n.start.line, n.start.column, n.endLine, n.endColumn)
+	for _, f := range n.fields {
+		b.WriteString(",")
+		writeJSON(&b, f.name)
+		b.WriteString(":")
+		switch {
+		case f.list:
+			if f.children == nil {
+				f.children = []*astNode{}
+			}
+			writeJSON(&b, f.children)
+		case f.children != nil:
+			writeJSON(&b, f.children[0])
+		default:
+			writeJSON(&b, jsonValue(f.value))
+		}
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		bs, _ = json.Marshal(err.Error())
+	}
+	b.Write(bs)
+}
+
+// jsonValue converts Lox literal values that encoding/json cannot represent
+// faithfully.
+func jsonValue(v interface{}) interface{} {
+	if f, ok := v.(float64); ok {
+		return json.Number(formatFloat(f))
+	}
+	return v
+}
+
+// astCommand implements "glox ast", which dumps the resolved syntax tree
+// of a script.
+func astCommand(args []string) int {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the tree as JSON instead of an S-expression")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox ast [-json] file.glox")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	name := fs.Arg(0)
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ts := (&scanner{source: string(bs), file: filepath.Base(name)}).scanTokens()
+	if hadParserError {
+		return 1
+	}
+	ss := (&parser{tokens: ts}).parse()
+	if hadParserError {
+		return 1
+	}
+	it := newInterpreter()
+	(&resolver{inter: it}).resolve(ss)
+	if hadResolutionError {
+		return 1
+	}
+
+	root := newASTNode("Program", token{line: 1, column: 1}, ts[len(ts)-1])
+	root.childList("statements", (&astBuilder{locals: it.locals}).stmts(ss))
+	if *asJSON {
+		out, err := json.MarshalIndent(root, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(out))
+		return 0
+	}
+	var b strings.Builder
+	root.writeSExpr(&b, 0)
+	fmt.Println(b.String())
+	return 0
+}
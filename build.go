@@ -0,0 +1,549 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runtimeSources are the sources of the loxrt package, which glox build
+// copies next to the programs it writes so that they build on their own.
+//
+//go:embed loxrt/*.go
+var runtimeSources embed.FS
+
+func buildCommand(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	out := fs.String("o", "", "write the Go module, holding main.go and a copy of loxrt, to this directory (default: the name of the script without its extension)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox build [-o dir] file.glox")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	p := compile(filepath.Base(name), string(bs))
+	if p == nil {
+		return 1
+	}
+	dir := *out
+	if strings.HasSuffix(dir, ".go") {
+		// A single file could not build: the program imports its copy of
+		// loxrt from the module.
+		fmt.Fprintf(os.Stderr, "glox build writes a Go module, so -o names a directory, not %s\n", dir)
+		return 2
+	}
+	if dir == "" {
+		dir = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	module := goModulePath(filepath.Base(dir))
+	src, err := translate(filepath.Base(name), module, p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := writeGoModule(dir, module, src); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// goModulePath returns the path of the module glox build writes to a
+// directory called name, which go build names the program after.
+func goModulePath(name string) string {
+	ret := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(ret, "_") == "" {
+		return "program"
+	}
+	return ret
+}
+
+// writeGoModule writes a module holding the program main.go and its copy
+// of the runtime to dir.
+func writeGoModule(dir, module string, src []byte) error {
+	if err := os.MkdirAll(filepath.Join(dir, "loxrt"), 0755); err != nil {
+		return err
+	}
+	mod := fmt.Sprintf("module %s\n\ngo 1.16\n", module)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), src, 0644); err != nil {
+		return err
+	}
+	files, err := runtimeSources.ReadDir("loxrt")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		bs, err := runtimeSources.ReadFile("loxrt/" + f.Name())
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "loxrt", f.Name()), bs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// goGenerator translates a resolved script to a Go program. Lox locals
+// become Go locals, so that Go closures capture them as Lox closures do,
+// and Lox globals become loxrt.Globals, which are bound late and fail when
+// used before they are defined.
+type goGenerator struct {
+	b      *strings.Builder
+	locals map[token]int
+
+	// globals are the names of the globals used, positions the variables
+	// holding the positions of the tokens errors are reported at.
+	globals   map[string]bool
+	positions map[string]token
+
+	// initializer is set in the body of an init method, whose returns
+	// return this. super names the variable holding the superclass of the
+	// innermost class.
+	initializer bool
+	super       string
+	// temporaries counts the Go variables introduced for classes.
+	temporaries int
+	// scopes counts the enclosing scopes; declarations outside of any are
+	// globals.
+	scopes int
+
+	// defined are the globals the script declares and unsupported the
+	// first use of each construct loxrt lacks.
+	defined     map[string]bool
+	unsupported []goUnsupported
+}
+
+// goUnsupported is a use of something glox build cannot translate. The use
+// of a native is fine if the script declares a global of the same name.
+type goUnsupported struct {
+	token  token
+	what   string
+	native bool
+}
+
+// goMissingNatives are the natives loxrt does not implement: Go programs
+// have no tasks.
+var goMissingNatives = map[string]bool{
+	"await": true, "channel": true, "send": true, "recv": true, "close": true, "select": true, "sleep": true,
+}
+
+// goExpr is a translated expression. read is set for plain reads of Go
+// variables, which Go may evaluate after the calls of the expressions
+// that follow them, and call for expressions that make calls.
+type goExpr struct {
+	code       string
+	read, call bool
+}
+
+// translate returns the Go program that runs a compiled script, importing
+// the runtime from the module at the given path.
+func translate(file, module string, p *program) ([]byte, error) {
+	g := &goGenerator{b: &strings.Builder{}, locals: p.locals, globals: map[string]bool{}, positions: map[string]token{},
+		defined: map[string]bool{}}
+	g.stmts(p.stmts)
+	body := g.b.String()
+	for _, u := range g.unsupported {
+		if !u.native || !g.defined[u.token.lexeme] {
+			return nil, fmt.Errorf("%s:%d:%d: glox build does not support %s", file, u.token.line, u.token.column, u.what)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Code generated by glox build from %s. DO NOT EDIT.\n\n", file)
+	fmt.Fprintf(&b, "package main\n\nimport %q\n\n", module+"/loxrt")
+	if len(g.globals) > 0 {
+		b.WriteString("var (\n")
+		for _, name := range sortedKeys(g.globals) {
+			fmt.Fprintf(&b, "g_%s = loxrt.NewGlobal(%q)\n", name, name)
+		}
+		b.WriteString(")\n\n")
+	}
+	if len(g.positions) > 0 {
+		names := make(map[string]bool, len(g.positions))
+		for name := range g.positions {
+			names[name] = true
+		}
+		b.WriteString("var (\n")
+		for _, name := range sortedKeys(names) {
+			t := g.positions[name]
+			fmt.Fprintf(&b, "%s = loxrt.Pos{Line: %d, Column: %d}\n", name, t.line, t.column)
+		}
+		b.WriteString(")\n\n")
+	}
+	fmt.Fprintf(&b, "func main() {\nloxrt.Run(%q, func() {\n%s})\n}\n", file, body)
+	return format.Source([]byte(b.String()))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (g *goGenerator) line(format string, args ...interface{}) {
+	fmt.Fprintf(g.b, format+"\n", args...)
+}
+
+// pos returns the variable holding the position of t.
+func (g *goGenerator) pos(t token) string {
+	name := fmt.Sprintf("p%d_%d", t.line, t.column)
+	g.positions[name] = t
+	return name
+}
+
+// inScope reports whether declarations are locals.
+func (g *goGenerator) inScope() bool {
+	return g.scopes > 0
+}
+
+func (g *goGenerator) isLocal(name token) bool {
+	_, ok := g.locals[name]
+	return ok
+}
+
+func (g *goGenerator) global(name token) string {
+	if goMissingNatives[name.lexeme] && !g.globals[name.lexeme] {
+		g.unsupported = append(g.unsupported, goUnsupported{name, name.lexeme + "()", true})
+	}
+	g.globals[name.lexeme] = true
+	return "g_" + name.lexeme
+}
+
+// definition returns the variable of a global the script declares.
+func (g *goGenerator) definition(name token) string {
+	g.defined[name.lexeme] = true
+	return g.global(name)
+}
+
+func local(name token) string {
+	return "l_" + name.lexeme
+}
+
+func (g *goGenerator) stmts(ss []stmt) {
+	for _, s := range ss {
+		g.stmt(s)
+	}
+}
+
+// declare declares the Go variable of a local.
+func (g *goGenerator) declare(name token, value string) {
+	g.line("var %s loxrt.Value = %s", local(name), value)
+	g.line("_ = %s", local(name))
+}
+
+func (g *goGenerator) stmt(s stmt) {
+	switch s := s.(type) {
+	case stmtExpression:
+		g.line("_ = %s", g.expr(s.e).code)
+	case stmtPrint:
+		g.line("loxrt.Print(%s)", g.expr(s.e).code)
+	case stmtVar:
+		value := "nil"
+		if s.initializer != nil {
+			value = g.expr(s.initializer).code
+		}
+		switch {
+		case g.inScope():
+			g.declare(s.name, value)
+		case s.constant():
+			g.line("%s.DefineConstant(%s, %s)", g.definition(s.name), g.pos(s.name), value)
+		default:
+			g.line("%s.Define(%s, %s)", g.definition(s.name), g.pos(s.name), value)
+		}
+	case stmtBlock:
+		g.line("{")
+		g.scopes++
+		g.stmts(s.statements)
+		g.scopes--
+		g.line("}")
+	case stmtIf:
+		g.line("if loxrt.Truthy(%s) {", g.expr(s.condition).code)
+		g.stmt(s.thenBranch)
+		if s.elseBranch != nil {
+			g.line("} else {")
+			g.stmt(s.elseBranch)
+		}
+		g.line("}")
+	case stmtWhile:
+		g.line("for loxrt.Truthy(%s) {", g.expr(s.condition).code)
+		g.stmt(s.body)
+		g.line("}")
+	case stmtFor:
+		// The initializer declares a single variable for the whole loop.
+		g.line("{")
+		g.scopes++
+		if s.initializer != nil {
+			g.stmt(s.initializer)
+		}
+		if s.condition != nil {
+			g.line("for loxrt.Truthy(%s) {", g.expr(s.condition).code)
+		} else {
+			g.line("for {")
+		}
+		g.stmt(s.body)
+		if s.increment != nil {
+			g.line("_ = %s", g.expr(s.increment).code)
+		}
+		g.line("}")
+		g.scopes--
+		g.line("}")
+	case stmtForIn:
+		g.temporaries++
+		next := fmt.Sprintf("n%d", g.temporaries)
+		g.line("for %s := %s; ; {", next, g.call("loxrt.Iterate", g.at(s.keyword), g.expr(s.iterable)).code)
+		g.line("v, ok := %s()", next)
+		g.line("if !ok {")
+		g.line("break")
+		g.line("}")
+		g.scopes++
+		g.declare(s.name, "v")
+		g.stmt(s.body)
+		g.scopes--
+		g.line("}")
+	case stmtFunction:
+		if g.inScope() {
+			// The function is declared first so that it can call itself.
+			g.declare(s.name, "nil")
+			g.line("%s = %s", local(s.name), g.function(s, false))
+		} else {
+			g.line("%s.Define(%s, %s)", g.definition(s.name), g.pos(s.name), g.function(s, false))
+		}
+	case stmtReturn:
+		switch {
+		case g.initializer:
+			g.line("return this")
+		case s.value != nil:
+			g.line("return %s", g.expr(s.value).code)
+		default:
+			g.line("return nil")
+		}
+	case stmtClass:
+		g.class(s)
+	}
+}
+
+// function returns the Go expression that creates a Lox function.
+func (g *goGenerator) function(s stmtFunction, initializer bool) string {
+	if s.generator {
+		g.unsupported = append(g.unsupported, goUnsupported{s.name, "generators", false})
+	}
+	enclosing, outer := g.b, g.initializer
+	g.b, g.initializer = &strings.Builder{}, initializer
+	g.scopes++
+	g.line("&loxrt.Function{Name: %q, Arity: %d, Fn: func(args []loxrt.Value) loxrt.Value {", s.name.lexeme, len(s.params))
+	for k, p := range s.params {
+		g.declare(p, fmt.Sprintf("args[%d]", k))
+	}
+	g.stmts(s.body.statements)
+	if initializer {
+		g.line("return this")
+	} else {
+		g.line("return nil")
+	}
+	g.b.WriteString("}}")
+	code := g.b.String()
+	g.scopes--
+	g.b, g.initializer = enclosing, outer
+	return code
+}
+
+func (g *goGenerator) class(s stmtClass) {
+	g.temporaries++
+	class := fmt.Sprintf("c%d", g.temporaries)
+	if g.inScope() {
+		g.declare(s.name, "nil")
+	}
+	g.line("{")
+	super := "nil"
+	if s.superClass != nil {
+		super = fmt.Sprintf("s%d", g.temporaries)
+		g.line("%s := loxrt.Superclass(%s, %s)", super, g.pos(s.superClass.name), g.expr(*s.superClass).code)
+	}
+	if !g.inScope() {
+		g.line("%s.Define(%s, nil)", g.definition(s.name), g.pos(s.name))
+	}
+	g.line("%s := loxrt.NewClass(%q, %s)", class, s.name.lexeme, super)
+	enclosing := g.super
+	g.super = super
+	for _, m := range s.methods {
+		g.line("%s.Methods[%q] = &loxrt.Method{Arity: %d, Bind: func(this *loxrt.Instance) *loxrt.Function {",
+			class, m.name.lexeme, len(m.params))
+		g.line("return %s", g.function(m, m.name.lexeme == "init"))
+		g.line("}}")
+	}
+	g.super = enclosing
+	if g.inScope() {
+		g.line("%s = %s", local(s.name), class)
+	} else {
+		g.line("%s.Assign(%s, %s)", g.global(s.name), g.pos(s.name), class)
+	}
+	g.line("}")
+}
+
+// call returns a call of a loxrt function with arguments. A variable read
+// as an argument is wrapped in loxrt.Read if a later argument makes a
+// call, since Go only orders calls among themselves.
+func (g *goGenerator) call(function string, args ...goExpr) goExpr {
+	codes := make([]string, len(args))
+	for k, a := range args {
+		codes[k] = a.code
+		if !a.read {
+			continue
+		}
+		for _, later := range args[k+1:] {
+			if later.call {
+				codes[k] = "loxrt.Read(" + a.code + ")"
+				break
+			}
+		}
+	}
+	return goExpr{code: function + "(" + strings.Join(codes, ", ") + ")", call: true}
+}
+
+// at is the argument for the position of t.
+func (g *goGenerator) at(t token) goExpr {
+	return goExpr{code: g.pos(t)}
+}
+
+func quoteArg(s string) goExpr {
+	return goExpr{code: strconv.Quote(s)}
+}
+
+// goBinaryOperators are the loxrt functions that implement the binary
+// operators that take a position.
+var goBinaryOperators = map[tokenType]string{
+	tokenTypePlus:           "loxrt.Add",
+	tokenTypeMinus:          "loxrt.Subtract",
+	tokenTypeStar:           "loxrt.Multiply",
+	tokenTypeSlash:          "loxrt.Divide",
+	tokenTypePercent:        "loxrt.Modulo",
+	tokenTypeAmpersand:      "loxrt.BitAnd",
+	tokenTypePipe:           "loxrt.BitOr",
+	tokenTypeCaret:          "loxrt.BitXor",
+	tokenTypeLessLess:       "loxrt.ShiftLeft",
+	tokenTypeGreaterGreater: "loxrt.ShiftRight",
+	tokenTypeLess:           "loxrt.Less",
+	tokenTypeLessEqual:      "loxrt.LessEqual",
+	tokenTypeGreater:        "loxrt.Greater",
+	tokenTypeGreaterEqual:   "loxrt.GreaterEqual",
+}
+
+func (g *goGenerator) expr(e expr) goExpr {
+	switch e := e.(type) {
+	case exprLiteral:
+		return goExpr{code: goLiteral(e.value)}
+	case exprGrouping:
+		return g.expr(e.exp)
+	case exprUnary:
+		if e.operator.tt == tokenTypeBang {
+			return g.call("loxrt.Not", g.expr(e.right))
+		}
+		return g.call("loxrt.Negate", g.at(e.operator), g.expr(e.right))
+	case exprBinary:
+		switch e.operator.tt {
+		case tokenTypeEqualEqual:
+			return g.call("loxrt.Equal", g.expr(e.left), g.expr(e.right))
+		case tokenTypeBangEqual:
+			return g.call("loxrt.NotEqual", g.expr(e.left), g.expr(e.right))
+		}
+		return g.call(goBinaryOperators[e.operator.tt], g.at(e.operator), g.expr(e.left), g.expr(e.right))
+	case exprLogical:
+		test := "!loxrt.Truthy(v)"
+		if e.operator.tt == tokenTypeOr {
+			test = "loxrt.Truthy(v)"
+		}
+		return goExpr{code: fmt.Sprintf("func() loxrt.Value {\nif v := %s; %s {\nreturn v\n}\nreturn %s\n}()",
+			g.expr(e.left).code, test, g.expr(e.right).code), call: true}
+	case exprVariable:
+		if g.isLocal(e.name) {
+			return goExpr{code: local(e.name), read: true}
+		}
+		return g.call(g.global(e.name)+".Get", g.at(e.name))
+	case exprThis:
+		return goExpr{code: "this", read: true}
+	case exprAssign:
+		if g.isLocal(e.name) {
+			return g.call("loxrt.Assign", goExpr{code: "&" + local(e.name)}, g.expr(e.value))
+		}
+		return g.call(g.global(e.name)+".Assign", g.at(e.name), g.expr(e.value))
+	case exprCall:
+		args := []goExpr{g.at(e.paren), g.expr(e.callee)}
+		for _, a := range e.args {
+			args = append(args, g.expr(a))
+		}
+		return g.call("loxrt.Call", args...)
+	case exprGet:
+		return g.call("loxrt.Get", g.at(e.name), g.expr(e.obj), quoteArg(e.name.lexeme))
+	case exprSet:
+		obj := g.call("loxrt.Fields", g.at(e.name), g.expr(e.obj))
+		return g.call(obj.code+".Set", quoteArg(e.name.lexeme), g.expr(e.value))
+	case exprSuper:
+		return g.call("loxrt.Super", g.at(e.method), goExpr{code: g.super}, goExpr{code: "this"}, quoteArg(e.method.lexeme))
+	case exprIndex:
+		return g.call("loxrt.Index", g.at(e.bracket), g.expr(e.obj), g.expr(e.index))
+	case exprIndexSet:
+		return g.call("loxrt.SetIndex", g.at(e.bracket), g.expr(e.obj), g.expr(e.index), g.expr(e.value))
+	case exprList:
+		elements := make([]goExpr, len(e.elements))
+		for k, x := range e.elements {
+			elements[k] = g.expr(x)
+		}
+		return g.call("loxrt.NewList", elements...)
+	case exprSpawn:
+		g.unsupported = append(g.unsupported, goUnsupported{e.keyword, "spawn", false})
+		return g.expr(e.call)
+	case exprMap:
+		m := goExpr{code: "loxrt.NewMap()", call: true}
+		for k := range e.keys {
+			m = g.call(m.code+".Key", g.at(e.brace), g.expr(e.keys[k]))
+			m = g.call(m.code+".Value", g.expr(e.values[k]))
+		}
+		return m
+	}
+	panic(fmt.Sprintf("cannot translate %T", e))
+}
+
+// goLiteral returns the Go expression for a literal value.
+func goLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return fmt.Sprintf("int64(%d)", v)
+	case float64:
+		return fmt.Sprintf("float64(%s)", strconv.FormatFloat(v, 'g', -1, 64))
+	case string:
+		return strconv.Quote(v)
+	}
+	panic(fmt.Sprintf("cannot translate literal %v", v))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMatchesInterpreter(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	files, err := filepath.Glob(filepath.Join("programs", "*.glox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempDir("", "glox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			dir := filepath.Join(tmp, strings.TrimSuffix(filepath.Base(f), ".glox"))
+			if r := glox(t, "build", "-o", dir, f); r.status != 0 {
+				t.Fatalf("glox build: %s", r.stderr)
+			}
+			build := exec.Command("go", "build", "-o", "program")
+			build.Dir = dir
+			if out, err := build.CombinedOutput(); err != nil {
+				t.Fatalf("go build: %v\n%s", err, out)
+			}
+
+			cmd := exec.Command(filepath.Join(dir, "program"))
+			var stdout, stderr bytes.Buffer
+			cmd.Stdout, cmd.Stderr = &stdout, &stderr
+			if err := cmd.Run(); err != nil {
+				if _, ok := err.(*exec.ExitError); !ok {
+					t.Fatal(err)
+				}
+			}
+			got := result{stdout: stdout.String(), stderr: stderr.String(), status: cmd.ProcessState.ExitCode()}
+			if want := glox(t, f); got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestBuildUnsupported checks that glox build rejects what Go programs
+// cannot run, rather than writing a program that behaves differently.
+func TestBuildUnsupported(t *testing.T) {
+	for _, c := range []struct {
+		name, source, want string
+	}{
+		{"generator", "fun *g() { yield 1; }\nprint 1;\n", "script.glox:1:6: glox build does not support generators\n"},
+		{"spawn", "fun f() {}\nspawn f();\n", "script.glox:2:1: glox build does not support spawn\n"},
+		{"channel", "var c = channel(1);\n", "script.glox:1:9: glox build does not support channel()\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "program")
+			r := glox(t, "build", "-o", dir, script(t, c.source))
+			if r.stderr != c.want || r.status != 1 {
+				t.Errorf("got %+v, want %q", r, c.want)
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("got %v for the output directory, want none", err)
+			}
+		})
+	}
+	r := glox(t, "build", "-o", filepath.Join(t.TempDir(), "out.go"), script(t, "print 1;\n"))
+	if want := "so -o names a directory"; r.status != 2 || !strings.Contains(r.stderr, want) {
+		t.Errorf("-o out.go: got %+v, want %q", r, want)
+	}
+}
@@ -5,6 +5,8 @@ type returnValue struct {
 }
 
 type callable interface {
-	call(i *interpreter, args []interface{}) interface{}
+	// call invokes the callable. paren is the closing parenthesis of the
+	// call expression and is used to report errors at the call site.
+	call(i *interpreter, paren token, args []interface{}) interface{}
 	arity() int
 }
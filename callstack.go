@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultMaxCallDepth keeps deep recursion well below the point where the
+// Go runtime would abort the whole process with a fatal stack overflow.
+const defaultMaxCallDepth = 10000
+
+// withMaxCallDepth limits how deeply Lox functions may recurse. Exceeding
+// the limit raises a "Stack overflow." runtime error.
+func withMaxCallDepth(n int) option {
+	return func(i *interpreter) {
+		i.maxCallDepth = n
+	}
+}
+
+// callFrame is an active call of a Lox function. The interpreter keeps a
+// stack of them so that runtime errors can show where they happened.
+type callFrame struct {
+	function string
+	// call is the closing parenthesis of the call expression in the caller.
+	call token
+}
+
+// traceLine is one entry of a Lox-level stack trace, innermost first.
+type traceLine struct {
+	function string
+	file     string
+	line     int
+}
+
+// maxTraceLines bounds how many lines of a stack trace are printed; the
+// middle of longer traces is elided.
+const maxTraceLines = 10
+
+func (i *interpreter) pushFrame(name string, call token) {
+	if i.maxCallDepth > 0 && i.depth+len(i.frames) >= i.maxCallDepth {
+		hadRuntimeError = true
+		panic(&runtimeError{
+			token:   call,
+			message: "Stack overflow.",
+			trace:   i.stackTrace(call),
+		})
+	}
+	i.frames = append(i.frames, callFrame{function: name, call: call})
+}
+
+func (i *interpreter) popFrame() {
+	i.frames = i.frames[:len(i.frames)-1]
+}
+
+// stackTrace returns the current Lox call stack, innermost first. t is the
+// token execution stopped at in the innermost frame.
+func (i *interpreter) stackTrace(t token) []traceLine {
+	ret := make([]traceLine, 0, len(i.frames)+1)
+	at := t
+	for k := len(i.frames) - 1; k >= 0; k-- {
+		ret = append(ret, traceLine{function: i.frames[k].function, file: at.file, line: at.line})
+		at = i.frames[k].call
+	}
+	return append(ret, traceLine{function: "<script>", file: at.file, line: at.line})
+}
+
+func formatTrace(trace []traceLine) string {
+	var b strings.Builder
+	for k, t := range trace {
+		if len(trace) > maxTraceLines && k == maxTraceLines/2 {
+			fmt.Fprintf(&b, "\n  ... %d more frames ...", len(trace)-maxTraceLines)
+		}
+		if len(trace) > maxTraceLines && k >= maxTraceLines/2 && k < len(trace)-maxTraceLines/2 {
+			continue
+		}
+		if t.file == "" {
+			fmt.Fprintf(&b, "\n  at %s (line %d)", t.function, t.line)
+		} else {
+			fmt.Fprintf(&b, "\n  at %s (%s:%d)", t.function, t.file, t.line)
+		}
+	}
+	return b.String()
+}
+
+// annotateTrace records the current call stack in a runtime error that is
+// propagating through a Lox function, unless an inner frame already did.
+func (i *interpreter) annotateTrace(raw interface{}) {
+	if err, ok := raw.(*runtimeError); ok && err.trace == nil && len(i.frames) > 0 {
+		err.trace = i.stackTrace(err.token)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Capabilities group the natives that reach outside the interpreter. A
+// script can only refer to the natives of the capabilities granted to it.
+const (
+	capabilityTime    = "time"
+	capabilityIORead  = "io.read"
+	capabilityIOWrite = "io.write"
+	capabilityEnv     = "env"
+	capabilityProcess = "process"
+)
+
+// allCapabilities returns every capability known to the interpreter.
+func allCapabilities() []string {
+	return []string{capabilityTime, capabilityIORead, capabilityIOWrite, capabilityEnv, capabilityProcess}
+}
+
+// withCapabilities grants only the given capabilities to scripts. By default
+// every capability is granted.
+func withCapabilities(caps ...string) option {
+	return func(i *interpreter) {
+		i.capabilities = map[string]bool{}
+		for _, c := range caps {
+			i.capabilities[c] = true
+		}
+	}
+}
+
+// withInput makes readLine read from r instead of the standard input.
+func withInput(r io.Reader) option {
+	return func(i *interpreter) {
+		i.stdin = bufio.NewReader(r)
+	}
+}
+
+// parseCapabilities parses a comma separated list of capabilities, as given
+// on the command line. "all" stands for every capability and "none" for none.
+func parseCapabilities(s string) ([]string, error) {
+	switch s {
+	case "all":
+		return allCapabilities(), nil
+	case "none", "":
+		return nil, nil
+	}
+	known := map[string]bool{}
+	for _, c := range allCapabilities() {
+		known[c] = true
+	}
+	var ret []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if !known[c] {
+			return nil, fmt.Errorf("unknown capability %q (known: %s)", c, strings.Join(allCapabilities(), ", "))
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// deniedNative returns the capability required by the native called name if
+// the interpreter does not grant it.
+func (i *interpreter) deniedNative(name string) (string, bool) {
+	n, ok := natives[name]
+	if !ok || n.capability == "" || i.capabilities[n.capability] {
+		return "", false
+	}
+	return n.capability, true
+}
+
+// exitStatus is raised (as a panic) by the exit native and stops the
+// script. interpret returns it so that the host can decide what to do.
+type exitStatus struct {
+	code int
+}
+
+func (e *exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// nativeReadLine reads a line from the input, or returns nil at its end.
+func nativeReadLine(i *interpreter, paren token, _ []interface{}) interface{} {
+	var line string
+	var err error
+	i.unlocked(func() {
+		i.stdinLock.Lock()
+		defer i.stdinLock.Unlock()
+		line, err = i.stdin.ReadString('\n')
+	})
+	if err == io.EOF && line == "" {
+		return nil
+	} else if err != nil && err != io.EOF {
+		reportRuntimeError(paren, err.Error())
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	i.checkStringLength(paren, len(line))
+	return line
+}
+
+// nativeReadFile returns the contents of a file.
+func nativeReadFile(i *interpreter, paren token, args []interface{}) interface{} {
+	name, ok := args[0].(string)
+	if !ok {
+		reportRuntimeError(paren, "readFile() expects a file name.")
+	}
+	var bs []byte
+	var err error
+	i.unlocked(func() {
+		bs, err = ioutil.ReadFile(name)
+	})
+	if err != nil {
+		reportRuntimeError(paren, err.Error())
+	}
+	i.checkStringLength(paren, len(bs))
+	return string(bs)
+}
+
+// nativeWriteFile replaces the contents of a file with a string.
+func nativeWriteFile(i *interpreter, paren token, args []interface{}) interface{} {
+	name, ok := args[0].(string)
+	if !ok {
+		reportRuntimeError(paren, "writeFile() expects a file name.")
+	}
+	contents := []byte(stringify(args[1]))
+	var err error
+	i.unlocked(func() {
+		err = ioutil.WriteFile(name, contents, 0644)
+	})
+	if err != nil {
+		reportRuntimeError(paren, err.Error())
+	}
+	return nil
+}
+
+// nativeGetenv returns the value of an environment variable, or nil if it
+// is not set.
+func nativeGetenv(_ *interpreter, paren token, args []interface{}) interface{} {
+	name, ok := args[0].(string)
+	if !ok {
+		reportRuntimeError(paren, "getenv() expects a variable name.")
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return nil
+}
+
+// nativeExit stops the script with the given status code.
+func nativeExit(_ *interpreter, paren token, args []interface{}) interface{} {
+	code, ok := args[0].(int64)
+	if !ok {
+		reportRuntimeError(paren, "exit() expects an integer status code.")
+	}
+	panic(&exitStatus{code: int(code)})
+}
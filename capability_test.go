@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	for _, c := range []struct {
+		flag string
+		want []string
+		err  string
+	}{
+		{flag: "all", want: allCapabilities()},
+		{flag: "none"},
+		{flag: ""},
+		{flag: "time", want: []string{capabilityTime}},
+		{flag: "time, io.read,env", want: []string{capabilityTime, capabilityIORead, capabilityEnv}},
+		{flag: "clock", err: `unknown capability "clock"`},
+		{flag: "time,none", err: `unknown capability "none"`},
+	} {
+		got, err := parseCapabilities(c.flag)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: got the error %v, want %q", c.flag, err, c.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %v, %v, want %v", c.flag, got, err, c.want)
+		}
+	}
+}
+
+const clockScript = "print \"first\";\nprint clock() > 0;\n"
+
+// TestDeniedNative checks that a script using a native it is not granted
+// fails before it runs, so that it prints nothing but the error.
+func TestDeniedNative(t *testing.T) {
+	r := glox(t, "-caps", "env,io.read", script(t, clockScript))
+	if want := "'clock' requires the 'time' capability"; strings.Contains(r.stdout, "first") || r.status == 0 || !strings.Contains(r.stdout, want) {
+		t.Errorf("got %+v, want only %q", r, want)
+	}
+	if r := glox(t, "-caps", "time", script(t, clockScript)); r.stdout != "first\ntrue\n" || r.status != 0 {
+		t.Errorf("granted: got %+v", r)
+	}
+	if r := glox(t, "-caps", "bogus", script(t, clockScript)); r.stdout != "" || r.status == 0 || !strings.Contains(r.stderr, `unknown capability "bogus"`) {
+		t.Errorf("unknown capability: got %+v", r)
+	}
+}
+
+func TestShadowedNative(t *testing.T) {
+	r := glox(t, "-caps", "none", script(t, `var clock = 1;
+fun f() { var getenv = 2; return getenv; }
+print clock + f();
+fun exit(x) { return x; }
+print exit(3);
+`))
+	if r.stdout != "3\n3\n" || r.status != 0 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+// TestCompiledCapabilities checks that a compiled script is held to the
+// capabilities of the run, not those of its compilation.
+func TestCompiledCapabilities(t *testing.T) {
+	compiled := filepath.Join(t.TempDir(), "clock.gloxc")
+	if r := glox(t, "compile", "-o", compiled, script(t, clockScript)); r.status != 0 {
+		t.Fatalf("compile: got %+v", r)
+	}
+	r := glox(t, "-caps", "none", compiled)
+	if want := "'clock' requires the 'time' capability"; strings.Contains(r.stdout, "first") || r.status == 0 || !strings.Contains(r.stdout, want) {
+		t.Errorf("got %+v, want only %q", r, want)
+	}
+	if r := glox(t, "-caps", "time", compiled); r.stdout != "first\ntrue\n" || r.status != 0 {
+		t.Errorf("granted: got %+v", r)
+	}
+}
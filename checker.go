@@ -0,0 +1,958 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type typeKind int
+
+const (
+	typeAny typeKind = iota
+	typeNil
+	typeBool
+	typeNumber
+	typeString
+	typeList
+	typeMap
+	typeFunction
+	typeClass
+	typeInstance
+)
+
+// builtinTypes are the types that annotations name, besides classes, which
+// stand for their instances.
+var builtinTypes = map[string]typeKind{
+	"any":    typeAny,
+	"nil":    typeNil,
+	"bool":   typeBool,
+	"number": typeNumber,
+	"string": typeString,
+	"list":   typeList,
+	"map":    typeMap,
+	"fun":    typeFunction,
+}
+
+// loxType is the static type of a value. A function carries its signature
+// if it is known, and a class or an instance its class. Values of type any
+// are not checked.
+type loxType struct {
+	kind     typeKind
+	nullable bool
+	sig      *signature
+	class    *classInfo
+}
+
+var (
+	anyType    = loxType{kind: typeAny}
+	nilType    = loxType{kind: typeNil}
+	boolType   = loxType{kind: typeBool}
+	numberType = loxType{kind: typeNumber}
+	stringType = loxType{kind: typeString}
+	listType   = loxType{kind: typeList}
+	mapType    = loxType{kind: typeMap}
+)
+
+func (t loxType) String() string {
+	var s string
+	switch t.kind {
+	case typeAny:
+		return "any"
+	case typeNil:
+		return "nil"
+	case typeClass:
+		s = "class " + t.class.name
+	case typeInstance:
+		s = t.class.name
+	default:
+		for name, k := range builtinTypes {
+			if k == t.kind {
+				s = name
+			}
+		}
+	}
+	if t.nullable {
+		s += "?"
+	}
+	return s
+}
+
+// signature is the type of a function. Unless it is annotated, the return
+// type is inferred from the body and is any until the body is checked.
+type signature struct {
+	params []loxType
+	ret    loxType
+}
+
+// classInfo is a class with its methods and the types of the fields its
+// methods assign to.
+type classInfo struct {
+	name    string
+	super   *classInfo
+	methods map[string]*signature
+	fields  map[string]loxType
+}
+
+func (c *classInfo) method(name string) *signature {
+	for ; c != nil; c = c.super {
+		if m, ok := c.methods[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *classInfo) field(name string) (loxType, bool) {
+	for ; c != nil; c = c.super {
+		if t, ok := c.fields[name]; ok {
+			return t, true
+		}
+	}
+	return anyType, false
+}
+
+func (c *classInfo) isSubclassOf(super *classInfo) bool {
+	for ; c != nil; c = c.super {
+		if c == super {
+			return true
+		}
+	}
+	return false
+}
+
+// assignable reports whether a value of type v can be used where one of
+// type t is expected.
+func assignable(v, t loxType) bool {
+	switch {
+	case v.kind == typeAny || t.kind == typeAny:
+		return true
+	case v.kind == typeNil:
+		return t.nullable || t.kind == typeNil
+	case v.nullable && !t.nullable, v.kind != t.kind:
+		return false
+	case v.kind == typeClass, v.kind == typeInstance:
+		return v.class.isSubclassOf(t.class)
+	}
+	return true
+}
+
+// join returns the type of a value that is of type a or of type b.
+func join(a, b loxType) loxType {
+	switch {
+	case a.kind == typeNil && b.kind != typeAny:
+		b.nullable = true
+		return b
+	case b.kind == typeNil && a.kind != typeAny:
+		a.nullable = true
+		return a
+	case a.kind != b.kind:
+		return anyType
+	case a.kind == typeClass, a.kind == typeInstance:
+		if !a.class.isSubclassOf(b.class) {
+			if !b.class.isSubclassOf(a.class) {
+				return anyType
+			}
+			a.class = b.class
+		}
+	case a.kind == typeFunction && a.sig != b.sig:
+		a.sig = nil
+	}
+	a.nullable = a.nullable || b.nullable
+	return a
+}
+
+// nativeSignatures are the types of the native functions.
+var nativeSignatures = map[string]*signature{
+	"len":          {params: []loxType{anyType}, ret: numberType},
+	"str":          {params: []loxType{anyType}, ret: stringType},
+	"push":         {params: []loxType{listType, anyType}, ret: nilType},
+	"keys":         {params: []loxType{mapType}, ret: listType},
+	"assert":       {params: []loxType{anyType}, ret: nilType},
+	"assertEqual":  {params: []loxType{anyType, anyType}, ret: nilType},
+	"assertThrows": {params: []loxType{{kind: typeFunction}}, ret: stringType},
+	"await":        {params: []loxType{anyType}, ret: anyType},
+	"channel":      {params: []loxType{numberType}, ret: anyType},
+	"send":         {params: []loxType{anyType, anyType}, ret: nilType},
+	"recv":         {params: []loxType{anyType}, ret: anyType},
+	"close":        {params: []loxType{anyType}, ret: nilType},
+	"select":       {params: []loxType{listType}, ret: listType},
+	"clock":        {ret: numberType},
+	"readLine":     {ret: loxType{kind: typeString, nullable: true}},
+	"readFile":     {params: []loxType{stringType}, ret: stringType},
+	"writeFile":    {params: []loxType{stringType, stringType}, ret: nilType},
+	"getenv":       {params: []loxType{stringType}, ret: loxType{kind: typeString, nullable: true}},
+	"sleep":        {params: []loxType{numberType}, ret: nilType},
+	"exit":         {params: []loxType{numberType}, ret: nilType},
+}
+
+type typeError struct {
+	token   token
+	message string
+}
+
+// checker infers the types of the expressions of a resolved script and
+// reports the operations that would fail on them. Annotated declarations
+// have the annotated type, and variables that are never assigned after
+// their declaration the type of their initializer. Everything else is of
+// type any and goes unchecked, so unannotated code mostly passes.
+type checker struct {
+	a      *analysis
+	errors []typeError
+
+	// classes are the classes of the script by name, which annotations
+	// refer to.
+	classes map[string]*classInfo
+	// declared are the classes by the token that declares them.
+	declared map[token]*classInfo
+	// signatures are the functions and methods by the token that names
+	// them.
+	signatures map[token]*signature
+	// types are the types of the declarations checked so far.
+	types map[token]loxType
+	// assigned records the declarations assigned after their declaration.
+	assigned map[token]bool
+	// narrowed records the declarations known not to be nil in the code
+	// being checked.
+	narrowed map[token]bool
+
+	// current is the innermost function being checked, or nil at the top
+	// level, and class the innermost class.
+	current *checkedFunction
+	class   *classInfo
+}
+
+type checkedFunction struct {
+	name      token
+	sig       *signature
+	annotated bool
+	returns   []loxType
+}
+
+// check returns the type errors of an analyzed script, in source order.
+func check(a *analysis) []typeError {
+	c := &checker{
+		a:          a,
+		classes:    map[string]*classInfo{},
+		declared:   map[token]*classInfo{},
+		signatures: map[token]*signature{},
+		types:      map[token]loxType{},
+		assigned:   map[token]bool{},
+		narrowed:   map[token]bool{},
+	}
+	c.declare(a.stmts)
+	c.stmts(a.stmts)
+	sort.SliceStable(c.errors, func(i, j int) bool {
+		return before(c.errors[i].token, c.errors[j].token.line, c.errors[j].token.column)
+	})
+	return c.errors
+}
+
+func (c *checker) errorf(t token, format string, args ...interface{}) {
+	c.errors = append(c.errors, typeError{t, fmt.Sprintf(format, args...)})
+}
+
+// declare records the classes, the signatures and the assignments of the
+// whole script, so that code can use what is declared after it.
+func (c *checker) declare(ss []stmt) {
+	walkStmts(ss, func(s stmt) {
+		if s, ok := s.(stmtClass); ok {
+			t := &classInfo{name: s.name.lexeme, methods: map[string]*signature{}, fields: map[string]loxType{}}
+			c.classes[t.name] = t
+			c.declared[s.name] = t
+		}
+		walkExprs(s, func(e expr) {
+			if e, ok := e.(exprAssign); ok {
+				if d, ok := c.a.refs[e.name]; ok {
+					c.assigned[d] = true
+				}
+			}
+		})
+	})
+	walkStmts(ss, func(s stmt) {
+		switch s := s.(type) {
+		case stmtFunction:
+			c.signatures[s.name] = c.signature(s)
+		case stmtClass:
+			if s.superClass != nil {
+				c.declared[s.name].super = c.declared[c.a.refs[s.superClass.name]]
+			}
+		}
+	})
+	walkStmts(ss, func(s stmt) {
+		switch s := s.(type) {
+		case stmtFunction:
+			if !c.assigned[s.name] {
+				c.types[s.name] = loxType{kind: typeFunction, sig: c.signatures[s.name]}
+			}
+		case stmtClass:
+			t := c.declared[s.name]
+			for _, m := range s.methods {
+				t.methods[m.name.lexeme] = c.signatures[m.name]
+			}
+			if init, ok := t.methods["init"]; ok {
+				init.ret = loxType{kind: typeInstance, class: t}
+			}
+			if !c.assigned[s.name] {
+				c.types[s.name] = loxType{kind: typeClass, class: t}
+			}
+		}
+	})
+}
+
+func (c *checker) signature(s stmtFunction) *signature {
+	sig := &signature{params: make([]loxType, len(s.params)), ret: anyType}
+	for k := range s.params {
+		sig.params[k] = c.annotation(s.paramTypes[k])
+	}
+	if s.returnType != nil && !s.generator {
+		sig.ret = c.annotation(s.returnType)
+	}
+	return sig
+}
+
+// annotation returns the type an annotation names, which is any if there
+// is no annotation.
+func (c *checker) annotation(t *typeAnnotation) loxType {
+	if t == nil {
+		return anyType
+	}
+	var ret loxType
+	if k, ok := builtinTypes[t.name.lexeme]; ok {
+		ret.kind = k
+	} else if class, ok := c.classes[t.name.lexeme]; ok {
+		ret = loxType{kind: typeInstance, class: class}
+	} else {
+		c.errorf(t.name, "unknown type '%s'", t.name.lexeme)
+		return anyType
+	}
+	ret.nullable = t.nullable && ret.kind != typeAny && ret.kind != typeNil
+	return ret
+}
+
+func (c *checker) stmts(ss []stmt) {
+	for _, s := range ss {
+		c.stmt(s)
+	}
+}
+
+func (c *checker) stmt(s stmt) {
+	switch s := s.(type) {
+	case stmtExpression:
+		c.expr(s.e)
+	case stmtPrint:
+		c.expr(s.e)
+	case stmtVar:
+		t := nilType
+		if s.initializer != nil {
+			t = c.expr(s.initializer)
+		}
+		switch {
+		case s.typ != nil:
+			declared := c.annotation(s.typ)
+			if s.initializer == nil && !assignable(nilType, declared) {
+				c.errorf(s.name, "'%s' of type %s is declared without a value", s.name.lexeme, declared)
+			} else if s.initializer != nil && !assignable(t, declared) {
+				c.errorf(exprStart(s.initializer), "cannot assign %s to '%s' of type %s", t, s.name.lexeme, declared)
+			}
+			c.types[s.name] = declared
+		case c.assigned[s.name]:
+			c.types[s.name] = anyType
+		default:
+			c.types[s.name] = t
+		}
+	case stmtBlock:
+		c.stmts(s.statements)
+	case stmtIf:
+		c.expr(s.condition)
+		then, otherwise := c.nonNil(s.condition)
+		c.narrow(then, func() { c.stmt(s.thenBranch) })
+		if s.elseBranch != nil {
+			c.narrow(otherwise, func() { c.stmt(s.elseBranch) })
+		}
+	case stmtWhile:
+		c.expr(s.condition)
+		then, _ := c.nonNil(s.condition)
+		c.narrow(then, func() { c.stmt(s.body) })
+	case stmtFor:
+		if s.initializer != nil {
+			c.stmt(s.initializer)
+		}
+		var then token
+		if s.condition != nil {
+			c.expr(s.condition)
+			then, _ = c.nonNil(s.condition)
+		}
+		c.narrow(then, func() {
+			c.stmt(s.body)
+			if s.increment != nil {
+				c.expr(s.increment)
+			}
+		})
+	case stmtForIn:
+		if t := c.element(c.expr(s.iterable), s.keyword); !c.assigned[s.name] {
+			c.types[s.name] = t
+		}
+		c.stmt(s.body)
+	case stmtYield:
+		if s.value != nil {
+			c.expr(s.value)
+		}
+	case stmtFunction:
+		c.function(s)
+	case stmtReturn:
+		t := nilType
+		if s.value != nil {
+			t = c.expr(s.value)
+		}
+		f := c.current
+		if f == nil {
+			return
+		}
+		f.returns = append(f.returns, t)
+		if f.annotated && !assignable(t, f.sig.ret) {
+			at := s.keyword
+			if s.value != nil {
+				at = exprStart(s.value)
+			}
+			c.errorf(at, "'%s' must return %s, got %s", f.name.lexeme, f.sig.ret, t)
+		}
+	case stmtClass:
+		if s.superClass != nil {
+			c.expr(*s.superClass)
+		}
+		enclosing := c.class
+		c.class = c.declared[s.name]
+		for _, m := range s.methods {
+			c.function(m)
+		}
+		c.class = enclosing
+	}
+}
+
+func (c *checker) function(s stmtFunction) {
+	sig := c.signatures[s.name]
+	for k, p := range s.params {
+		c.types[p] = sig.params[k]
+	}
+	enclosing := c.current
+	c.current = &checkedFunction{name: s.name, sig: sig, annotated: s.returnType != nil && !s.generator}
+	c.stmts(s.body.statements)
+	f := c.current
+	c.current = enclosing
+
+	switch {
+	case c.class != nil && c.class.methods["init"] == sig:
+	case s.generator:
+		if s.returnType != nil {
+			c.errorf(s.name, "generator '%s' returns an iterator and cannot be annotated", s.name.lexeme)
+		}
+		sig.ret = anyType
+	case f.annotated:
+		if !alwaysReturns(s.body.statements) && !assignable(nilType, sig.ret) {
+			c.errorf(s.name, "'%s' may end without returning %s", s.name.lexeme, sig.ret)
+		}
+	default:
+		ret := nilType
+		if len(f.returns) > 0 {
+			ret = f.returns[0]
+			for _, t := range f.returns[1:] {
+				ret = join(ret, t)
+			}
+			if !alwaysReturns(s.body.statements) {
+				ret = join(ret, nilType)
+			}
+		}
+		sig.ret = ret
+	}
+}
+
+// nonNil returns the variables a condition proves not to be nil when it
+// is true and when it is false, if any.
+func (c *checker) nonNil(e expr) (then, otherwise token) {
+	switch e := e.(type) {
+	case exprVariable:
+		then = c.a.refs[e.name]
+	case exprBinary:
+		if e.operator.tt != tokenTypeBangEqual && e.operator.tt != tokenTypeEqualEqual {
+			return
+		}
+		v, ok := e.left.(exprVariable)
+		other := e.right
+		if !ok {
+			v, ok = e.right.(exprVariable)
+			other = e.left
+		}
+		if l, isNil := other.(exprLiteral); !ok || !isNil || l.value != nil {
+			return
+		}
+		if e.operator.tt == tokenTypeBangEqual {
+			then = c.a.refs[v.name]
+		} else {
+			otherwise = c.a.refs[v.name]
+		}
+	}
+	return
+}
+
+// narrow runs f treating the declaration d as not nil.
+func (c *checker) narrow(d token, f func()) {
+	if d.lexeme == "" || c.narrowed[d] {
+		f()
+		return
+	}
+	c.narrowed[d] = true
+	f()
+	delete(c.narrowed, d)
+}
+
+func (c *checker) expr(e expr) loxType {
+	switch e := e.(type) {
+	case exprLiteral:
+		switch e.value.(type) {
+		case nil:
+			return nilType
+		case bool:
+			return boolType
+		case int64, float64:
+			return numberType
+		case string:
+			return stringType
+		}
+	case exprGrouping:
+		return c.expr(e.exp)
+	case exprVariable:
+		return c.variable(e.name)
+	case exprAssign:
+		t := c.expr(e.value)
+		if d, ok := c.a.refs[e.name]; ok {
+			if want, ok := c.types[d]; ok && !assignable(t, want) {
+				c.errorf(exprStart(e.value), "cannot assign %s to '%s' of type %s", t, e.name.lexeme, want)
+			}
+		}
+		return t
+	case exprUnary:
+		t := c.expr(e.right)
+		if e.operator.tt == tokenTypeBang {
+			return boolType
+		}
+		if !c.is(t, typeNumber) {
+			c.errorf(e.operator, "operand of '%s' must be a number, got %s", e.operator.lexeme, t)
+		}
+		return numberType
+	case exprBinary:
+		return c.binary(e)
+	case exprLogical:
+		l := c.expr(e.left)
+		var r loxType
+		if then, _ := c.nonNil(e.left); e.operator.tt == tokenTypeAnd {
+			c.narrow(then, func() { r = c.expr(e.right) })
+		} else {
+			r = c.expr(e.right)
+		}
+		if e.operator.tt == tokenTypeOr {
+			// The left operand is the result only if it is not nil.
+			if l.kind == typeNil {
+				return r
+			}
+			l.nullable = false
+		}
+		return join(l, r)
+	case exprCall:
+		return c.call(e)
+	case exprSpawn:
+		c.call(e.call)
+		return anyType
+	case exprGet:
+		obj := c.expr(e.obj)
+		if !c.instance(obj, e.name) {
+			return anyType
+		}
+		if t, ok := obj.class.field(e.name.lexeme); ok {
+			return t
+		}
+		if m := obj.class.method(e.name.lexeme); m != nil {
+			return loxType{kind: typeFunction, sig: m}
+		}
+	case exprSet:
+		obj := c.expr(e.obj)
+		t := c.expr(e.value)
+		if !c.instance(obj, e.name) {
+			return t
+		}
+		want, ok := obj.class.field(e.name.lexeme)
+		if _, this := e.obj.(exprThis); this && obj.class == c.class {
+			// The methods of a class decide the types of its fields.
+			if ok {
+				t = join(want, t)
+			}
+			c.class.fields[e.name.lexeme] = t
+		} else if ok && !assignable(t, want) {
+			c.errorf(exprStart(e.value), "field '%s' of %s is %s, got %s", e.name.lexeme, obj.class.name, want, t)
+		}
+		return t
+	case exprThis:
+		if c.class != nil {
+			return loxType{kind: typeInstance, class: c.class}
+		}
+	case exprSuper:
+		if c.class != nil {
+			if m := c.class.super.method(e.method.lexeme); m != nil {
+				return loxType{kind: typeFunction, sig: m}
+			}
+		}
+	case exprIndex:
+		obj := c.expr(e.obj)
+		index := c.expr(e.index)
+		return c.index(obj, index, e.bracket, false)
+	case exprIndexSet:
+		obj := c.expr(e.obj)
+		index := c.expr(e.index)
+		c.index(obj, index, e.bracket, true)
+		return c.expr(e.value)
+	case exprList:
+		for _, x := range e.elements {
+			c.expr(x)
+		}
+		return listType
+	case exprMap:
+		for k := range e.keys {
+			c.expr(e.keys[k])
+			c.expr(e.values[k])
+		}
+		return mapType
+	}
+	return anyType
+}
+
+func (c *checker) variable(name token) loxType {
+	d, ok := c.a.refs[name]
+	if !ok {
+		if _, ok := nativeNamed(name.file, name.lexeme); ok && nativeSignatures[name.lexeme] != nil {
+			sig := nativeSignatures[name.lexeme]
+			return loxType{kind: typeFunction, sig: sig}
+		}
+		return anyType
+	}
+	t, ok := c.types[d]
+	if !ok {
+		return anyType
+	}
+	if c.narrowed[d] {
+		t.nullable = false
+	}
+	return t
+}
+
+// is reports whether a value of type t may be of kind k. Values that may
+// be nil are not.
+func (c *checker) is(t loxType, k typeKind) bool {
+	return t.kind == typeAny || (t.kind == k && !t.nullable)
+}
+
+func (c *checker) binary(e exprBinary) loxType {
+	l, r := c.expr(e.left), c.expr(e.right)
+	switch e.operator.tt {
+	case tokenTypeEqualEqual, tokenTypeBangEqual:
+		return boolType
+	case tokenTypePlus:
+		for _, k := range []typeKind{typeNumber, typeString} {
+			if c.is(l, k) && c.is(r, k) {
+				if l.kind == typeAny {
+					return r
+				}
+				return l
+			}
+		}
+		c.errorf(e.operator, "operands of '+' must be two numbers or two strings, got %s and %s", l, r)
+		return anyType
+	}
+	if !c.is(l, typeNumber) || !c.is(r, typeNumber) {
+		c.errorf(e.operator, "operands of '%s' must be numbers, got %s and %s", e.operator.lexeme, l, r)
+	}
+	switch e.operator.tt {
+	case tokenTypeGreater, tokenTypeGreaterEqual, tokenTypeLess, tokenTypeLessEqual:
+		return boolType
+	}
+	return numberType
+}
+
+func (c *checker) call(e exprCall) loxType {
+	callee := c.expr(e.callee)
+	args := make([]loxType, len(e.args))
+	for k, a := range e.args {
+		args[k] = c.expr(a)
+	}
+	var sig *signature
+	ret := anyType
+	switch {
+	case callee.kind == typeAny:
+		return anyType
+	case callee.nullable:
+		c.errorf(e.paren, "cannot call %s, which may be nil", callee)
+		return anyType
+	case callee.kind == typeFunction:
+		if sig = callee.sig; sig == nil {
+			return anyType
+		}
+		ret = sig.ret
+	case callee.kind == typeClass:
+		if sig = callee.class.method("init"); sig == nil {
+			sig = &signature{}
+		}
+		ret = loxType{kind: typeInstance, class: callee.class}
+	default:
+		c.errorf(e.paren, "cannot call %s", callee)
+		return anyType
+	}
+
+	name := calleeName(e.callee)
+	if len(args) != len(sig.params) {
+		c.errorf(e.paren, "'%s' expects %s but is called with %d", name, countOf(len(sig.params), "argument"), len(args))
+		return ret
+	}
+	for k, t := range args {
+		if !assignable(t, sig.params[k]) {
+			c.errorf(exprStart(e.args[k]), "argument %d of '%s' must be %s, got %s", k+1, name, sig.params[k], t)
+		}
+	}
+	return ret
+}
+
+// calleeName names what an expression calls in messages.
+func calleeName(e expr) string {
+	switch e := e.(type) {
+	case exprVariable:
+		return e.name.lexeme
+	case exprGet:
+		return e.name.lexeme
+	case exprSuper:
+		return "super." + e.method.lexeme
+	case exprGrouping:
+		return calleeName(e.exp)
+	}
+	return "function"
+}
+
+// instance reports whether a value of type t is known to be an instance,
+// and reports an error if it cannot be one.
+func (c *checker) instance(t loxType, name token) bool {
+	switch {
+	case t.kind == typeAny:
+	case t.kind != typeInstance:
+		c.errorf(name, "only instances have properties, got %s", t)
+	case t.nullable:
+		c.errorf(name, "cannot access property '%s' of %s, which may be nil", name.lexeme, t)
+	default:
+		return true
+	}
+	return false
+}
+
+// element returns the type of the loop variable of a for-in loop over a
+// value of type t, and reports an error if such a value cannot be iterated.
+func (c *checker) element(t loxType, keyword token) loxType {
+	switch {
+	case t.kind == typeAny:
+	case t.nullable:
+		c.errorf(keyword, "cannot iterate over %s, which may be nil", t)
+	case t.kind == typeString:
+		return stringType
+	case t.kind != typeList && t.kind != typeMap:
+		c.errorf(keyword, "cannot iterate over %s", t)
+	}
+	return anyType
+}
+
+// index checks indexing a value of type obj with one of type index, to read
+// an element or to assign one, and returns the type of the element.
+func (c *checker) index(obj, index loxType, bracket token, assign bool) loxType {
+	switch {
+	case obj.kind == typeAny:
+		return anyType
+	case obj.nullable:
+		c.errorf(bracket, "cannot index %s, which may be nil", obj)
+		return anyType
+	case obj.kind == typeList, obj.kind == typeString && !assign:
+		if !c.is(index, typeNumber) {
+			c.errorf(bracket, "index of %s must be a number, got %s", obj, index)
+		}
+		if obj.kind == typeString {
+			return stringType
+		}
+		return anyType
+	case obj.kind == typeMap:
+		return anyType
+	case assign:
+		c.errorf(bracket, "cannot assign to an element of %s", obj)
+	default:
+		c.errorf(bracket, "cannot index %s", obj)
+	}
+	return anyType
+}
+
+// alwaysReturns reports whether running ss always ends with a return
+// statement.
+func alwaysReturns(ss []stmt) bool {
+	for _, s := range ss {
+		switch s := s.(type) {
+		case stmtReturn:
+			return true
+		case stmtBlock:
+			if alwaysReturns(s.statements) {
+				return true
+			}
+		case stmtIf:
+			if s.elseBranch != nil && alwaysReturns([]stmt{s.thenBranch}) && alwaysReturns([]stmt{s.elseBranch}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walkStmts calls f for every statement of ss and every statement nested
+// in them, including in the bodies of functions and methods.
+func walkStmts(ss []stmt, f func(stmt)) {
+	for _, s := range ss {
+		if s == nil {
+			continue
+		}
+		f(s)
+		switch s := s.(type) {
+		case stmtBlock:
+			walkStmts(s.statements, f)
+		case stmtIf:
+			walkStmts([]stmt{s.thenBranch, s.elseBranch}, f)
+		case stmtWhile:
+			walkStmts([]stmt{s.body}, f)
+		case stmtForIn:
+			walkStmts([]stmt{s.body}, f)
+		case stmtFor:
+			walkStmts([]stmt{s.initializer, s.body}, f)
+		case stmtFunction:
+			walkStmts(s.body.statements, f)
+		case stmtClass:
+			for _, m := range s.methods {
+				walkStmts([]stmt{m}, f)
+			}
+		}
+	}
+}
+
+// walkExprs calls f for every expression of a statement, not counting
+// those of the statements nested in it.
+func walkExprs(s stmt, f func(expr)) {
+	var es []expr
+	switch s := s.(type) {
+	case stmtExpression:
+		es = []expr{s.e}
+	case stmtPrint:
+		es = []expr{s.e}
+	case stmtVar:
+		es = []expr{s.initializer}
+	case stmtIf:
+		es = []expr{s.condition}
+	case stmtWhile:
+		es = []expr{s.condition}
+	case stmtFor:
+		es = []expr{s.condition, s.increment}
+	case stmtForIn:
+		es = []expr{s.iterable}
+	case stmtReturn:
+		es = []expr{s.value}
+	case stmtYield:
+		es = []expr{s.value}
+	case stmtClass:
+		if s.superClass != nil {
+			es = []expr{*s.superClass}
+		}
+	}
+	for _, e := range es {
+		walkExpr(e, f)
+	}
+}
+
+func walkExpr(e expr, f func(expr)) {
+	if e == nil {
+		return
+	}
+	f(e)
+	var es []expr
+	switch e := e.(type) {
+	case exprBinary:
+		es = []expr{e.left, e.right}
+	case exprLogical:
+		es = []expr{e.left, e.right}
+	case exprGrouping:
+		es = []expr{e.exp}
+	case exprUnary:
+		es = []expr{e.right}
+	case exprAssign:
+		es = []expr{e.value}
+	case exprCall:
+		es = append([]expr{e.callee}, e.args...)
+	case exprSpawn:
+		es = []expr{e.call}
+	case exprGet:
+		es = []expr{e.obj}
+	case exprSet:
+		es = []expr{e.obj, e.value}
+	case exprIndex:
+		es = []expr{e.obj, e.index}
+	case exprIndexSet:
+		es = []expr{e.obj, e.index, e.value}
+	case exprList:
+		es = e.elements
+	case exprMap:
+		es = append(append([]expr{}, e.keys...), e.values...)
+	}
+	for _, x := range es {
+		walkExpr(x, f)
+	}
+}
+
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox check path ...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	files, err := scriptFiles(fs.Args(), ".glox")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	failed := false
+	for _, name := range files {
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		a := analyze(filepath.Base(name), string(bs))
+		var es []typeError
+		if a.err != nil {
+			es = append(es, typeError{a.err.token, a.err.message})
+		} else {
+			es = check(a)
+		}
+		for _, e := range es {
+			fmt.Printf("%s:%d:%d: %s\n", name, e.token.line, e.token.column, e.message)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, c := range []struct {
+		name, source string
+		// want are the diagnostics, with the script called t.glox.
+		want string
+	}{
+		{
+			name:   "variable",
+			source: `var x: number = "a";`,
+			want:   "t.glox:1:17: cannot assign string to 'x' of type number\n",
+		},
+		{
+			name:   "parameter",
+			source: "fun f(a: number) { var s: string = a; }",
+			want:   "t.glox:1:36: cannot assign number to 's' of type string\n",
+		},
+		{
+			name:   "return",
+			source: `fun f(): number { return "a"; }`,
+			want:   "t.glox:1:26: 'f' must return number, got string\n",
+		},
+		{
+			name:   "argument",
+			source: "fun f(a: number) {}\nf(\"a\");",
+			want:   "t.glox:2:3: argument 1 of 'f' must be number, got string\n",
+		},
+		{
+			name:   "argument count",
+			source: "fun f(a: number) {}\nf(1, 2);",
+			want:   "t.glox:2:7: 'f' expects 1 argument but is called with 2\n",
+		},
+		{
+			name:   "field",
+			source: "class P { init() { this.x = 1; } }\nvar p = P();\np.x = \"a\";",
+			want:   "t.glox:3:7: field 'x' of P is number, got string\n",
+		},
+		{
+			name:   "method return",
+			source: "class P { m(a: number): string { return a; } }",
+			want:   "t.glox:1:41: 'm' must return string, got number\n",
+		},
+		{
+			name:   "method argument",
+			source: "class P { m(a: number) {} }\nP().m(\"a\");",
+			want:   "t.glox:2:7: argument 1 of 'm' must be number, got string\n",
+		},
+		{
+			name: "unannotated",
+			source: `fun f(a) { return a + 1; }
+class P { init(v) { this.v = v; } get() { return this.v; } }
+print f(P(1).get());
+var x = "s"; x = 2;`,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := script(t, c.source)
+			r := glox(t, "check", path)
+			if got := strings.ReplaceAll(r.stdout, path, "t.glox"); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+			want := 0
+			if c.want != "" {
+				want = 1
+			}
+			if r.status != want {
+				t.Errorf("got status %d, want %d", r.status, want)
+			}
+		})
+	}
+}
@@ -9,15 +9,16 @@ type loxClass struct {
 }
 
 func (l loxClass) String() string {
-	return l.name
+	return stringify(l)
 }
 
 var _ callable = loxClass{}
 
-func (l loxClass) call(i *interpreter, args []interface{}) interface{} {
+func (l loxClass) call(i *interpreter, paren token, args []interface{}) interface{} {
+	i.allocate(paren)
 	inst := loxInstance{klass: l, fields: map[string]interface{}{}}
 	if init := l.findMethod("init"); init != nil {
-		init.bind(inst).call(i, args)
+		init.bind(inst).call(i, paren, args)
 	}
 	return inst
 }
@@ -48,7 +49,7 @@ type loxInstance struct {
 }
 
 func (l loxInstance) String() string {
-	return l.klass.String() + fmt.Sprintf(" instance: fields: %v", l.fields)
+	return stringify(l)
 }
 
 func (l loxInstance) get(name token) interface{} {
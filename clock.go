@@ -2,12 +2,7 @@ package main
 
 import "time"
 
-type clock struct{}
-
-var _ callable = clock{}
-
-func (c clock) arity() (ret int) { return }
-
-func (c clock) call(*interpreter, []interface{}) interface{} {
+// nativeClock returns the current Unix time in seconds.
+func nativeClock(*interpreter, token, []interface{}) interface{} {
 	return time.Now().Unix()
 }
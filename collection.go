@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+// loxList is a mutable, growable sequence of values. Lists have reference
+// semantics, so it is always used through a pointer.
+type loxList struct {
+	elements []interface{}
+}
+
+func (l *loxList) String() string {
+	return stringify(l)
+}
+
+// loxMap is a mutable map from strings, numbers or booleans to values. keys
+// keeps the insertion order so that maps print and iterate predictably.
+type loxMap struct {
+	keys    []interface{}
+	entries map[interface{}]interface{}
+}
+
+func newLoxMap() *loxMap {
+	return &loxMap{entries: map[interface{}]interface{}{}}
+}
+
+func (m *loxMap) String() string {
+	return stringify(m)
+}
+
+// mapKey validates k and normalizes it so that keys which compare equal in
+// Lox (such as 1 and 1.0) find the same entry.
+func mapKey(t token, k interface{}) interface{} {
+	switch v := k.(type) {
+	case string, bool, int64:
+		return v
+	case float64:
+		if n := int64(v); float64(n) == v {
+			return n
+		}
+		return v
+	}
+	reportRuntimeError(t, fmt.Sprintf("Map keys must be strings, numbers or booleans, got %s.", stringify(k)))
+	return nil
+}
+
+func (m *loxMap) get(k interface{}) interface{} {
+	return m.entries[k]
+}
+
+func (m *loxMap) set(k, v interface{}) {
+	if _, ok := m.entries[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.entries[k] = v
+}
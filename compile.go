@@ -0,0 +1,754 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A compiled script starts with compiledMagic and the version of its
+// encoding, which is followed by the encoded program and the CRC-32 of
+// everything before it. compiledVersion changes whenever the syntax tree
+// or its encoding does.
+const (
+	compiledMagic   = "GLOXC"
+	compiledVersion = 4
+)
+
+// program is a script as compile saves it: its resolved statements, the
+// scope distances the resolver found and its references to natives, whose
+// capabilities are only checked when it is loaded.
+type program struct {
+	stmts   []stmt
+	locals  map[token]int
+	natives []token
+}
+
+var errCorruptProgram = errors.New("corrupt compiled script")
+
+// isCompiled reports whether bs is a compiled script rather than source.
+func isCompiled(bs []byte) bool {
+	return strings.HasPrefix(string(bs), compiledMagic)
+}
+
+func compileCommand(args []string) int {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	out := fs.String("o", "", "write the compiled script to this file instead of file.gloxc")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox compile [-o file.gloxc] file.glox")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(name, filepath.Ext(name)) + ".gloxc"
+	}
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	p := compile(filepath.Base(name), string(bs))
+	if p == nil {
+		return 1
+	}
+	if err := ioutil.WriteFile(*out, encodeProgram(p), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// compile scans, parses and resolves a script, printing its errors. It
+// returns nil if there were any.
+func compile(file, source string) *program {
+	sc := &scanner{source: source, file: file}
+	ts := sc.scanTokens()
+	if hadParserError {
+		return nil
+	}
+	ss := (&parser{tokens: ts}).parse()
+	if hadParserError {
+		return nil
+	}
+	// Every native is granted here, so that the capabilities are those of
+	// the interpreter that loads the script.
+	it := newInterpreter(withCapabilities(allCapabilities()...))
+	r := &resolver{inter: it}
+	r.resolve(ss)
+	if hadResolutionError {
+		return nil
+	}
+	return &program{stmts: ss, locals: it.locals, natives: r.natives}
+}
+
+// runProgram runs a compiled script as run runs source.
+func runProgram(it *interpreter, p *program) error {
+	if err := it.checkCapabilities(p.natives); err != nil {
+		return nil
+	}
+	for name, depth := range p.locals {
+		it.resolveLocal(name, depth)
+	}
+	ss := p.stmts
+	if it.optimize {
+		ss = optimize(it, ss)
+	}
+	return it.interpret(ss)
+}
+
+// checkCapabilities rejects references to natives whose capability has not
+// been granted, as the resolver does for source.
+func (i *interpreter) checkCapabilities(natives []token) (err *syntaxError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverSyntaxError(r, false)
+		}
+	}()
+	for _, name := range natives {
+		if c, denied := i.deniedNative(name.lexeme); denied {
+			reportCapabilityError(name, c)
+		}
+	}
+	return nil
+}
+
+// The tags that start each encoded statement and expression. Zero stands
+// for a missing one.
+const (
+	tagExpression byte = iota + 1
+	tagPrint
+	tagVar
+	tagBlock
+	tagIf
+	tagWhile
+	tagFor
+	tagFunction
+	tagReturn
+	tagClass
+	tagForIn
+	tagYield
+)
+
+const (
+	tagBinary byte = iota + 1
+	tagGrouping
+	tagLiteral
+	tagUnary
+	tagVariable
+	tagAssign
+	tagLogical
+	tagCall
+	tagGet
+	tagSet
+	tagThis
+	tagSuper
+	tagIndex
+	tagIndexSet
+	tagList
+	tagMap
+	tagSpawn
+)
+
+// The tags of literal values.
+const (
+	tagNilValue byte = iota
+	tagFalse
+	tagTrue
+	tagInteger
+	tagFloat
+	tagString
+)
+
+// programEncoder writes a program. Each string is written once and then
+// referred to by its index.
+type programEncoder struct {
+	b       []byte
+	strings map[string]uint64
+}
+
+func encodeProgram(p *program) []byte {
+	w := &programEncoder{b: []byte(compiledMagic), strings: map[string]uint64{}}
+	w.uint(compiledVersion)
+	w.stmts(p.stmts)
+	w.uint(uint64(len(p.locals)))
+	for name, depth := range p.locals {
+		w.token(name)
+		w.uint(uint64(depth))
+	}
+	w.tokens(p.natives)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(w.b))
+	return append(w.b, sum[:]...)
+}
+
+func (w *programEncoder) uint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.b = append(w.b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func (w *programEncoder) int(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.b = append(w.b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func (w *programEncoder) bool(v bool) {
+	if v {
+		w.b = append(w.b, 1)
+	} else {
+		w.b = append(w.b, 0)
+	}
+}
+
+func (w *programEncoder) string(s string) {
+	if k, ok := w.strings[s]; ok {
+		w.uint(k)
+		return
+	}
+	k := uint64(len(w.strings))
+	w.strings[s] = k
+	w.uint(k)
+	w.uint(uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *programEncoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.b = append(w.b, tagNilValue)
+	case bool:
+		if v {
+			w.b = append(w.b, tagTrue)
+		} else {
+			w.b = append(w.b, tagFalse)
+		}
+	case int64:
+		w.b = append(w.b, tagInteger)
+		w.int(v)
+	case float64:
+		w.b = append(w.b, tagFloat)
+		w.uint(math.Float64bits(v))
+	case string:
+		w.b = append(w.b, tagString)
+		w.string(v)
+	default:
+		panic(fmt.Sprintf("cannot encode literal %v", v))
+	}
+}
+
+func (w *programEncoder) token(t token) {
+	w.uint(uint64(t.tt))
+	w.string(t.lexeme)
+	w.value(t.literal)
+	w.uint(uint64(t.line))
+	w.uint(uint64(t.column))
+	w.string(t.file)
+}
+
+func (w *programEncoder) tokens(ts []token) {
+	w.uint(uint64(len(ts)))
+	for _, t := range ts {
+		w.token(t)
+	}
+}
+
+func (w *programEncoder) annotation(t *typeAnnotation) {
+	w.bool(t != nil)
+	if t != nil {
+		w.token(t.name)
+		w.bool(t.nullable)
+		w.token(t.end)
+	}
+}
+
+func (w *programEncoder) stmts(ss []stmt) {
+	w.uint(uint64(len(ss)))
+	for _, s := range ss {
+		w.stmt(s)
+	}
+}
+
+func (w *programEncoder) block(s stmtBlock) {
+	w.stmts(s.statements)
+	w.token(s.open)
+	w.token(s.close)
+}
+
+func (w *programEncoder) function(s stmtFunction) {
+	w.token(s.keyword)
+	w.token(s.name)
+	w.bool(s.generator)
+	w.tokens(s.params)
+	for _, t := range s.paramTypes {
+		w.annotation(t)
+	}
+	w.annotation(s.returnType)
+	w.block(s.body)
+}
+
+func (w *programEncoder) stmt(s stmt) {
+	switch s := s.(type) {
+	case nil:
+		w.b = append(w.b, 0)
+	case stmtExpression:
+		w.b = append(w.b, tagExpression)
+		w.expr(s.e)
+		w.bool(s.echo)
+	case stmtPrint:
+		w.b = append(w.b, tagPrint)
+		w.token(s.keyword)
+		w.expr(s.e)
+	case stmtVar:
+		w.b = append(w.b, tagVar)
+		w.token(s.keyword)
+		w.token(s.name)
+		w.annotation(s.typ)
+		w.expr(s.initializer)
+	case stmtBlock:
+		w.b = append(w.b, tagBlock)
+		w.block(s)
+	case stmtIf:
+		w.b = append(w.b, tagIf)
+		w.token(s.keyword)
+		w.expr(s.condition)
+		w.stmt(s.thenBranch)
+		w.stmt(s.elseBranch)
+	case stmtWhile:
+		w.b = append(w.b, tagWhile)
+		w.token(s.keyword)
+		w.expr(s.condition)
+		w.stmt(s.body)
+	case stmtFor:
+		w.b = append(w.b, tagFor)
+		w.token(s.keyword)
+		w.stmt(s.initializer)
+		w.expr(s.condition)
+		w.expr(s.increment)
+		w.stmt(s.body)
+	case stmtForIn:
+		w.b = append(w.b, tagForIn)
+		w.token(s.keyword)
+		w.token(s.name)
+		w.expr(s.iterable)
+		w.stmt(s.body)
+	case stmtFunction:
+		w.b = append(w.b, tagFunction)
+		w.function(s)
+	case stmtReturn:
+		w.b = append(w.b, tagReturn)
+		w.token(s.keyword)
+		w.expr(s.value)
+	case stmtYield:
+		w.b = append(w.b, tagYield)
+		w.token(s.keyword)
+		w.expr(s.value)
+	case stmtClass:
+		w.b = append(w.b, tagClass)
+		w.token(s.keyword)
+		w.token(s.name)
+		w.bool(s.superClass != nil)
+		if s.superClass != nil {
+			w.token(s.superClass.name)
+		}
+		w.uint(uint64(len(s.methods)))
+		for _, m := range s.methods {
+			w.function(m)
+		}
+		w.token(s.close)
+	default:
+		panic(fmt.Sprintf("cannot encode statement %T", s))
+	}
+}
+
+func (w *programEncoder) exprs(es []expr) {
+	w.uint(uint64(len(es)))
+	for _, e := range es {
+		w.expr(e)
+	}
+}
+
+func (w *programEncoder) expr(e expr) {
+	switch e := e.(type) {
+	case nil:
+		w.b = append(w.b, 0)
+	case exprBinary:
+		w.b = append(w.b, tagBinary)
+		w.expr(e.left)
+		w.token(e.operator)
+		w.expr(e.right)
+	case exprGrouping:
+		w.b = append(w.b, tagGrouping)
+		w.token(e.open)
+		w.expr(e.exp)
+		w.token(e.close)
+	case exprLiteral:
+		w.b = append(w.b, tagLiteral)
+		w.value(e.value)
+		w.token(e.token)
+	case exprUnary:
+		w.b = append(w.b, tagUnary)
+		w.token(e.operator)
+		w.expr(e.right)
+	case exprVariable:
+		w.b = append(w.b, tagVariable)
+		w.token(e.name)
+	case exprAssign:
+		w.b = append(w.b, tagAssign)
+		w.token(e.name)
+		w.expr(e.value)
+	case exprLogical:
+		w.b = append(w.b, tagLogical)
+		w.expr(e.left)
+		w.token(e.operator)
+		w.expr(e.right)
+	case exprCall:
+		w.b = append(w.b, tagCall)
+		w.expr(e.callee)
+		w.token(e.paren)
+		w.exprs(e.args)
+	case exprGet:
+		w.b = append(w.b, tagGet)
+		w.expr(e.obj)
+		w.token(e.name)
+	case exprSet:
+		w.b = append(w.b, tagSet)
+		w.expr(e.obj)
+		w.token(e.name)
+		w.expr(e.value)
+	case exprThis:
+		w.b = append(w.b, tagThis)
+		w.token(e.name)
+	case exprSuper:
+		w.b = append(w.b, tagSuper)
+		w.token(e.keyword)
+		w.token(e.method)
+	case exprIndex:
+		w.b = append(w.b, tagIndex)
+		w.expr(e.obj)
+		w.token(e.bracket)
+		w.expr(e.index)
+	case exprIndexSet:
+		w.b = append(w.b, tagIndexSet)
+		w.expr(e.obj)
+		w.token(e.bracket)
+		w.expr(e.index)
+		w.expr(e.value)
+	case exprList:
+		w.b = append(w.b, tagList)
+		w.token(e.bracket)
+		w.exprs(e.elements)
+		w.token(e.close)
+	case exprSpawn:
+		w.b = append(w.b, tagSpawn)
+		w.token(e.keyword)
+		w.expr(e.call)
+	case exprMap:
+		w.b = append(w.b, tagMap)
+		w.token(e.brace)
+		w.exprs(e.keys)
+		w.exprs(e.values)
+		w.token(e.close)
+	default:
+		panic(fmt.Sprintf("cannot encode expression %T", e))
+	}
+}
+
+// programDecoder reads what programEncoder wrote. Malformed input makes it
+// panic with errCorruptProgram.
+type programDecoder struct {
+	b       []byte
+	strings []string
+}
+
+// decodeProgram loads a compiled script, rejecting one of another version
+// or one that does not decode.
+func decodeProgram(bs []byte) (p *program, err error) {
+	if !isCompiled(bs) || len(bs) < len(compiledMagic)+4 {
+		return nil, errCorruptProgram
+	}
+	body, sum := bs[:len(bs)-4], binary.BigEndian.Uint32(bs[len(bs)-4:])
+	r := &programDecoder{b: body[len(compiledMagic):]}
+	defer func() {
+		if v := recover(); v != nil {
+			if v != errCorruptProgram {
+				panic(v)
+			}
+			p, err = nil, errCorruptProgram
+		}
+	}()
+	if v := r.uint(); v != compiledVersion {
+		return nil, fmt.Errorf("compiled script has version %d, but this glox reads version %d; compile it again", v, compiledVersion)
+	}
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, errCorruptProgram
+	}
+	p = &program{stmts: r.stmts(), locals: map[token]int{}}
+	for n := r.count(); n > 0; n-- {
+		name := r.token()
+		p.locals[name] = r.int()
+	}
+	p.natives = r.tokens()
+	if len(r.b) > 0 {
+		return nil, errCorruptProgram
+	}
+	return p, nil
+}
+
+func (r *programDecoder) byte() byte {
+	if len(r.b) == 0 {
+		panic(errCorruptProgram)
+	}
+	b := r.b[0]
+	r.b = r.b[1:]
+	return b
+}
+
+func (r *programDecoder) uint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		panic(errCorruptProgram)
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *programDecoder) int() int {
+	v := r.uint()
+	if v > math.MaxInt32 {
+		panic(errCorruptProgram)
+	}
+	return int(v)
+}
+
+// count reads the length of a list, which cannot exceed the bytes left
+// since each element takes at least one.
+func (r *programDecoder) count() int {
+	n := r.uint()
+	if n > uint64(len(r.b)) {
+		panic(errCorruptProgram)
+	}
+	return int(n)
+}
+
+func (r *programDecoder) bool() bool {
+	switch r.byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	panic(errCorruptProgram)
+}
+
+func (r *programDecoder) string() string {
+	k := r.uint()
+	switch {
+	case k < uint64(len(r.strings)):
+		return r.strings[k]
+	case k > uint64(len(r.strings)):
+		panic(errCorruptProgram)
+	}
+	n := r.count()
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	r.strings = append(r.strings, s)
+	return s
+}
+
+func (r *programDecoder) value() interface{} {
+	switch r.byte() {
+	case tagNilValue:
+		return nil
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	case tagInteger:
+		v, n := binary.Varint(r.b)
+		if n <= 0 {
+			panic(errCorruptProgram)
+		}
+		r.b = r.b[n:]
+		return v
+	case tagFloat:
+		return math.Float64frombits(r.uint())
+	case tagString:
+		return r.string()
+	}
+	panic(errCorruptProgram)
+}
+
+func (r *programDecoder) token() token {
+	tt := r.uint()
+	if tt >= uint64(len(tokenTypeNames)) {
+		panic(errCorruptProgram)
+	}
+	t := token{tt: tokenType(tt), lexeme: r.string(), literal: r.value()}
+	t.line, t.column = r.int(), r.int()
+	t.file = r.string()
+	return t
+}
+
+func (r *programDecoder) tokens() []token {
+	var ts []token
+	for n := r.count(); n > 0; n-- {
+		ts = append(ts, r.token())
+	}
+	return ts
+}
+
+func (r *programDecoder) annotation() *typeAnnotation {
+	if !r.bool() {
+		return nil
+	}
+	return &typeAnnotation{name: r.token(), nullable: r.bool(), end: r.token()}
+}
+
+func (r *programDecoder) stmts() []stmt {
+	var ss []stmt
+	for n := r.count(); n > 0; n-- {
+		s := r.stmt()
+		if s == nil {
+			panic(errCorruptProgram)
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func (r *programDecoder) block() stmtBlock {
+	return stmtBlock{statements: r.stmts(), open: r.token(), close: r.token()}
+}
+
+func (r *programDecoder) function() stmtFunction {
+	s := stmtFunction{keyword: r.token(), name: r.token(), generator: r.bool(), params: r.tokens()}
+	s.paramTypes = make([]*typeAnnotation, len(s.params))
+	for k := range s.params {
+		s.paramTypes[k] = r.annotation()
+	}
+	s.returnType = r.annotation()
+	s.body = r.block()
+	return s
+}
+
+// stmt reads a statement, which is nil where one is missing. Go evaluates
+// the operands of a composite literal in order, so fields are read in the
+// order they were written.
+func (r *programDecoder) stmt() stmt {
+	switch r.byte() {
+	case 0:
+		return nil
+	case tagExpression:
+		return stmtExpression{e: r.required(), echo: r.bool()}
+	case tagPrint:
+		return stmtPrint{keyword: r.token(), e: r.required()}
+	case tagVar:
+		return stmtVar{keyword: r.token(), name: r.token(), typ: r.annotation(), initializer: r.expr()}
+	case tagBlock:
+		return r.block()
+	case tagIf:
+		return stmtIf{keyword: r.token(), condition: r.required(), thenBranch: r.stmt(), elseBranch: r.stmt()}
+	case tagWhile:
+		return stmtWhile{keyword: r.token(), condition: r.required(), body: r.stmt()}
+	case tagFor:
+		return stmtFor{keyword: r.token(), initializer: r.stmt(), condition: r.expr(), increment: r.expr(), body: r.stmt()}
+	case tagFunction:
+		return r.function()
+	case tagReturn:
+		return stmtReturn{keyword: r.token(), value: r.expr()}
+	case tagForIn:
+		return stmtForIn{keyword: r.token(), name: r.token(), iterable: r.required(), body: r.stmt()}
+	case tagYield:
+		return stmtYield{keyword: r.token(), value: r.expr()}
+	case tagClass:
+		s := stmtClass{keyword: r.token(), name: r.token()}
+		if r.bool() {
+			s.superClass = &exprVariable{name: r.token()}
+		}
+		for n := r.count(); n > 0; n-- {
+			s.methods = append(s.methods, r.function())
+		}
+		s.close = r.token()
+		return s
+	}
+	panic(errCorruptProgram)
+}
+
+// required reads an expression that cannot be missing.
+func (r *programDecoder) required() expr {
+	e := r.expr()
+	if e == nil {
+		panic(errCorruptProgram)
+	}
+	return e
+}
+
+func (r *programDecoder) exprs() []expr {
+	var es []expr
+	for n := r.count(); n > 0; n-- {
+		es = append(es, r.required())
+	}
+	return es
+}
+
+func (r *programDecoder) expr() expr {
+	switch r.byte() {
+	case 0:
+		return nil
+	case tagBinary:
+		return exprBinary{left: r.required(), operator: r.token(), right: r.required()}
+	case tagGrouping:
+		return exprGrouping{open: r.token(), exp: r.required(), close: r.token()}
+	case tagLiteral:
+		return exprLiteral{value: r.value(), token: r.token()}
+	case tagUnary:
+		return exprUnary{operator: r.token(), right: r.required()}
+	case tagVariable:
+		return exprVariable{name: r.token()}
+	case tagAssign:
+		return exprAssign{name: r.token(), value: r.required()}
+	case tagLogical:
+		return exprLogical{left: r.required(), operator: r.token(), right: r.required()}
+	case tagCall:
+		return exprCall{callee: r.required(), paren: r.token(), args: r.exprs()}
+	case tagGet:
+		return exprGet{obj: r.required(), name: r.token()}
+	case tagSet:
+		return exprSet{obj: r.required(), name: r.token(), value: r.required()}
+	case tagThis:
+		return exprThis{name: r.token()}
+	case tagSuper:
+		return exprSuper{keyword: r.token(), method: r.token()}
+	case tagIndex:
+		return exprIndex{obj: r.required(), bracket: r.token(), index: r.required()}
+	case tagIndexSet:
+		return exprIndexSet{obj: r.required(), bracket: r.token(), index: r.required(), value: r.required()}
+	case tagList:
+		return exprList{bracket: r.token(), elements: r.exprs(), close: r.token()}
+	case tagMap:
+		e := exprMap{brace: r.token(), keys: r.exprs(), values: r.exprs(), close: r.token()}
+		if len(e.keys) != len(e.values) {
+			panic(errCorruptProgram)
+		}
+		return e
+	case tagSpawn:
+		keyword := r.token()
+		call, ok := r.required().(exprCall)
+		if !ok {
+			panic(errCorruptProgram)
+		}
+		return exprSpawn{keyword: keyword, call: call}
+	}
+	panic(errCorruptProgram)
+}
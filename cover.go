@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// coverage is a hook that counts how many times each statement is executed
+// and each function is called. Statements are identified by where they
+// start, so the counts can be matched with a fresh parse of the source when
+// reporting.
+type coverage struct {
+	statements map[coveragePos]int64
+	calls      map[coveragePos]int64
+}
+
+type coveragePos struct {
+	file         string
+	line, column int
+}
+
+func positionOf(t token) coveragePos {
+	return coveragePos{t.file, t.line, t.column}
+}
+
+func newCoverage() *coverage {
+	return &coverage{statements: map[coveragePos]int64{}, calls: map[coveragePos]int64{}}
+}
+
+var _ hook = &coverage{}
+
+func (c *coverage) statement(_ *interpreter, s stmt) {
+	c.statements[positionOf(stmtStart(s))]++
+}
+
+func (c *coverage) enter(_ *interpreter, f loxFunction, _ []interface{}) {
+	c.calls[positionOf(f.declaration.name)]++
+}
+
+func (c *coverage) leave(*interpreter, loxFunction, interface{}, bool) {}
+
+// coverageFile is the coverage of a source file.
+type coverageFile struct {
+	path      string
+	source    []string
+	lines     map[int]*coverageLine
+	branches  []coverageBranch
+	functions []coverageFunction
+}
+
+// coverageLine sums up the statements starting on a line. count is the
+// highest number of times one of them ran.
+type coverageLine struct {
+	statements, covered int
+	count               int64
+}
+
+// coverageBranch is a way through an if statement, or into the body of a
+// loop. block numbers the statement the branch belongs to, and branch the
+// way through it.
+type coverageBranch struct {
+	line, block, branch int
+	// reached reports whether the statement ran at all.
+	reached bool
+	taken   int64
+}
+
+type coverageFunction struct {
+	name  string
+	line  int
+	calls int64
+}
+
+// file matches the counts with the statements of the script read from path.
+func (c *coverage) file(path, source string) (*coverageFile, error) {
+	name := filepath.Base(path)
+	sc := &scanner{source: source, file: name, quiet: true}
+	ts := sc.scanTokens()
+	if sc.err != nil {
+		return nil, sc.err
+	}
+	p := &parser{tokens: ts, quiet: true}
+	ss := p.parse()
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	f := &coverageFile{path: path, source: strings.Split(source, "\n"), lines: map[int]*coverageLine{}}
+	count := func(s stmt) int64 {
+		return c.statements[positionOf(stmtStart(s))]
+	}
+	blocks := 0
+	branch := func(line int, reached bool, taken ...int64) {
+		for k, n := range taken {
+			f.branches = append(f.branches, coverageBranch{line, blocks, k, reached, n})
+		}
+		blocks++
+	}
+	function := func(name string, s stmtFunction) {
+		f.functions = append(f.functions, coverageFunction{name, s.name.line, c.calls[positionOf(s.name)]})
+	}
+	var walk func(s stmt)
+	walk = func(s stmt) {
+		if s == nil {
+			return
+		}
+		if _, ok := s.(stmtBlock); !ok {
+			line := stmtStart(s).line
+			l := f.lines[line]
+			if l == nil {
+				l = &coverageLine{}
+				f.lines[line] = l
+			}
+			n := count(s)
+			l.statements++
+			if n > 0 {
+				l.covered++
+			}
+			if n > l.count {
+				l.count = n
+			}
+		}
+		switch s := s.(type) {
+		case stmtBlock:
+			for _, s := range s.statements {
+				walk(s)
+			}
+		case stmtIf:
+			n, then := count(s), count(s.thenBranch)
+			otherwise := n - then
+			if s.elseBranch != nil {
+				otherwise = count(s.elseBranch)
+			}
+			branch(s.keyword.line, n > 0, then, otherwise)
+			walk(s.thenBranch)
+			walk(s.elseBranch)
+		case stmtWhile:
+			branch(s.keyword.line, count(s) > 0, count(s.body))
+			walk(s.body)
+		case stmtFor:
+			branch(s.keyword.line, count(s) > 0, count(s.body))
+			walk(s.initializer)
+			walk(s.body)
+		case stmtForIn:
+			branch(s.keyword.line, count(s) > 0, count(s.body))
+			walk(s.body)
+		case stmtFunction:
+			function(s.name.lexeme, s)
+			walk(s.body)
+		case stmtClass:
+			for _, m := range s.methods {
+				function(s.name.lexeme+"."+m.name.lexeme, m)
+				walk(m.body)
+			}
+		}
+	}
+	for _, s := range ss {
+		walk(s)
+	}
+	return f, nil
+}
+
+// lineNumbers returns the lines with statements in order.
+func (f *coverageFile) lineNumbers() []int {
+	var ret []int
+	for l := range f.lines {
+		ret = append(ret, l)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// summary returns the number of statements and branches, and how many of
+// them were covered.
+func (f *coverageFile) summary() (statements, coveredStatements, branches, coveredBranches int) {
+	for _, l := range f.lines {
+		statements += l.statements
+		coveredStatements += l.covered
+	}
+	for _, b := range f.branches {
+		branches++
+		if b.taken > 0 {
+			coveredBranches++
+		}
+	}
+	return
+}
+
+// writeLCOV writes the coverage of the files as LCOV tracefile records.
+func writeLCOV(out io.Writer, files []*coverageFile) {
+	for _, f := range files {
+		fmt.Fprintf(out, "TN:\nSF:%s\n", f.path)
+		hit := 0
+		for _, fn := range f.functions {
+			fmt.Fprintf(out, "FN:%d,%s\n", fn.line, fn.name)
+		}
+		for _, fn := range f.functions {
+			fmt.Fprintf(out, "FNDA:%d,%s\n", fn.calls, fn.name)
+			if fn.calls > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(out, "FNF:%d\nFNH:%d\n", len(f.functions), hit)
+		hit = 0
+		for _, b := range f.branches {
+			taken := "-"
+			if b.reached {
+				taken = fmt.Sprint(b.taken)
+			}
+			if b.taken > 0 {
+				hit++
+			}
+			fmt.Fprintf(out, "BRDA:%d,%d,%d,%s\n", b.line, b.block, b.branch, taken)
+		}
+		fmt.Fprintf(out, "BRF:%d\nBRH:%d\n", len(f.branches), hit)
+		hit = 0
+		lines := f.lineNumbers()
+		for _, l := range lines {
+			fmt.Fprintf(out, "DA:%d,%d\n", l, f.lines[l].count)
+			if f.lines[l].count > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(out, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit)
+	}
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 0.5em; white-space: pre; }
+td.num, td.count { text-align: right; color: #888; }
+tr.hit td.code { background: #dfd; }
+tr.partial td.code { background: #ffd; }
+tr.miss td.code { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<p>{{.Statements}} of statements and {{.Branches}} of branches covered.</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="num">{{.Number}}</td><td class="count">{{.Count}}</td><td class="code">{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeHTML writes the source of the file with each line colored by
+// whether its statements ran: all of them and every branch starting there
+// (hit), only some (partial) or none (miss).
+func (f *coverageFile) writeHTML(out io.Writer) error {
+	type line struct {
+		Number      int
+		Count, Text string
+		Class       string
+	}
+	untaken := map[int]bool{}
+	for _, b := range f.branches {
+		if b.taken == 0 {
+			untaken[b.line] = true
+		}
+	}
+	var lines []line
+	for k, text := range f.source {
+		l := line{Number: k + 1, Text: text}
+		if c := f.lines[k+1]; c != nil {
+			l.Count = fmt.Sprint(c.count)
+			switch {
+			case c.covered == 0:
+				l.Class = "miss"
+			case c.covered < c.statements || untaken[k+1]:
+				l.Class = "partial"
+			default:
+				l.Class = "hit"
+			}
+		}
+		lines = append(lines, l)
+	}
+	statements, coveredStatements, branches, coveredBranches := f.summary()
+	return coverageTemplate.Execute(out, map[string]interface{}{
+		"Path":       f.path,
+		"Statements": ratio(coveredStatements, statements),
+		"Branches":   ratio(coveredBranches, branches),
+		"Lines":      lines,
+	})
+}
+
+func ratio(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// writeCoverage writes the coverage of the script read from path into dir,
+// as an HTML report named after the script and an lcov.info file, and
+// prints a summary to stderr.
+func writeCoverage(c *coverage, path, source, dir string) error {
+	f, err := c.file(path, source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var lcov strings.Builder
+	writeLCOV(&lcov, []*coverageFile{f})
+	if err := ioutil.WriteFile(filepath.Join(dir, "lcov.info"), []byte(lcov.String()), 0644); err != nil {
+		return err
+	}
+	var html strings.Builder
+	if err := f.writeHTML(&html); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(path)+".html"), []byte(html.String()), 0644); err != nil {
+		return err
+	}
+	statements, coveredStatements, branches, coveredBranches := f.summary()
+	fmt.Fprintf(os.Stderr, "coverage: %s of statements, %s of branches\n",
+		ratio(coveredStatements, statements), ratio(coveredBranches, branches))
+	return nil
+}
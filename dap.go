@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dapServer drives the debugger from an editor through the Debug Adapter
+// Protocol. Requests are handled on the goroutine that reads them while
+// the script runs on its own goroutine, which blocks in stop while paused.
+type dapServer struct {
+	in *bufio.Reader
+
+	// mu guards out, seq and paused.
+	mu     sync.Mutex
+	out    io.Writer
+	seq    int
+	paused bool
+
+	d       *debugger
+	it      *interpreter
+	program string
+	stmts   []stmt
+	valid   map[int]bool
+	cancel  context.CancelFunc
+	// resumed receives how to continue when the script is paused.
+	resumed chan stepMode
+	// finished is closed when the script has ended.
+	finished chan struct{}
+	started  bool
+	// entry is set until the first stop, which is reported as such.
+	entry bool
+
+	// handles are the objects behind variable references: the
+	// environments of frames and the lists, maps and instances they hold.
+	// They are only valid while paused.
+	handles []interface{}
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapScope is a handle on the variables of a frame, or on the globals if
+// env is the global environment.
+type dapScope struct {
+	env *environment
+}
+
+// dapThread is the only thread of a script.
+const dapThread = 1
+
+func serveDAP(in io.Reader, out io.Writer) int {
+	s := &dapServer{in: bufio.NewReader(in), out: out, resumed: make(chan stepMode), finished: make(chan struct{})}
+	for {
+		body, err := readMessage(s.in)
+		if err != nil {
+			s.terminate()
+			if err == io.EOF {
+				return 0
+			}
+			return 1
+		}
+		var req dapRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+			continue
+		}
+		result, err := s.handle(req)
+		resp := dapResponse{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: result}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		s.send(&resp.Seq, &resp)
+		switch req.Command {
+		case "launch":
+			if err == nil {
+				s.event("initialized", nil)
+			}
+		case "disconnect":
+			return 0
+		}
+	}
+}
+
+// send writes a message after numbering it through seq.
+func (s *dapServer) send(seq *int, msg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	*seq = s.seq
+	writeMessage(s.out, msg)
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	e := dapEvent{Type: "event", Event: name, Body: body}
+	s.send(&e.Seq, &e)
+}
+
+func (s *dapServer) handle(req dapRequest) (interface{}, error) {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		Source      struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+		FrameID            int    `json:"frameId"`
+		VariablesReference int    `json:"variablesReference"`
+		Expression         string `json:"expression"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.launch(args.Program, args.StopOnEntry)
+	case "setBreakpoints":
+		if s.d == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+		lines := make([]int, len(args.Breakpoints))
+		for k, b := range args.Breakpoints {
+			lines[k] = b.Line
+		}
+		bps := []map[string]interface{}{}
+		for _, l := range s.d.setBreakpoints(lines, s.valid) {
+			bps = append(bps, map[string]interface{}{"verified": s.valid[l], "line": l})
+		}
+		return map[string]interface{}{"breakpoints": bps}, nil
+	case "configurationDone":
+		if s.d == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+		if !s.started {
+			s.started = true
+			go s.run()
+		}
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThread, "name": "main"}},
+		}, nil
+	case "stackTrace":
+		frames := []map[string]interface{}{}
+		if s.isPaused() {
+			source := map[string]string{"name": filepath.Base(s.program), "path": s.program}
+			for k, f := range s.d.frames() {
+				frames = append(frames, map[string]interface{}{
+					"id": k + 1, "name": f.function, "line": f.line, "column": f.column, "source": source,
+				})
+			}
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		f, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		var scopes []map[string]interface{}
+		if f.env != s.it.globals {
+			scopes = append(scopes, map[string]interface{}{
+				"name": "Locals", "variablesReference": s.newHandle(dapScope{f.env}), "expensive": false,
+			})
+		}
+		scopes = append(scopes, map[string]interface{}{
+			"name": "Globals", "variablesReference": s.newHandle(dapScope{s.it.globals}), "expensive": false,
+		})
+		return map[string]interface{}{"scopes": scopes}, nil
+	case "variables":
+		if !s.isPaused() || args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+			return nil, fmt.Errorf("invalid variables reference %d", args.VariablesReference)
+		}
+		return map[string]interface{}{"variables": s.variables(s.handles[args.VariablesReference-1])}, nil
+	case "evaluate":
+		f, err := s.frame(args.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		v, err := s.d.evaluate(args.Expression, f.env)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"result": debugValue(v), "variablesReference": s.reference(v)}, nil
+	case "continue":
+		s.resume(stepContinue)
+		return map[string]interface{}{"allThreadsContinued": true}, nil
+	case "next":
+		s.resume(stepOver)
+	case "stepIn":
+		s.resume(stepInto)
+	case "stepOut":
+		s.resume(stepOut)
+	case "pause":
+		if s.d != nil {
+			s.d.resume(stepPause)
+		}
+	case "terminate", "disconnect":
+		s.terminate()
+	default:
+		return nil, fmt.Errorf("unsupported request %q", req.Command)
+	}
+	return nil, nil
+}
+
+// launch loads the program and prepares its interpreter. The script starts
+// running once the client is done configuring breakpoints.
+func (s *dapServer) launch(program string, stopOnEntry bool) error {
+	if s.d != nil {
+		return fmt.Errorf("a program is already launched")
+	}
+	bs, err := ioutil.ReadFile(program)
+	if err != nil {
+		return err
+	}
+	a := analyze(filepath.Base(program), string(bs))
+	if a.err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(a.err.text))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.program, s.stmts, s.valid, s.cancel = program, a.stmts, statementLines(a.stmts), cancel
+	s.d = newDebugger(string(bs))
+	s.d.stop = s.stop
+	s.entry = stopOnEntry
+	if !stopOnEntry {
+		s.d.mode = stepContinue
+	}
+	opts := append(interpreterOptions(),
+		withHook(s.d), withContext(ctx), withInput(strings.NewReader("")),
+		withOutput(dapOutput{s, "stdout"}), withDiagnostics(dapOutput{s, "stderr"}))
+	s.it = newInterpreter(opts...)
+	// The analysis resolved the script with a throwaway interpreter.
+	(&resolver{inter: s.it, quiet: true}).resolve(s.stmts)
+	return nil
+}
+
+// dapOutput sends what the script writes to the client as output events.
+type dapOutput struct {
+	s        *dapServer
+	category string
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", map[string]string{"category": o.category, "output": string(p)})
+	return len(p), nil
+}
+
+func (s *dapServer) run() {
+	defer close(s.finished)
+	code := 0
+	switch err := s.it.interpret(s.stmts).(type) {
+	case nil:
+	case *exitStatus:
+		code = err.code
+	default:
+		code = 1
+	}
+	s.event("exited", map[string]int{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+// stop is called on the script's goroutine when it pauses. It waits for
+// the client to resume it.
+func (s *dapServer) stop(reason string) {
+	if s.entry {
+		s.entry, reason = false, "entry"
+	}
+	s.mu.Lock()
+	s.paused = true
+	s.handles = nil
+	s.mu.Unlock()
+	s.event("stopped", map[string]interface{}{"reason": reason, "threadId": dapThread, "allThreadsStopped": true})
+	s.d.resume(<-s.resumed)
+}
+
+func (s *dapServer) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// resume continues a paused script.
+func (s *dapServer) resume(mode stepMode) {
+	s.mu.Lock()
+	paused := s.paused
+	s.paused = false
+	s.mu.Unlock()
+	if paused {
+		s.resumed <- mode
+	}
+}
+
+// terminate aborts the script, if it runs, and waits for it to end.
+func (s *dapServer) terminate() {
+	if !s.started {
+		return
+	}
+	s.d.resume(stepTerminate)
+	s.resume(stepTerminate)
+	s.cancel()
+	<-s.finished
+}
+
+// frame returns the frame with the given id, which is 1 for the innermost
+// frame. An id of 0 means the innermost frame too.
+func (s *dapServer) frame(id int) (debugFrame, error) {
+	if !s.isPaused() {
+		return debugFrame{}, fmt.Errorf("the script is not paused")
+	}
+	frames := s.d.frames()
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(frames) {
+		return debugFrame{}, fmt.Errorf("invalid frame %d", id)
+	}
+	return frames[id-1], nil
+}
+
+// newHandle returns a variables reference for v.
+func (s *dapServer) newHandle(v interface{}) int {
+	s.handles = append(s.handles, v)
+	return len(s.handles)
+}
+
+// reference returns a variables reference for the values that have
+// children, and 0 for the others.
+func (s *dapServer) reference(v interface{}) int {
+	switch v.(type) {
+	case *loxList, *loxMap, loxInstance:
+		return s.newHandle(v)
+	}
+	return 0
+}
+
+func (s *dapServer) variables(h interface{}) []dapVariable {
+	ret := []dapVariable{}
+	add := func(name string, v interface{}) {
+		ret = append(ret, dapVariable{name, debugValue(v), s.reference(v)})
+	}
+	switch h := h.(type) {
+	case dapScope:
+		vs := environmentVariables(h.env)
+		if h.env != s.it.globals {
+			vs = s.d.scopeVariables(h.env)
+		}
+		for _, v := range vs {
+			add(v.name, v.value)
+		}
+	case *loxList:
+		for k, v := range h.elements {
+			add(fmt.Sprintf("[%d]", k), v)
+		}
+	case *loxMap:
+		for _, k := range h.keys {
+			add(debugValue(k), h.get(k))
+		}
+	case loxInstance:
+		for _, v := range environmentVariables(&environment{values: h.fields}) {
+			add(v.name, v.value)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// dapMessage is a response or an event sent by the debug adapter.
+type dapMessage struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// dapClient drives a debug adapter over in-memory pipes. Events may arrive
+// at any time, so messages are read as they come and queued in out.
+type dapClient struct {
+	t      *testing.T
+	in     io.WriteCloser
+	out    chan dapMessage
+	seq    int
+	status chan int
+	// events are the events received and not waited for yet.
+	events []dapMessage
+}
+
+func newDAPClient(t *testing.T) *dapClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &dapClient{t: t, in: inW, out: make(chan dapMessage, 64), status: make(chan int, 1)}
+	go func() {
+		c.status <- serveDAP(inR, outW)
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(c.out)
+				return
+			}
+			var msg dapMessage
+			if err := json.Unmarshal(body, &msg); err != nil {
+				t.Errorf("%v in %s", err, body)
+			}
+			c.out <- msg
+		}
+	}()
+	return c
+}
+
+func (c *dapClient) next() dapMessage {
+	c.t.Helper()
+	msg, ok := <-c.out
+	if !ok {
+		c.t.Fatal("the adapter closed its output")
+	}
+	return msg
+}
+
+// request sends a request and decodes the body of its response into body,
+// queueing the events that arrive before it.
+func (c *dapClient) request(command string, args interface{}, body interface{}) {
+	c.t.Helper()
+	c.seq++
+	writeMessage(c.in, map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	for {
+		msg := c.next()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("%s: got the response to request %d, want %d", command, msg.RequestSeq, c.seq)
+		}
+		if !msg.Success {
+			c.t.Fatalf("%s: %s", command, msg.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("%s: %v in %s", command, err, msg.Body)
+			}
+		}
+		return
+	}
+}
+
+// nextEvent returns the next event.
+func (c *dapClient) nextEvent() dapMessage {
+	c.t.Helper()
+	for len(c.events) == 0 {
+		if msg := c.next(); msg.Type == "event" {
+			return msg
+		}
+	}
+	msg := c.events[0]
+	c.events = c.events[1:]
+	return msg
+}
+
+// event waits for the next event named name, skipping output events, and
+// decodes its body into body.
+func (c *dapClient) event(name string, body interface{}) {
+	c.t.Helper()
+	for {
+		msg := c.nextEvent()
+		if msg.Event == "output" {
+			continue
+		}
+		if msg.Event != name {
+			c.t.Fatalf("got event %s, want %s", msg.Event, name)
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("%s: %v in %s", name, err, msg.Body)
+			}
+		}
+		return
+	}
+}
+
+const dapTestSource = `fun double(x) {
+  var y = x * 2;
+  return y;
+}
+print double(21);
+`
+
+func TestDAPSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := filepath.Join(dir, "double.glox")
+	if err := ioutil.WriteFile(program, []byte(dapTestSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDAPClient(t)
+	var caps map[string]bool
+	c.request("initialize", map[string]string{"adapterID": "glox"}, &caps)
+	if !caps["supportsConfigurationDoneRequest"] {
+		t.Errorf("initialize: got capabilities %v", caps)
+	}
+	c.request("launch", map[string]interface{}{"program": program}, nil)
+	c.event("initialized", nil)
+
+	var bps struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+			Line     int  `json:"line"`
+		} `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", map[string]interface{}{
+		"source": map[string]string{"path": program}, "breakpoints": []map[string]int{{"line": 2}},
+	}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified || bps.Breakpoints[0].Line != 2 {
+		t.Errorf("setBreakpoints: got %+v", bps.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	c.event("stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("stopped: got reason %q, want breakpoint", stopped.Reason)
+	}
+
+	var trace struct {
+		StackFrames []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+			Line int    `json:"line"`
+		} `json:"stackFrames"`
+	}
+	c.request("stackTrace", map[string]int{"threadId": dapThread}, &trace)
+	if len(trace.StackFrames) != 2 || trace.StackFrames[0].Name != "double" || trace.StackFrames[0].Line != 2 ||
+		trace.StackFrames[1].Line != 5 {
+		t.Errorf("stackTrace: got %+v", trace.StackFrames)
+	}
+
+	var scopes struct {
+		Scopes []struct {
+			Name               string `json:"name"`
+			VariablesReference int    `json:"variablesReference"`
+		} `json:"scopes"`
+	}
+	c.request("scopes", map[string]int{"frameId": 1}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" {
+		t.Fatalf("scopes: got %+v", scopes.Scopes)
+	}
+	var vars struct {
+		Variables []dapVariable `json:"variables"`
+	}
+	c.request("variables", map[string]int{"variablesReference": scopes.Scopes[0].VariablesReference}, &vars)
+	locals := map[string]string{}
+	for _, v := range vars.Variables {
+		locals[v.Name] = v.Value
+	}
+	if locals["x"] != "21" {
+		t.Errorf("variables: got %v, want x = 21", locals)
+	}
+
+	c.request("next", map[string]int{"threadId": dapThread}, nil)
+	c.event("stopped", &stopped)
+	c.request("stackTrace", map[string]int{"threadId": dapThread}, &trace)
+	if stopped.Reason != "step" || len(trace.StackFrames) != 2 || trace.StackFrames[0].Line != 3 {
+		t.Errorf("next: stopped for %q at %+v, want a step to line 3", stopped.Reason, trace.StackFrames)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", map[string]interface{}{"frameId": 1, "expression": "y + x"}, &result)
+	if result.Result != "63" {
+		t.Errorf("evaluate: got %q, want 63", result.Result)
+	}
+
+	c.request("continue", map[string]int{"threadId": dapThread}, nil)
+	var exited struct {
+		ExitCode int `json:"exitCode"`
+	}
+	var output []string
+	for msg := c.nextEvent(); ; msg = c.nextEvent() {
+		if msg.Event == "exited" {
+			json.Unmarshal(msg.Body, &exited)
+			break
+		}
+		var o struct {
+			Output string `json:"output"`
+		}
+		if msg.Event != "output" || json.Unmarshal(msg.Body, &o) != nil {
+			t.Fatalf("continue: got event %s, want output", msg.Event)
+		}
+		output = append(output, o.Output)
+	}
+	if exited.ExitCode != 0 || len(output) != 1 || output[0] != "42\n" {
+		t.Errorf("continue: got exit code %d and output %q, want 0 and 42", exited.ExitCode, output)
+	}
+	c.event("terminated", nil)
+
+	c.request("disconnect", nil, nil)
+	if status := <-c.status; status != 0 {
+		t.Errorf("disconnect: got status %d, want 0", status)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stepMode tells the debugger where to stop next once execution resumes.
+type stepMode int
+
+const (
+	stepContinue  stepMode = iota // at the next breakpoint
+	stepInto                      // at the next statement
+	stepOver                      // at the next statement of this frame or a caller
+	stepOut                       // at the next statement of a caller
+	stepPause                     // as soon as possible
+	stepTerminate                 // abort the script
+)
+
+// debugger is a hook that pauses the script at breakpoints and after steps.
+// The front end, which talks to the user, is called through stop and tells
+// the debugger how to continue with resume.
+type debugger struct {
+	lines []string
+
+	mu          sync.Mutex
+	breakpoints map[int]bool
+	mode        stepMode
+	// depth is the call depth at which the last step started.
+	depth int
+
+	// lastLine and lastDepth are the position of the last statement seen,
+	// so that a line with several statements is stopped at only once.
+	lastLine, lastDepth int
+	// callers holds, for each active call of each interpreter, the
+	// environment it was made from, which is where the calling frame's
+	// variables live.
+	callers map[*interpreter][]*environment
+	// at is the first token of the statement execution is stopped at.
+	at token
+	it *interpreter
+	// evaluating is set while evaluating an expression for the user, during
+	// which the debugger never stops.
+	evaluating bool
+
+	// stop is called by the interpreter goroutine when it pauses, with the
+	// reason. It returns once the front end has called resume.
+	stop func(reason string)
+}
+
+func newDebugger(source string) *debugger {
+	return &debugger{
+		lines:       strings.Split(source, "\n"),
+		breakpoints: map[int]bool{},
+		mode:        stepInto,
+		callers:     map[*interpreter][]*environment{},
+	}
+}
+
+var _ hook = &debugger{}
+
+func (d *debugger) statement(i *interpreter, s stmt) {
+	if d.evaluating {
+		return
+	}
+	if _, ok := s.(stmtBlock); ok {
+		// A block only groups statements; entering one starts a new line
+		// even if it comes back to the same one, as in a loop.
+		d.lastLine = 0
+		return
+	}
+	t := stmtStart(s)
+	depth := i.depth + len(i.frames)
+	if t.line == d.lastLine && depth == d.lastDepth {
+		return
+	}
+	d.lastLine, d.lastDepth = t.line, depth
+
+	d.mu.Lock()
+	var reason string
+	switch {
+	case d.mode == stepTerminate:
+		d.mu.Unlock()
+		panic(&exitStatus{code: 1})
+	case d.breakpoints[t.line]:
+		reason = "breakpoint"
+	case d.mode == stepPause:
+		reason = "pause"
+	case d.mode == stepInto,
+		d.mode == stepOver && depth <= d.depth,
+		d.mode == stepOut && depth < d.depth:
+		reason = "step"
+	}
+	d.mu.Unlock()
+	if reason == "" {
+		return
+	}
+
+	d.at, d.it = t, i
+	d.stop(reason)
+	d.mu.Lock()
+	terminate := d.mode == stepTerminate
+	d.mu.Unlock()
+	if terminate {
+		panic(&exitStatus{code: 1})
+	}
+}
+
+func (d *debugger) enter(i *interpreter, _ loxFunction, _ []interface{}) {
+	d.callers[i] = append(d.callers[i], i.env)
+}
+
+func (d *debugger) leave(i *interpreter, _ loxFunction, _ interface{}, _ bool) {
+	if cs := d.callers[i]; len(cs) > 1 {
+		d.callers[i] = cs[:len(cs)-1]
+	} else {
+		delete(d.callers, i)
+	}
+}
+
+// resume continues execution in the given mode. It may be called while the
+// script runs, to pause or terminate it.
+func (d *debugger) resume(mode stepMode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode = mode
+	if d.it != nil {
+		d.depth = d.it.depth + len(d.it.frames)
+	}
+}
+
+// setBreakpoints replaces the breakpoints. Lines without a statement move
+// to the next line that has one; the lines actually used are returned.
+func (d *debugger) setBreakpoints(lines []int, valid map[int]bool) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = map[int]bool{}
+	ret := make([]int, len(lines))
+	for k, l := range lines {
+		ret[k] = l
+		for n := l; n <= len(d.lines); n++ {
+			if valid[n] {
+				ret[k] = n
+				break
+			}
+		}
+		d.breakpoints[ret[k]] = true
+	}
+	return ret
+}
+
+// statementLines returns the lines on which a statement starts.
+func statementLines(ss []stmt) map[int]bool {
+	ret := map[int]bool{}
+	var walk func(s stmt)
+	walk = func(s stmt) {
+		if s == nil {
+			return
+		}
+		if _, ok := s.(stmtBlock); !ok {
+			ret[stmtStart(s).line] = true
+		}
+		switch s := s.(type) {
+		case stmtBlock:
+			for _, s := range s.statements {
+				walk(s)
+			}
+		case stmtIf:
+			walk(s.thenBranch)
+			walk(s.elseBranch)
+		case stmtWhile:
+			walk(s.body)
+		case stmtFor:
+			walk(s.initializer)
+			walk(s.body)
+		case stmtForIn:
+			walk(s.body)
+		case stmtFunction:
+			walk(s.body)
+		case stmtClass:
+			for _, m := range s.methods {
+				walk(m.body)
+			}
+		}
+	}
+	for _, s := range ss {
+		walk(s)
+	}
+	return ret
+}
+
+// debugFrame is an active frame of the paused script, innermost first.
+type debugFrame struct {
+	function string
+	line     int
+	column   int
+	env      *environment
+}
+
+func (d *debugger) frames() []debugFrame {
+	fs := d.it.frames
+	ret := make([]debugFrame, 0, len(fs)+1)
+	at, env := d.at, d.it.env
+	for k := len(fs) - 1; k >= 0; k-- {
+		ret = append(ret, debugFrame{fs[k].function, at.line, at.column, env})
+		at, env = fs[k].call, d.callers[d.it][k]
+	}
+	return append(ret, debugFrame{"<script>", at.line, at.column, env})
+}
+
+// debugVariable is a variable in scope in a frame.
+type debugVariable struct {
+	name  string
+	value interface{}
+}
+
+// scopeVariables returns the variables of env and its enclosing
+// environments, except the globals, innermost first and without the ones
+// they shadow.
+func (d *debugger) scopeVariables(env *environment) []debugVariable {
+	var ret []debugVariable
+	seen := map[string]bool{}
+	for e := env; e != nil && e != d.it.globals; e = e.enclosing {
+		for _, v := range environmentVariables(e) {
+			if !seen[v.name] {
+				seen[v.name] = true
+				ret = append(ret, v)
+			}
+		}
+	}
+	return ret
+}
+
+// environmentVariables returns the variables defined in e sorted by name.
+// Natives are left out.
+func environmentVariables(e *environment) []debugVariable {
+	var ret []debugVariable
+	for name, v := range e.values {
+		if _, ok := v.(nativeFunction); !ok {
+			ret = append(ret, debugVariable{name, v})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// debugValue formats a value for display, quoting strings.
+func debugValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return stringify(v)
+}
+
+// evaluate evaluates the expression src in env, as if it appeared in the
+// code where env is the current environment.
+func (d *debugger) evaluate(src string, env *environment) (v interface{}, err error) {
+	i := d.it
+	parseFailed, resolutionFailed, runtimeFailed := hadParserError, hadResolutionError, hadRuntimeError
+	prevEnv := i.env
+	defer func() {
+		hadParserError, hadResolutionError, hadRuntimeError = parseFailed, resolutionFailed, runtimeFailed
+		i.env = prevEnv
+		d.evaluating = false
+	}()
+
+	sc := &scanner{source: src, file: "<eval>", quiet: true}
+	ts := sc.scanTokens()
+	if sc.err != nil {
+		return nil, fmt.Errorf("%s", sc.err.message)
+	}
+	e, perr := parseExpression(ts)
+	if perr != nil {
+		return nil, fmt.Errorf("%s", perr.message)
+	}
+
+	// Resolve the expression in scopes that mirror the environment chain
+	// so that the distances it records find the right variables.
+	var chain []*environment
+	for en := env; en != nil && en != i.globals; en = en.enclosing {
+		chain = append(chain, en)
+	}
+	r := &resolver{inter: i, quiet: true}
+	for k := len(chain) - 1; k >= 0; k-- {
+		r.beginScope()
+		for name := range chain[k].values {
+			r.peekScope()[name] = true
+		}
+	}
+	r.currentFunctionType, r.currentClass = functionTypeFunction, classTypeSubclass
+	r.resolve([]stmt{stmtExpression{e: e}})
+	if r.err != nil {
+		return nil, fmt.Errorf("%s", r.err.message)
+	}
+
+	d.evaluating = true
+	i.env = env
+	defer func() {
+		if raw := recover(); raw != nil {
+			switch raw := raw.(type) {
+			case *runtimeError:
+				err = fmt.Errorf("%s", raw.message)
+			case *limitError:
+				err = fmt.Errorf("%s", raw.reason)
+			default:
+				panic(raw)
+			}
+		}
+	}()
+	return i.evaluate(e), nil
+}
+
+// parseExpression parses tokens that must form a single expression.
+func parseExpression(ts []token) (e expr, err *syntaxError) {
+	p := &parser{tokens: ts, quiet: true}
+	defer func() {
+		if raw := recover(); raw != nil {
+			err = recoverSyntaxError(raw, true)
+		}
+	}()
+	e = p.expression()
+	if !p.isAtEnd() {
+		reportParserError(p.peek(), "Expect end of expression.")
+	}
+	return e, nil
+}
+
+// debugCommand implements "glox debug", which runs a script under the
+// debugger, driven from the terminal or, with -dap, by an editor through
+// the Debug Adapter Protocol.
+func debugCommand(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	dap := fs.Bool("dap", false, "speak the Debug Adapter Protocol on stdin and stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox debug file.glox\n       glox debug -dap")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dap {
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return 2
+		}
+		return serveDAP(os.Stdin, os.Stdout)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	name := fs.Arg(0)
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	d := newDebugger(string(bs))
+	c := &debugConsole{d: d, in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	d.stop = c.stop
+	fmt.Fprintln(c.out, `glox debugger; type "help" for a list of commands.`)
+
+	it := newInterpreter(append(interpreterOptions(), withHook(d))...)
+	err = run(it, filepath.Base(name), string(bs), false)
+	if exit, ok := err.(*exitStatus); ok {
+		if !c.quit {
+			fmt.Fprintf(c.out, "script exited with status %d\n", exit.code)
+		}
+		return exit.code
+	}
+	if hadParserError || hadResolutionError || hadRuntimeError {
+		return 1
+	}
+	fmt.Fprintln(c.out, "script finished")
+	return 0
+}
+
+// debugConsole is the terminal front end of the debugger.
+type debugConsole struct {
+	d    *debugger
+	in   *bufio.Reader
+	out  io.Writer
+	quit bool
+}
+
+const debugHelp = `commands:
+  break LINE (b)      set a breakpoint
+  delete LINE (d)     remove a breakpoint
+  continue (c)        run until the next breakpoint
+  step (s)            step into calls
+  next (n)            step over calls
+  out (o)             run until the current function returns
+  locals (l)          print the variables of the current frame
+  globals             print the global variables
+  print EXPR (p)      evaluate an expression in the current frame
+  stack (bt)          print the call stack
+  frame N (f)         select the frame to inspect, 0 being the innermost
+  list                show the source around the current line
+  quit (q)            abort the script`
+
+func (c *debugConsole) stop(reason string) {
+	frame := 0
+	if reason == "breakpoint" {
+		fmt.Fprintf(c.out, "breakpoint at line %d\n", c.d.at.line)
+	}
+	c.showLine(c.d.at.line)
+	for {
+		fmt.Fprint(c.out, "(glox) ")
+		line, err := c.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(c.out)
+			c.quit = true
+			c.d.resume(stepTerminate)
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, arg := fields[0], strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		frames := c.d.frames()
+		switch cmd {
+		case "help", "h":
+			fmt.Fprintln(c.out, debugHelp)
+		case "break", "b", "delete", "d":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 || n > len(c.d.lines) {
+				fmt.Fprintln(c.out, "expected a line number")
+				continue
+			}
+			c.d.mu.Lock()
+			c.d.breakpoints[n] = cmd == "break" || cmd == "b"
+			c.d.mu.Unlock()
+		case "continue", "c":
+			c.d.resume(stepContinue)
+			return
+		case "step", "s":
+			c.d.resume(stepInto)
+			return
+		case "next", "n":
+			c.d.resume(stepOver)
+			return
+		case "out", "o":
+			c.d.resume(stepOut)
+			return
+		case "quit", "q":
+			c.quit = true
+			c.d.resume(stepTerminate)
+			return
+		case "locals", "l":
+			vs := c.d.scopeVariables(frames[frame].env)
+			if len(vs) == 0 {
+				fmt.Fprintln(c.out, "no local variables")
+			}
+			for _, v := range vs {
+				fmt.Fprintf(c.out, "%s = %s\n", v.name, debugValue(v.value))
+			}
+		case "globals":
+			for _, v := range environmentVariables(c.d.it.globals) {
+				fmt.Fprintf(c.out, "%s = %s\n", v.name, debugValue(v.value))
+			}
+		case "print", "p":
+			v, err := c.d.evaluate(arg, frames[frame].env)
+			if err != nil {
+				fmt.Fprintln(c.out, "error:", err)
+			} else {
+				fmt.Fprintln(c.out, debugValue(v))
+			}
+		case "stack", "bt":
+			for k, f := range frames {
+				mark := " "
+				if k == frame {
+					mark = "*"
+				}
+				fmt.Fprintf(c.out, "%s#%d %s at line %d\n", mark, k, f.function, f.line)
+			}
+		case "frame", "f":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n >= len(frames) {
+				fmt.Fprintf(c.out, "expected a frame number between 0 and %d\n", len(frames)-1)
+				continue
+			}
+			frame = n
+			c.showLine(frames[n].line)
+		case "list":
+			at := frames[frame].line
+			for n := at - 3; n <= at+3; n++ {
+				if n >= 1 && n <= len(c.d.lines) {
+					mark := "  "
+					if n == at {
+						mark = "=>"
+					}
+					fmt.Fprintf(c.out, "%s %4d  %s\n", mark, n, c.d.lines[n-1])
+				}
+			}
+		default:
+			fmt.Fprintf(c.out, "unknown command %q; type \"help\" for a list of commands\n", cmd)
+		}
+	}
+}
+
+func (c *debugConsole) showLine(n int) {
+	if n >= 1 && n <= len(c.d.lines) {
+		fmt.Fprintf(c.out, "%4d  %s\n", n, c.d.lines[n-1])
+	}
+}
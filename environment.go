@@ -5,6 +5,9 @@ import "fmt"
 type environment struct {
 	enclosing *environment
 	values    map[string]interface{}
+	// constants maps the names of the constants defined in the environment
+	// to the token that declared them.
+	constants map[string]token
 }
 
 func newEnvironment() *environment {
@@ -19,6 +22,24 @@ func (e *environment) define(name string, v interface{}) {
 	e.values[name] = v
 }
 
+// defineConstant defines a name that cannot be assigned to.
+func (e *environment) defineConstant(name token, v interface{}) {
+	if e.constants == nil {
+		e.constants = map[string]token{}
+	}
+	e.values[name.lexeme] = v
+	e.constants[name.lexeme] = name
+}
+
+// checkRedeclaration rejects declaring name again where it is a constant,
+// which only the global scope allows.
+func (e *environment) checkRedeclaration(name token) {
+	if decl, ok := e.constants[name.lexeme]; ok {
+		reportRuntimeError(name, fmt.Sprintf("Cannot redeclare constant '%s' declared at line %d:%d.",
+			name.lexeme, decl.line, decl.column))
+	}
+}
+
 func (e *environment) get(name token) interface{} {
 	v, ok := e.values[name.lexeme]
 	if !ok && e.enclosing != nil {
@@ -41,9 +62,18 @@ func (e *environment) assign(name token, v interface{}) {
 	} else if !ok {
 		reportRuntimeError(name, fmt.Sprintf("Undefined variable: '%s'", name.lexeme))
 	}
+	if decl, ok := e.constants[name.lexeme]; ok {
+		reportRuntimeError(name, constantAssignment(name, decl))
+	}
 	e.values[name.lexeme] = v
 }
 
+// constantAssignment describes the assignment of name to the constant
+// declared by decl.
+func constantAssignment(name, decl token) string {
+	return fmt.Sprintf("Cannot assign to constant '%s' declared at line %d:%d.", name.lexeme, decl.line, decl.column)
+}
+
 func (e *environment) assignAt(dist int, name token, v interface{}) {
 	e.ancestor(dist).assign(name, v)
 }
@@ -17,6 +17,11 @@ type exprVisitor interface {
 	visitSetExpr(e exprSet) interface{}
 	visitThisExpr(e exprThis) interface{}
 	visitSuperExpr(e exprSuper) interface{}
+	visitIndexExpr(e exprIndex) interface{}
+	visitIndexSetExpr(e exprIndexSet) interface{}
+	visitListExpr(e exprList) interface{}
+	visitMapExpr(e exprMap) interface{}
+	visitSpawnExpr(e exprSpawn) interface{}
 }
 
 type exprBinary struct {
@@ -29,7 +34,8 @@ func (e exprBinary) accept(v exprVisitor) interface{} {
 }
 
 type exprGrouping struct {
-	exp expr
+	exp         expr
+	open, close token
 }
 
 func (e exprGrouping) accept(v exprVisitor) interface{} {
@@ -38,6 +44,10 @@ func (e exprGrouping) accept(v exprVisitor) interface{} {
 
 type exprLiteral struct {
 	value interface{}
+	// token is the literal as written in the source. It is the zero token
+	// for literals synthesized by the parser, and only has a position for
+	// those the optimizer folded.
+	token token
 }
 
 func (e exprLiteral) accept(v exprVisitor) interface{} {
@@ -122,3 +132,49 @@ type exprSuper struct {
 func (e exprSuper) accept(v exprVisitor) interface{} {
 	return v.visitSuperExpr(e)
 }
+
+type exprIndex struct {
+	obj, index expr
+	bracket    token
+}
+
+func (e exprIndex) accept(v exprVisitor) interface{} {
+	return v.visitIndexExpr(e)
+}
+
+type exprIndexSet struct {
+	obj, index, value expr
+	bracket           token
+}
+
+func (e exprIndexSet) accept(v exprVisitor) interface{} {
+	return v.visitIndexSetExpr(e)
+}
+
+type exprList struct {
+	bracket, close token
+	elements       []expr
+}
+
+func (e exprList) accept(v exprVisitor) interface{} {
+	return v.visitListExpr(e)
+}
+
+type exprMap struct {
+	brace, close token
+	keys, values []expr
+}
+
+func (e exprMap) accept(v exprVisitor) interface{} {
+	return v.visitMapExpr(e)
+}
+
+// exprSpawn starts a call on a task of its own and evaluates to the task.
+type exprSpawn struct {
+	keyword token
+	call    exprCall
+}
+
+func (e exprSpawn) accept(v exprVisitor) interface{} {
+	return v.visitSpawnExpr(e)
+}
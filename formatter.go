@@ -0,0 +1,518 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formatter pretty-prints a parsed program in the canonical glox style: two
+// space indentation, opening braces on the line of the statement they
+// belong to and single spaces around binary operators. Comments are kept
+// before the statement they precede or at the end of the line they ended.
+// A statement with a comment inside it, say between the arguments of a
+// call, is printed as written so that the comment stays where it was.
+// At most one blank line of the original spacing between statements is
+// preserved.
+type formatter struct {
+	b      strings.Builder
+	indent int
+	// comments are the comments not printed yet, in source order, and
+	// tokens the other tokens of the source.
+	comments, tokens []token
+	// lines are the lines of the source.
+	lines []string
+	// lastLine is the source line of the last statement or comment printed.
+	// It is zero at the start of a block, where blank lines are dropped.
+	lastLine int
+}
+
+// formatSource formats a whole script. It reports false if the script has
+// syntax errors, which the parser has already printed.
+func formatSource(file, source string) (string, bool) {
+	hadParserError = false
+	sc := &scanner{source: source, file: file, keepComments: true}
+	ts := sc.scanTokens()
+	if hadParserError {
+		return "", false
+	}
+	p := &parser{tokens: ts}
+	ss := p.parse()
+	if hadParserError {
+		return "", false
+	}
+
+	f := &formatter{comments: sc.comments, tokens: ts, lines: strings.Split(source, "\n")}
+	f.statements(ss, ts[len(ts)-1])
+	return f.b.String(), true
+}
+
+// statements prints a list of statements followed by the comments that
+// precede end, the token that closes the list.
+func (f *formatter) statements(ss []stmt, end token) {
+	for _, s := range ss {
+		f.commentsBefore(stmtStart(s))
+		f.startLine(stmtStart(s).line)
+		last, ok := f.verbatim(s)
+		if !ok {
+			f.stmt(s)
+			last = stmtEnd(s)
+		}
+		f.endLine(last)
+	}
+	f.commentsBefore(end)
+}
+
+// verbatim prints s as written if a comment appears inside it other than
+// in one of its blocks, and returns the last token printed.
+func (f *formatter) verbatim(s stmt) (token, bool) {
+	start, last := stmtStart(s), f.last(s)
+	k, inside := 0, false
+	for ; k < len(f.comments) && precedes(f.comments[k], last); k++ {
+		inside = inside || !f.between(s, f.comments[k])
+	}
+	if !inside {
+		return token{}, false
+	}
+	f.comments = f.comments[k:]
+	endLine, endColumn := tokenEnd(last)
+	f.b.WriteString(f.text(start.line, start.column, endLine, endColumn))
+	return last, true
+}
+
+// last returns the last token of s, including the semicolon that ends it.
+func (f *formatter) last(s stmt) token {
+	last := stmtEnd(s)
+	if next := f.after(last); next.tt == tokenTypeSemicolon {
+		return next
+	}
+	return last
+}
+
+// between reports whether the comment c lies in a block of s, or between
+// the methods of a class, where the formatter keeps it in place.
+func (f *formatter) between(s stmt, c token) bool {
+	switch s := s.(type) {
+	case stmtBlock:
+		// The statements of the block are printed on their own, as
+		// written if need be.
+		return precedes(s.open, c) && precedes(c, s.close)
+	case stmtIf:
+		return f.between(s.thenBranch, c) || s.elseBranch != nil && f.between(s.elseBranch, c)
+	case stmtWhile:
+		return f.between(s.body, c)
+	case stmtFor:
+		return f.between(s.body, c)
+	case stmtForIn:
+		return f.between(s.body, c)
+	case stmtFunction:
+		return f.between(s.body, c)
+	case stmtClass:
+		open := f.after(s.name)
+		if s.superClass != nil {
+			open = f.after(s.superClass.name)
+		}
+		if !precedes(open, c) || !precedes(c, s.close) {
+			return false
+		}
+		for _, m := range s.methods {
+			if precedes(m.name, c) && precedes(c, m.body.close) {
+				return f.between(m, c)
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// text returns the source from line:column up to endLine:endColumn, with
+// columns counted in runes.
+func (f *formatter) text(line, column, endLine, endColumn int) string {
+	var b strings.Builder
+	for l := line; l <= endLine; l++ {
+		rs := []rune(f.lines[l-1])
+		from, to := 0, len(rs)
+		if l == line {
+			from = column - 1
+		}
+		if l == endLine {
+			to = endColumn - 1
+		}
+		if l > line {
+			b.WriteByte('\n')
+		}
+		b.WriteString(string(rs[from:to]))
+	}
+	return b.String()
+}
+
+// commentsBefore prints, each on its own line, the comments that appear in
+// the source before t.
+func (f *formatter) commentsBefore(t token) {
+	for len(f.comments) > 0 {
+		c := f.comments[0]
+		if c.line > t.line || (c.line == t.line && c.column > t.column) {
+			return
+		}
+		f.comments = f.comments[1:]
+		f.startLine(c.line)
+		f.b.WriteString(c.lexeme)
+		f.lastLine, _ = tokenEnd(c)
+		f.b.WriteByte('\n')
+	}
+}
+
+// startLine starts a new output line for something that was on the given
+// source line, keeping a single blank line if there was one.
+func (f *formatter) startLine(line int) {
+	if f.lastLine > 0 && line > f.lastLine+1 {
+		f.b.WriteByte('\n')
+	}
+	f.b.WriteString(strings.Repeat("  ", f.indent))
+}
+
+// endLine ends the output line of something whose last token is last,
+// appending the comments that end its source line. Comments that follow
+// more code on that line stay for the code they follow.
+func (f *formatter) endLine(last token) {
+	f.lastLine, _ = tokenEnd(last)
+	next := f.after(last)
+	if next.tt == tokenTypeSemicolon {
+		// The spans of statements end before their semicolon.
+		next = f.after(next)
+	}
+	for len(f.comments) > 0 {
+		c := f.comments[0]
+		if c.line != f.lastLine || strings.Contains(c.lexeme, "\n") || precedes(next, c) {
+			break
+		}
+		f.b.WriteString(" " + c.lexeme)
+		f.comments = f.comments[1:]
+	}
+	f.b.WriteByte('\n')
+}
+
+// after returns the token that follows t in the source.
+func (f *formatter) after(t token) token {
+	k := sort.Search(len(f.tokens), func(k int) bool { return precedes(t, f.tokens[k]) })
+	if k == len(f.tokens) {
+		return f.tokens[len(f.tokens)-1]
+	}
+	return f.tokens[k]
+}
+
+// precedes reports whether a starts before b in the source.
+func precedes(a, b token) bool {
+	return a.line < b.line || (a.line == b.line && a.column < b.column)
+}
+
+func (f *formatter) stmt(s stmt) {
+	switch s := s.(type) {
+	case stmtExpression:
+		f.b.WriteString(f.expr(s.e) + ";")
+	case stmtPrint:
+		f.b.WriteString("print " + f.expr(s.e) + ";")
+	case stmtVar:
+		f.b.WriteString(s.keyword.lexeme + " " + annotated(s.name, s.typ))
+		if s.initializer != nil {
+			f.b.WriteString(" = " + f.expr(s.initializer))
+		}
+		f.b.WriteString(";")
+	case stmtReturn:
+		f.b.WriteString("return")
+		if s.value != nil {
+			f.b.WriteString(" " + f.expr(s.value))
+		}
+		f.b.WriteString(";")
+	case stmtYield:
+		f.b.WriteString("yield")
+		if s.value != nil {
+			f.b.WriteString(" " + f.expr(s.value))
+		}
+		f.b.WriteString(";")
+	case stmtBlock:
+		f.block(s)
+	case stmtIf:
+		f.b.WriteString("if (" + f.expr(s.condition) + ")")
+		f.body(s.thenBranch)
+		if s.elseBranch == nil {
+			return
+		}
+		if _, ok := s.thenBranch.(stmtBlock); ok {
+			f.b.WriteString(" else")
+		} else {
+			f.endLine(stmtEnd(s.thenBranch))
+			f.b.WriteString(strings.Repeat("  ", f.indent) + "else")
+		}
+		if elseIf, ok := s.elseBranch.(stmtIf); ok {
+			f.b.WriteString(" ")
+			f.stmt(elseIf)
+		} else {
+			f.body(s.elseBranch)
+		}
+	case stmtWhile:
+		f.b.WriteString("while (" + f.expr(s.condition) + ")")
+		f.body(s.body)
+	case stmtForIn:
+		f.b.WriteString("for (" + s.name.lexeme + " in " + f.expr(s.iterable) + ")")
+		f.body(s.body)
+	case stmtFor:
+		f.b.WriteString("for (")
+		if s.initializer != nil {
+			f.stmt(s.initializer)
+		} else {
+			f.b.WriteString(";")
+		}
+		if s.condition != nil {
+			f.b.WriteString(" " + f.expr(s.condition))
+		}
+		f.b.WriteString(";")
+		if s.increment != nil {
+			f.b.WriteString(" " + f.expr(s.increment))
+		}
+		f.b.WriteString(")")
+		f.body(s.body)
+	case stmtFunction:
+		f.b.WriteString("fun")
+		if s.generator {
+			f.b.WriteString("*")
+		}
+		f.b.WriteString(" ")
+		f.function(s)
+	case stmtClass:
+		f.b.WriteString("class " + s.name.lexeme)
+		if s.superClass != nil {
+			f.b.WriteString(" < " + s.superClass.name.lexeme)
+		}
+		f.b.WriteString(" {")
+		if len(s.methods) == 0 && !f.hasCommentsBefore(s.close) {
+			f.b.WriteString("}")
+			return
+		}
+		if s.superClass != nil {
+			f.endLine(f.after(s.superClass.name))
+		} else {
+			f.endLine(f.after(s.name))
+		}
+		f.indent++
+		f.lastLine = 0
+		for _, m := range s.methods {
+			f.commentsBefore(m.name)
+			f.startLine(m.name.line)
+			if m.generator {
+				f.b.WriteString("*")
+			}
+			f.function(m)
+			f.endLine(m.body.close)
+		}
+		f.commentsBefore(s.close)
+		f.indent--
+		f.b.WriteString(strings.Repeat("  ", f.indent) + "}")
+	}
+}
+
+// body prints the body of a control flow statement: blocks open on the
+// same line and single statements follow on the same line.
+func (f *formatter) body(s stmt) {
+	f.b.WriteString(" ")
+	f.stmt(s)
+}
+
+func (f *formatter) block(s stmtBlock) {
+	f.b.WriteString("{")
+	if len(s.statements) == 0 && !f.hasCommentsBefore(s.close) {
+		f.b.WriteString("}")
+		return
+	}
+	f.endLine(s.open)
+	f.indent++
+	f.lastLine = 0
+	f.statements(s.statements, s.close)
+	f.indent--
+	f.b.WriteString(strings.Repeat("  ", f.indent) + "}")
+}
+
+// function prints a function or method without the fun keyword.
+func (f *formatter) function(s stmtFunction) {
+	ps := make([]string, len(s.params))
+	for k, p := range s.params {
+		ps[k] = annotated(p, s.paramTypes[k])
+	}
+	f.b.WriteString(s.name.lexeme + "(" + strings.Join(ps, ", ") + ")")
+	if s.returnType != nil {
+		f.b.WriteString(": " + s.returnType.String())
+	}
+	f.b.WriteString(" ")
+	f.block(s.body)
+}
+
+// annotated prints a name followed by its type annotation, if any.
+func annotated(name token, t *typeAnnotation) string {
+	if t == nil {
+		return name.lexeme
+	}
+	return name.lexeme + ": " + t.String()
+}
+
+func (f *formatter) hasCommentsBefore(t token) bool {
+	if len(f.comments) == 0 {
+		return false
+	}
+	c := f.comments[0]
+	return c.line < t.line || (c.line == t.line && c.column < t.column)
+}
+
+func (f *formatter) expr(e expr) string {
+	switch e := e.(type) {
+	case exprBinary:
+		return f.expr(e.left) + " " + e.operator.lexeme + " " + f.expr(e.right)
+	case exprLogical:
+		return f.expr(e.left) + " " + e.operator.lexeme + " " + f.expr(e.right)
+	case exprGrouping:
+		return "(" + f.expr(e.exp) + ")"
+	case exprLiteral:
+		if e.token.lexeme != "" {
+			return e.token.lexeme
+		}
+		return stringify(e.value)
+	case exprUnary:
+		return e.operator.lexeme + f.expr(e.right)
+	case exprVariable:
+		return e.name.lexeme
+	case exprAssign:
+		return e.name.lexeme + " = " + f.expr(e.value)
+	case exprCall:
+		return f.expr(e.callee) + "(" + f.exprs(e.args) + ")"
+	case exprSpawn:
+		return "spawn " + f.expr(e.call)
+	case exprGet:
+		return f.expr(e.obj) + "." + e.name.lexeme
+	case exprSet:
+		return f.expr(e.obj) + "." + e.name.lexeme + " = " + f.expr(e.value)
+	case exprThis:
+		return "this"
+	case exprSuper:
+		return "super." + e.method.lexeme
+	case exprIndex:
+		return f.expr(e.obj) + "[" + f.expr(e.index) + "]"
+	case exprIndexSet:
+		return f.expr(e.obj) + "[" + f.expr(e.index) + "] = " + f.expr(e.value)
+	case exprList:
+		return "[" + f.exprs(e.elements) + "]"
+	case exprMap:
+		es := make([]string, len(e.keys))
+		for k := range e.keys {
+			es[k] = f.expr(e.keys[k]) + ": " + f.expr(e.values[k])
+		}
+		return "{" + strings.Join(es, ", ") + "}"
+	}
+	return ""
+}
+
+func (f *formatter) exprs(es []expr) string {
+	ss := make([]string, len(es))
+	for k, e := range es {
+		ss[k] = f.expr(e)
+	}
+	return strings.Join(ss, ", ")
+}
+
+// fmtCommand implements "glox fmt". Without flags it prints the formatted
+// scripts; -w rewrites them, -l lists the ones whose formatting differs and
+// -d prints a diff.
+func fmtCommand(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the source file instead of stdout")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	diff := fs.Bool("d", false, "display diffs instead of rewriting files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox fmt [-w] [-l] [-d] [path ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		bs, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out, ok := formatSource("<stdin>", string(bs))
+		if !ok {
+			return 1
+		}
+		fmt.Print(out)
+		return 0
+	}
+
+	files, err := scriptFiles(paths, ".glox")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, name := range files {
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		out, ok := formatSource(filepath.Base(name), string(bs))
+		if !ok {
+			status = 1
+			continue
+		}
+		changed := out != string(bs)
+		if *list && changed {
+			fmt.Println(name)
+		}
+		if *diff && changed {
+			fmt.Print(unifiedDiff(name+".orig", name, string(bs), out))
+		}
+		if *write && changed {
+			if err := ioutil.WriteFile(name, []byte(out), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 1
+			}
+		}
+		if !*list && !*diff && !*write {
+			fmt.Print(out)
+		}
+	}
+	return status
+}
+
+// scriptFiles expands the directories in paths to the files below them
+// whose names end with suffix. Files named explicitly are kept as given.
+func scriptFiles(paths []string, suffix string) ([]string, error) {
+	var ret []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			ret = append(ret, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(path, suffix) {
+				ret = append(ret, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
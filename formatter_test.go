@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, c := range []struct {
+		name, source, want string
+	}{
+		{
+			name:   "layout",
+			source: "var a=1;fun f(x){if(x>a){print x;}else print a;}\n",
+			want:   "var a = 1;\nfun f(x) {\n  if (x > a) {\n    print x;\n  } else print a;\n}\n",
+		},
+		{
+			name:   "blank lines",
+			source: "print 1;\n\n\n\nprint 2;\nprint 3;\n",
+			want:   "print 1;\n\nprint 2;\nprint 3;\n",
+		},
+		{
+			name:   "comments between statements",
+			source: "// head\nprint 1; // one\n\n/* two */\nprint 2;\n// tail\n",
+			want:   "// head\nprint 1; // one\n\n/* two */\nprint 2;\n// tail\n",
+		},
+		{
+			name:   "comments in blocks",
+			source: "class A { // a\n  // m\n  m() { // body\n    print 1;\n    // end\n  }\n}\n",
+			want:   "class A { // a\n  // m\n  m() { // body\n    print 1;\n    // end\n  }\n}\n",
+		},
+		{
+			name:   "comment in a call",
+			source: "f(1, // one\n  2);\nprint 3;\n",
+			want:   "f(1, // one\n  2);\nprint 3;\n",
+		},
+		{
+			name:   "comment in a binary expression",
+			source: "print 1 /* inline */ + 2;\n",
+			want:   "print 1 /* inline */ + 2;\n",
+		},
+		{
+			name:   "comment in a header",
+			source: "fun f(a /* first */, b) {print a;}\n",
+			want:   "fun f(a /* first */, b) {print a;}\n",
+		},
+		{
+			name:   "comment in a nested statement",
+			source: "fun f() {\nvar x=1;\n  print g(1,\n  // own\n    2);\n\n\n  print 3;\n}\n",
+			want:   "fun f() {\n  var x = 1;\n  print g(1,\n  // own\n    2);\n\n  print 3;\n}\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := glox(t, "fmt", script(t, c.source))
+			if got.stdout != c.want || got.status != 0 {
+				t.Fatalf("got %q (status %d), want %q", got.stdout, got.status, c.want)
+			}
+			if again := glox(t, "fmt", script(t, got.stdout)); again.stdout != got.stdout {
+				t.Errorf("formatting twice: got %q, want %q", again.stdout, got.stdout)
+			}
+		})
+	}
+}
+
+// TestFormatPrograms checks that formatting the example programs twice
+// changes nothing the second time.
+func TestFormatPrograms(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("programs", "*.glox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			once := glox(t, "fmt", f)
+			if once.status != 0 {
+				t.Fatalf("got status %d: %s", once.status, once.stderr)
+			}
+			if twice := glox(t, "fmt", script(t, once.stdout)); twice.stdout != once.stdout {
+				t.Errorf("formatting twice: got %q, want %q", twice.stdout, once.stdout)
+			}
+		})
+	}
+}
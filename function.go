@@ -1,7 +1,5 @@
 package main
 
-import "fmt"
-
 type loxFunction struct {
 	declaration   stmtFunction
 	closure       *environment
@@ -10,15 +8,35 @@ type loxFunction struct {
 
 var _ callable = loxFunction{}
 
-func (l loxFunction) call(i *interpreter, args []interface{}) (v interface{}) {
+func (l loxFunction) call(i *interpreter, paren token, args []interface{}) interface{} {
+	if l.declaration.generator {
+		return i.newIterator(l, paren, args)
+	}
+	return l.invoke(i, paren, args)
+}
+
+// invoke runs the body of the function.
+func (l loxFunction) invoke(i *interpreter, paren token, args []interface{}) (v interface{}) {
+	i.pushFrame(l.declaration.name.lexeme, paren)
+	defer i.popFrame()
+
 	env := newEnvironmentWithParent(l.closure)
 	for i, arg := range args {
 		env.define(l.declaration.params[i].lexeme, arg)
 	}
 	defer func() {
 		if raw := recover(); raw != nil {
+			if raw == errGeneratorStopped {
+				// The goroutine of a dropped generator unwinds without
+				// holding the lock, so the hooks must not see it.
+				panic(raw)
+			}
 			rawValue, ok := raw.(returnValue)
 			if !ok {
+				i.annotateTrace(raw)
+				if len(i.hooks) > 0 {
+					i.hookLeave(l, nil, true)
+				}
 				panic(raw)
 			}
 			v = rawValue.value
@@ -27,7 +45,13 @@ func (l loxFunction) call(i *interpreter, args []interface{}) (v interface{}) {
 		if l.isInitializer {
 			v = l.closure.getAt(0, token{lexeme: "this"})
 		}
+		if len(i.hooks) > 0 {
+			i.hookLeave(l, v, false)
+		}
 	}()
+	if len(i.hooks) > 0 {
+		i.hookEnter(l, args)
+	}
 	i.executeBlock(l.declaration.body, env)
 	return
 }
@@ -37,7 +61,7 @@ func (l loxFunction) arity() int {
 }
 
 func (l loxFunction) String() string {
-	return fmt.Sprintf("<fn %s>", l.declaration.name.lexeme)
+	return stringify(l)
 }
 
 func (l loxFunction) bind(inst loxInstance) loxFunction {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"unicode/utf8"
+)
+
+// Calling a generator function returns an iterator, whose next method runs
+// the body until it yields a value. The interpreter cannot suspend a tree
+// walk halfway, so the body runs on a goroutine of its own, which next hands
+// control to and waits for. The two never run at the same time, so the
+// body runs Lox code under the lock its caller holds.
+
+// loxIterator is what calling a generator returns.
+type loxIterator struct {
+	name string
+	*generator
+}
+
+// generator is the state the goroutine of a generator shares with its
+// iterator. The goroutine does not refer to the iterator, so that an
+// iterator dropped before its generator returned can be collected, which
+// stops the goroutine.
+type generator struct {
+	// resume hands control to the goroutine, which hands it back over
+	// yields with each value and closes yields once the body has returned.
+	// Closing resume stops the goroutine.
+	resume chan struct{}
+	yields chan interface{}
+
+	started, running, finished bool
+	// err is what aborted the body, if anything.
+	err interface{}
+	// depth is the number of frames below the generator's, which counts
+	// toward maxCallDepth.
+	depth int
+	// start starts the goroutine.
+	start func()
+}
+
+// errGeneratorStopped unwinds the goroutine of a dropped iterator.
+var errGeneratorStopped = errors.New("generator stopped")
+
+// newIterator returns an iterator over the values that the call of f with
+// args yields.
+func (i *interpreter) newIterator(f loxFunction, paren token, args []interface{}) *loxIterator {
+	i.allocate(paren)
+	g := &generator{resume: make(chan struct{}), yields: make(chan interface{})}
+	it := &loxIterator{name: f.declaration.name.lexeme, generator: g}
+	runtime.SetFinalizer(it, func(it *loxIterator) {
+		close(it.resume)
+	})
+	body := &interpreter{shared: i.shared, env: i.globals, generator: g, hooks: i.hooks}
+	g.start = func() {
+		go body.runGenerator(f, paren, args)
+	}
+	return it
+}
+
+// runGenerator runs the body of a generator on its goroutine.
+func (i *interpreter) runGenerator(f loxFunction, paren token, args []interface{}) {
+	defer func() {
+		if r := recover(); r != nil && r != errGeneratorStopped {
+			i.generator.err = r
+		}
+		close(i.generator.yields)
+	}()
+	if _, ok := <-i.generator.resume; !ok {
+		return
+	}
+	i.depth = i.generator.depth
+	f.invoke(i, paren, args)
+}
+
+func (i *interpreter) visitYieldStatement(s stmtYield) interface{} {
+	var v interface{}
+	if s.value != nil {
+		v = i.evaluate(s.value)
+	}
+	g := i.generator
+	g.yields <- v
+	if _, ok := <-g.resume; !ok {
+		panic(errGeneratorStopped)
+	}
+	i.depth = g.depth
+	return nil
+}
+
+// next runs the generator until it yields a value, which it returns, or
+// returns false once the body has returned. An error that aborted the body
+// is raised again, with the frames of the caller added to its trace.
+func (it *loxIterator) next(i *interpreter, paren token) (interface{}, bool) {
+	if it.finished {
+		return nil, false
+	}
+	if it.running {
+		reportRuntimeError(paren, "Generator "+it.name+" is already running.")
+	}
+	if !it.started {
+		it.started = true
+		it.start()
+	}
+	it.running = true
+	it.depth = i.depth + len(i.frames)
+	it.resume <- struct{}{}
+	v, ok := <-it.yields
+	it.running = false
+	if ok {
+		return v, true
+	}
+	it.finished = true
+	if err, ok := it.err.(*runtimeError); ok && len(err.trace) > 0 {
+		err.trace = append(err.trace[:len(err.trace)-1], i.stackTrace(paren)...)
+	}
+	if it.err != nil {
+		panic(it.err)
+	}
+	return nil, false
+}
+
+// method returns the method of an iterator named name.
+func (it *loxIterator) method(name token) interface{} {
+	switch name.lexeme {
+	case "next":
+		return nativeFunction{name: "next", fn: func(i *interpreter, paren token, _ []interface{}) interface{} {
+			v, _ := it.next(i, paren)
+			return v
+		}}
+	case "done":
+		return nativeFunction{name: "done", fn: func(*interpreter, token, []interface{}) interface{} {
+			return it.finished
+		}}
+	}
+	reportRuntimeError(name, "Undefined property '"+name.lexeme+"'.")
+	return nil
+}
+
+// iterate returns a function that returns the values of a list, the
+// characters of a string, the keys of a map or the values of an iterator
+// one by one, and false after the last.
+func (i *interpreter) iterate(t token, v interface{}) func() (interface{}, bool) {
+	switch v := v.(type) {
+	case *loxList:
+		k := 0
+		return func() (interface{}, bool) {
+			if k >= len(v.elements) {
+				return nil, false
+			}
+			k++
+			return v.elements[k-1], true
+		}
+	case string:
+		return func() (interface{}, bool) {
+			if v == "" {
+				return nil, false
+			}
+			r, n := utf8.DecodeRuneInString(v)
+			v = v[n:]
+			return string(r), true
+		}
+	case *loxMap:
+		keys := append([]interface{}(nil), v.keys...)
+		return func() (interface{}, bool) {
+			if len(keys) == 0 {
+				return nil, false
+			}
+			k := keys[0]
+			keys = keys[1:]
+			return k, true
+		}
+	case *loxIterator:
+		return func() (interface{}, bool) {
+			return v.next(i, t)
+		}
+	}
+	reportRuntimeError(t, "Can only iterate over lists, strings, maps and iterators.")
+	return nil
+}
+
+func (i *interpreter) visitForInStatement(s stmtForIn) interface{} {
+	next := i.iterate(s.keyword, i.evaluate(s.iterable))
+	for {
+		v, ok := next()
+		if !ok {
+			return nil
+		}
+		i.step(s.keyword)
+		env := newEnvironmentWithParent(i.env)
+		env.define(s.name.lexeme, v)
+		i.executeIn(s.body, env)
+	}
+}
@@ -1,3 +1,3 @@
-module main
+module github.com/mathetake/glox
 
-go 1.14
+go 1.16
@@ -0,0 +1,41 @@
+package main
+
+// hook observes the execution of a script. The debugger and other tools
+// are built on hooks, which are installed with withHook.
+type hook interface {
+	// statement is called before s is executed.
+	statement(i *interpreter, s stmt)
+	// enter is called when a Lox function is called, once its frame is on
+	// the call stack. i.env is still the environment of the caller.
+	enter(i *interpreter, f loxFunction, args []interface{})
+	// leave is called when the call of f ends, before its frame is popped.
+	// value is what it returned, unless failed reports that the call was
+	// aborted by an error.
+	leave(i *interpreter, f loxFunction, value interface{}, failed bool)
+}
+
+// withHook installs a hook. Hooks are called in the order they were
+// installed.
+func withHook(h hook) option {
+	return func(i *interpreter) {
+		i.hooks = append(i.hooks, h)
+	}
+}
+
+func (i *interpreter) hookStatement(s stmt) {
+	for _, h := range i.hooks {
+		h.statement(i, s)
+	}
+}
+
+func (i *interpreter) hookEnter(f loxFunction, args []interface{}) {
+	for _, h := range i.hooks {
+		h.enter(i, f, args)
+	}
+}
+
+func (i *interpreter) hookLeave(f loxFunction, value interface{}, failed bool) {
+	for _, h := range i.hooks {
+		h.leave(i, f, value, failed)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// lineRecorder is a hook that records the lines of the statements executed
+// and the functions called.
+type lineRecorder struct {
+	lines map[int]int
+	calls map[string]int
+}
+
+func (r *lineRecorder) statement(_ *interpreter, s stmt) {
+	r.lines[stmtStart(s).line]++
+}
+
+func (r *lineRecorder) enter(_ *interpreter, f loxFunction, _ []interface{}) {
+	r.calls[f.declaration.name.lexeme]++
+}
+
+func (r *lineRecorder) leave(*interpreter, loxFunction, interface{}, bool) {}
+
+// record runs a script with a lineRecorder and the tracer installed and
+// returns what they saw.
+func record(t *testing.T, source string) (*lineRecorder, string) {
+	t.Helper()
+	hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	r := &lineRecorder{lines: map[int]int{}, calls: map[string]int{}}
+	var trace bytes.Buffer
+	it := newInterpreter(withOutput(ioutil.Discard), withDiagnostics(&trace), withHook(r), withHook(&tracer{}))
+	if err := run(it, "hooks.glox", source, false); err != nil {
+		t.Fatal(err)
+	}
+	return r, trace.String()
+}
+
+func TestHooksSeeGenerators(t *testing.T) {
+	r, trace := record(t, `fun* count(n) {
+  for (var i = 0; i < n; i = i + 1) {
+    yield i;
+  }
+}
+for (x in count(2)) print x;
+`)
+	if r.calls["count"] != 1 || r.lines[3] != 2 {
+		t.Errorf("got calls %v and lines %v, want count called once and line 3 run twice", r.calls, r.lines)
+	}
+	want := `hooks.glox:1: fun* count(n)
+hooks.glox:6: for (x in count(2))
+call count(n=2)
+  hooks.glox:2: for (var i = 0; i < n; i = i + 1)
+  hooks.glox:2: var i = 0;
+  hooks.glox:3: yield i;
+hooks.glox:6: print x;
+  hooks.glox:3: yield i;
+hooks.glox:6: print x;
+count returned nil
+`
+	if trace != want {
+		t.Errorf("got trace\n%s\nwant\n%s", trace, want)
+	}
+}
+
+func TestHooksSeeTasks(t *testing.T) {
+	r, trace := record(t, `fun double(x) {
+  return x * 2;
+}
+print await(spawn double(21));
+`)
+	if r.calls["double"] != 1 || r.lines[2] != 1 {
+		t.Errorf("got calls %v and lines %v, want double called once and line 2 run once", r.calls, r.lines)
+	}
+	want := `hooks.glox:1: fun double(x)
+hooks.glox:4: print await(spawn double(21));
+call double(x=21)
+  hooks.glox:2: return x * 2;
+double returned 42
+`
+	if trace != want {
+		t.Errorf("got trace\n%s\nwant\n%s", trace, want)
+	}
+}
+
+// TestDroppedGenerator drops started generators with hooks installed. The
+// goroutines of the generators unwind without holding the lock once they
+// are collected, which the race detector reports if they call the hooks.
+func TestDroppedGenerator(t *testing.T) {
+	hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	p := newProfiler("dropped.glox")
+	it := newInterpreter(withOutput(ioutil.Discard), withDiagnostics(ioutil.Discard), withHook(p))
+	source := `fun* count(n) {
+  for (var i = 0; i < n; i = i + 1) yield i;
+}
+fun take() {
+  var it = count(100);
+  it.next();
+  return it.next();
+}
+for (var k = 0; k < 200; k = k + 1) take();
+`
+	if err := run(it, "dropped.glox", source, false); err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 5; k++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := run(it, "again.glox", "take();", false); err != nil {
+		t.Fatal(err)
+	}
+	p.finish()
+	for name, e := range p.functions {
+		if name.lexeme == "count" && e.count != 201 {
+			t.Errorf("got %d calls of count, want 201", e.count)
+		}
+	}
+}
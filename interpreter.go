@@ -1,23 +1,115 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"runtime/debug"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
+// interpreter runs a script on one goroutine. The main script and each task
+// it spawns have their own interpreter, with their own environment and call
+// stack, sharing everything else.
 type interpreter struct {
-	globals, env *environment
-	locals       map[expr]int
-}
-
-func newInterpreter() *interpreter {
+	*shared
+	env *environment
+
+	// frames is the stack of active Lox function calls, bounded by
+	// maxCallDepth together with the depth frames below them.
+	frames []callFrame
+	depth  int
+	// generator is set if this interpreter runs the body of a generator.
+	generator *generator
+
+	// hooks observe the execution, see hook.
+	hooks []hook
+}
+
+// shared is the state of an interpreter its tasks share. Goroutines only
+// use it while they hold lock, see task.go.
+type shared struct {
+	globals *environment
+	// locals maps the name token of each resolved local variable reference
+	// to the number of scopes between the reference and its declaration.
+	locals map[token]int
+
+	// ctx, timeout and maxSteps are the execution limits set by options.
+	ctx      context.Context
+	timeout  time.Duration
+	maxSteps int64
+
+	// stdout receives the output of print; diagnostics receives errors.
+	stdout, diagnostics io.Writer
+	stdin               *bufio.Reader
+
+	// capabilities are the groups of natives the script may use.
+	capabilities map[string]bool
+
+	maxCallDepth int
+
+	// maxStringLength, maxCollectionSize and maxAllocations cap the memory
+	// a script can use; allocations counts the objects allocated so far.
+	maxStringLength   int
+	maxCollectionSize int
+	maxAllocations    int64
+	allocations       int64
+
+	// execCtx is the context of the running interpret call, done caches its
+	// Done channel and steps counts the steps taken so far.
+	execCtx context.Context
+	done    <-chan struct{}
+	steps   int64
+
+	// optimize is set if scripts are optimized before they run.
+	optimize bool
+
+	// lock is held by the goroutine that is running Lox code; tasks counts
+	// the tasks that have not finished yet, and waiting are the goroutines
+	// waiting on channels and tasks. interpreting is set while interpret
+	// runs the main body, and settled is signalled when a goroutine starts
+	// to wait or a task ends. failures are the tasks that failed since
+	// interpret last reported them.
+	lock         sync.Mutex
+	tasks        int
+	waiting      []*waiter
+	interpreting bool
+	settled      chan struct{}
+	failures     []*loxTask
+	// stdinLock serializes readLine, which reads without holding lock.
+	stdinLock sync.Mutex
+}
+
+func newInterpreter(opts ...option) *interpreter {
 	gs := newEnvironment()
-	gs.define("clock", clock{})
-	return &interpreter{
-		globals: gs,
-		env:     gs,
-		locals:  map[expr]int{},
+	i := &interpreter{
+		shared: &shared{
+			globals:      gs,
+			locals:       map[token]int{},
+			ctx:          context.Background(),
+			maxCallDepth: defaultMaxCallDepth,
+			stdout:       os.Stdout,
+			diagnostics:  os.Stderr,
+			stdin:        bufio.NewReader(os.Stdin),
+			settled:      make(chan struct{}, 1),
+		},
+		env: gs,
+	}
+	withCapabilities(allCapabilities()...)(i)
+	for _, opt := range opts {
+		opt(i)
+	}
+	for name, n := range natives {
+		if n.capability == "" || i.capabilities[n.capability] {
+			gs.define(name, n)
+		}
 	}
+	return i
 }
 
 var (
@@ -25,17 +117,55 @@ var (
 	_ stmtVisitor = &interpreter{}
 )
 
-func (i *interpreter) interpret(ss []stmt) {
+// interpret executes the statements, then runs the tasks until each has
+// ended or waits, and returns the *runtimeError or *limitError that aborted
+// the statements or a task nothing awaited, if any. The errors are also
+// printed, along with the Lox call stack, to the diagnostics writer.
+func (i *interpreter) interpret(ss []stmt) (err error) {
+	ctx := i.ctx
+	if i.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.timeout)
+		defer cancel()
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.execCtx, i.done, i.steps, i.allocations = ctx, ctx.Done(), 0, 0
+	i.frames = i.frames[:0]
+	i.interpreting = true
+	defer func() {
+		i.interpreting = false
+	}()
+	// Runs after the recover below, once the error of the main body is
+	// known.
 	defer func() {
-		if err := recover(); err != nil {
-			fmt.Println(err)
-			fmt.Println(string(debug.Stack()))
+		err = i.reportFailures(err)
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case *runtimeError:
+				err = r
+			case *limitError:
+				err = r
+			case *exitStatus:
+				err = r
+				return
+			default:
+				// A panic that is not a Lox error is a bug in the interpreter.
+				fmt.Fprintln(i.diagnostics, r)
+				fmt.Fprintln(i.diagnostics, string(debug.Stack()))
+				err = fmt.Errorf("%v", r)
+				return
+			}
+			fmt.Fprintln(i.diagnostics, err)
 		}
 	}()
 
 	for _, s := range ss {
 		i.execute(s)
 	}
+	i.settle()
 	return
 }
 
@@ -43,52 +173,33 @@ func (i *interpreter) visitBinaryExpr(e exprBinary) interface{} {
 	left := i.evaluate(e.left)
 	right := i.evaluate(e.right)
 	switch e.operator.tt {
-	case tokenTypeMinus:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) - right.(float64)
-	case tokenTypeSlash:
-		i.checkNumberOperands(e.operator, left, right)
-		den := right.(float64)
-		if den == 0 {
-			reportRuntimeError(e.operator, "Division by zero")
-		}
-		return left.(float64) / den
-	case tokenTypeStar:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) * right.(float64)
+	case tokenTypeMinus, tokenTypeSlash, tokenTypeStar, tokenTypePercent:
+		return i.arithmetic(e.operator, left, right)
 	case tokenTypePlus:
-		ln, lok := left.(float64)
-		rn, rok := right.(float64)
-		if lok && rok {
-			return ln + rn
+		if isNumber(left) && isNumber(right) {
+			return i.arithmetic(e.operator, left, right)
 		}
 		sln, slok := left.(string)
 		srn, srok := right.(string)
 		if slok && srok {
+			i.checkStringLength(e.operator, len(sln)+len(srn))
 			return sln + srn
 		}
 		reportRuntimeError(e.operator, "Operands must be two numbers or two strings.")
-	case tokenTypeGreater:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) > right.(float64)
-	case tokenTypeGreaterEqual:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) >= right.(float64)
-	case tokenTypeLess:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) < right.(float64)
-	case tokenTypeLessEqual:
-		i.checkNumberOperands(e.operator, left, right)
-		return left.(float64) <= right.(float64)
+	case tokenTypeAmpersand, tokenTypePipe, tokenTypeCaret, tokenTypeLessLess, tokenTypeGreaterGreater:
+		return i.bitwise(e.operator, left, right)
+	case tokenTypeGreater, tokenTypeGreaterEqual, tokenTypeLess, tokenTypeLessEqual:
+		return i.compare(e.operator, left, right)
 	case tokenTypeBangEqual:
-		return left != right
+		return !i.isEqual(left, right)
 	case tokenTypeEqualEqual:
-		return left == right
+		return i.isEqual(left, right)
 	}
 	return nil
 }
 
 func (i *interpreter) visitCallExpr(e exprCall) interface{} {
+	i.step(e.paren)
 	callee := i.evaluate(e.callee)
 
 	var args []interface{}
@@ -104,7 +215,7 @@ func (i *interpreter) visitCallExpr(e exprCall) interface{} {
 			f.arity(), len(args)))
 	}
 
-	return f.call(i, args)
+	return f.call(i, e.paren, args)
 }
 
 func (i *interpreter) visitGroupingExpr(e exprGrouping) interface{} {
@@ -119,8 +230,7 @@ func (i *interpreter) visitUnaryExpr(e exprUnary) interface{} {
 	r := i.evaluate(e.right)
 	switch e.operator.tt {
 	case tokenTypeMinus:
-		i.checkNumberOperand(e.operator, r)
-		return -r.(float64)
+		return i.negate(e.operator, r)
 	case tokenTypeBang:
 		return !i.isTruthy(r)
 	}
@@ -128,11 +238,11 @@ func (i *interpreter) visitUnaryExpr(e exprUnary) interface{} {
 }
 
 func (i *interpreter) visitVariableExpr(e exprVariable) interface{} {
-	return i.lookUpVariable(e.name, e)
+	return i.lookUpVariable(e.name)
 }
 
-func (i *interpreter) lookUpVariable(name token, e expr) interface{} {
-	dist, ok := i.locals[e]
+func (i *interpreter) lookUpVariable(name token) interface{} {
+	dist, ok := i.locals[name]
 	if ok {
 		return i.env.getAt(dist, name)
 	} else {
@@ -142,7 +252,7 @@ func (i *interpreter) lookUpVariable(name token, e expr) interface{} {
 
 func (i *interpreter) visitAssignExpr(e exprAssign) interface{} {
 	v := i.evaluate(e.value)
-	dist, ok := i.locals[e]
+	dist, ok := i.locals[e.name]
 	if ok {
 		i.env.assignAt(dist, e.name, v)
 	} else {
@@ -167,7 +277,11 @@ func (i *interpreter) visitLogicalExpr(e exprLogical) interface{} {
 }
 
 func (i *interpreter) visitGetExpr(e exprGet) interface{} {
-	inst, ok := i.evaluate(e.obj).(loxInstance)
+	obj := i.evaluate(e.obj)
+	if it, ok := obj.(*loxIterator); ok {
+		return it.method(e.name)
+	}
+	inst, ok := obj.(loxInstance)
 	if !ok {
 		reportRuntimeError(e.name, "only instances have properties.")
 	}
@@ -184,12 +298,87 @@ func (i *interpreter) visitSetExpr(e exprSet) interface{} {
 	return v
 }
 
+func (i *interpreter) visitIndexExpr(e exprIndex) interface{} {
+	obj := i.evaluate(e.obj)
+	index := i.evaluate(e.index)
+	switch obj := obj.(type) {
+	case string:
+		n := i.checkIndex(e.bracket, index, utf8.RuneCountInString(obj))
+		for _, r := range obj {
+			if n == 0 {
+				return string(r)
+			}
+			n--
+		}
+	case *loxList:
+		return obj.elements[i.checkIndex(e.bracket, index, len(obj.elements))]
+	case *loxMap:
+		return obj.get(mapKey(e.bracket, index))
+	default:
+		reportRuntimeError(e.bracket, "Only strings, lists and maps can be indexed.")
+	}
+	return nil
+}
+
+func (i *interpreter) visitIndexSetExpr(e exprIndexSet) interface{} {
+	obj := i.evaluate(e.obj)
+	index := i.evaluate(e.index)
+	v := i.evaluate(e.value)
+	switch obj := obj.(type) {
+	case *loxList:
+		obj.elements[i.checkIndex(e.bracket, index, len(obj.elements))] = v
+	case *loxMap:
+		k := mapKey(e.bracket, index)
+		if _, ok := obj.entries[k]; !ok {
+			i.checkCollectionSize(e.bracket, len(obj.keys)+1)
+		}
+		obj.set(k, v)
+	default:
+		reportRuntimeError(e.bracket, "Only list elements and map entries can be assigned.")
+	}
+	return v
+}
+
+func (i *interpreter) visitListExpr(e exprList) interface{} {
+	i.allocate(e.bracket)
+	i.checkCollectionSize(e.bracket, len(e.elements))
+	l := &loxList{elements: make([]interface{}, 0, len(e.elements))}
+	for _, el := range e.elements {
+		l.elements = append(l.elements, i.evaluate(el))
+	}
+	return l
+}
+
+func (i *interpreter) visitMapExpr(e exprMap) interface{} {
+	i.allocate(e.brace)
+	i.checkCollectionSize(e.brace, len(e.keys))
+	m := newLoxMap()
+	for k := range e.keys {
+		key := mapKey(e.brace, i.evaluate(e.keys[k]))
+		m.set(key, i.evaluate(e.values[k]))
+	}
+	return m
+}
+
+// checkIndex ensures that index is an integer within [0, length) and
+// returns it as an int.
+func (i *interpreter) checkIndex(t token, index interface{}, length int) int {
+	n, ok := index.(int64)
+	if !ok {
+		reportRuntimeError(t, "Index must be an integer.")
+	}
+	if n < 0 || n >= int64(length) {
+		reportRuntimeError(t, fmt.Sprintf("Index %d out of range [0, %d).", n, length))
+	}
+	return int(n)
+}
+
 func (i *interpreter) visitThisExpr(e exprThis) interface{} {
-	return i.lookUpVariable(e.name, e)
+	return i.lookUpVariable(e.name)
 }
 
 func (i *interpreter) visitSuperExpr(e exprSuper) interface{} {
-	dist := i.locals[e]
+	dist := i.locals[e.keyword]
 	super := i.env.getAt(dist, token{lexeme: "super"}).(loxClass)
 	this := i.env.getAt(dist-1, token{lexeme: "this"}).(loxInstance)
 	m := super.findMethod(e.method.lexeme)
@@ -200,8 +389,43 @@ func (i *interpreter) visitSuperExpr(e exprSuper) interface{} {
 	return m.bind(this)
 }
 
-func (i *interpreter) resolveLocal(e expr, depth int) {
-	i.locals[e] = depth
+func (i *interpreter) resolveLocal(name token, depth int) {
+	i.locals[name] = depth
+}
+
+// isEqual compares two values. Numbers are equal if they have the same
+// value regardless of whether they are integers or floats.
+func (i *interpreter) isEqual(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		if l, r, ok := bothIntegers(a, b); ok {
+			return l == r
+		}
+		l, _ := toFloat(a)
+		r, _ := toFloat(b)
+		return l == r
+	}
+	// Instances, classes and functions hold maps or slices, which Go cannot
+	// compare, and are equal only to themselves.
+	switch l := a.(type) {
+	case loxInstance:
+		r, ok := b.(loxInstance)
+		return ok && sameMap(l.fields, r.fields)
+	case loxClass:
+		r, ok := b.(loxClass)
+		return ok && sameMap(l.methods, r.methods)
+	case loxFunction:
+		r, ok := b.(loxFunction)
+		return ok && l.closure == r.closure && l.declaration.name == r.declaration.name
+	case nativeFunction:
+		r, ok := b.(nativeFunction)
+		return ok && l.name == r.name
+	}
+	return a == b
+}
+
+// sameMap reports whether a and b are the same map.
+func sameMap(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
 }
 
 func (i *interpreter) isTruthy(v interface{}) bool {
@@ -218,10 +442,14 @@ func (i *interpreter) evaluate(e expr) interface{} {
 }
 
 func (i *interpreter) execute(s stmt) {
+	if len(i.hooks) > 0 {
+		i.hookStatement(s)
+	}
 	s.accept(i)
 }
 
 func (i *interpreter) executeBlock(s stmtBlock, env *environment) {
+	i.step(s.open)
 	prev := i.env
 	i.env = env
 	defer func() {
@@ -233,13 +461,43 @@ func (i *interpreter) executeBlock(s stmtBlock, env *environment) {
 	return
 }
 
+// executeIn executes s in env.
+func (i *interpreter) executeIn(s stmt, env *environment) {
+	prev := i.env
+	i.env = env
+	defer func() {
+		i.env = prev
+	}()
+	i.execute(s)
+}
+
 func (i *interpreter) visitWhileStatement(s stmtWhile) interface{} {
 	for i.isTruthy(i.evaluate(s.condition)) {
+		i.step(s.keyword)
 		i.execute(s.body)
 	}
 	return nil
 }
 
+func (i *interpreter) visitForStatement(s stmtFor) interface{} {
+	if s.initializer != nil {
+		prev := i.env
+		i.env = newEnvironmentWithParent(i.env)
+		defer func() {
+			i.env = prev
+		}()
+		i.execute(s.initializer)
+	}
+	for s.condition == nil || i.isTruthy(i.evaluate(s.condition)) {
+		i.step(s.keyword)
+		i.execute(s.body)
+		if s.increment != nil {
+			i.evaluate(s.increment)
+		}
+	}
+	return nil
+}
+
 func (i *interpreter) visitIfStatement(s stmtIf) interface{} {
 	if i.isTruthy(i.evaluate(s.condition)) {
 		i.execute(s.thenBranch)
@@ -255,18 +513,23 @@ func (i *interpreter) visitBlockStatement(s stmtBlock) interface{} {
 }
 
 func (i *interpreter) visitExpressionStatement(s stmtExpression) interface{} {
-	i.evaluate(s.e)
+	v := i.evaluate(s.e)
+	if s.echo && v != nil {
+		fmt.Fprintln(i.stdout, stringify(v))
+	}
 	return nil
 }
 
 func (i *interpreter) visitFunctionStatement(s stmtFunction) interface{} {
+	i.allocate(s.name)
+	i.env.checkRedeclaration(s.name)
 	i.env.define(s.name.lexeme, loxFunction{declaration: s, closure: i.env})
 	return nil
 }
 
 func (i *interpreter) visitPrintStatement(s stmtPrint) interface{} {
 	e := i.evaluate(s.e)
-	fmt.Printf("%v\n", e)
+	fmt.Fprintln(i.stdout, stringify(e))
 	return nil
 }
 
@@ -284,7 +547,12 @@ func (i *interpreter) visitVarStatement(s stmtVar) interface{} {
 		v = i.evaluate(s.initializer)
 	}
 
-	i.env.define(s.name.lexeme, v)
+	i.env.checkRedeclaration(s.name)
+	if s.constant() {
+		i.env.defineConstant(s.name, v)
+	} else {
+		i.env.define(s.name.lexeme, v)
+	}
 	return nil
 }
 
@@ -298,6 +566,7 @@ func (i *interpreter) visitClassStatement(s stmtClass) interface{} {
 		}
 	}
 
+	i.env.checkRedeclaration(s.name)
 	i.env.define(s.name.lexeme, nil)
 	if s.superClass != nil {
 		i.env = newEnvironmentWithParent(i.env)
@@ -322,16 +591,14 @@ func (i *interpreter) visitClassStatement(s stmtClass) interface{} {
 }
 
 func (i *interpreter) checkNumberOperand(operator token, operand interface{}) {
-	if _, ok := operand.(float64); ok {
+	if isNumber(operand) {
 		return
 	}
 	reportRuntimeError(operator, "Operand must be a number.")
 }
 
 func (i *interpreter) checkNumberOperands(operator token, left, right interface{}) {
-	_, lok := left.(float64)
-	_, rok := right.(float64)
-	if lok && rok {
+	if isNumber(left) && isNumber(right) {
 		return
 	}
 	reportRuntimeError(operator, "Operands must be numbers.")
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// option configures an interpreter created by newInterpreter.
+type option func(i *interpreter)
+
+// withOutput redirects the output of print statements to w.
+func withOutput(w io.Writer) option {
+	return func(i *interpreter) {
+		i.stdout = w
+	}
+}
+
+// withDiagnostics redirects error reports to w.
+func withDiagnostics(w io.Writer) option {
+	return func(i *interpreter) {
+		i.diagnostics = w
+	}
+}
+
+// withContext makes the interpreter abort execution once ctx is done.
+func withContext(ctx context.Context) option {
+	return func(i *interpreter) {
+		i.ctx = ctx
+	}
+}
+
+// withTimeout limits the wall-clock time a single call to interpret may take.
+func withTimeout(d time.Duration) option {
+	return func(i *interpreter) {
+		i.timeout = d
+	}
+}
+
+// withStepLimit limits the number of steps a single call to interpret may
+// take. A step is a loop iteration, a call or the execution of a block.
+func withStepLimit(n int64) option {
+	return func(i *interpreter) {
+		i.maxSteps = n
+	}
+}
+
+// withMaxStringLength limits the length in bytes of strings a script can
+// build by concatenation or conversion.
+func withMaxStringLength(n int) option {
+	return func(i *interpreter) {
+		i.maxStringLength = n
+	}
+}
+
+// withMaxCollectionSize limits the number of elements of a list or entries
+// of a map.
+func withMaxCollectionSize(n int) option {
+	return func(i *interpreter) {
+		i.maxCollectionSize = n
+	}
+}
+
+// withMaxAllocations limits the number of objects (instances, lists, maps
+// and closures) a single call to interpret may allocate.
+func withMaxAllocations(n int64) option {
+	return func(i *interpreter) {
+		i.maxAllocations = n
+	}
+}
+
+// limitError is raised (as a panic) when a script exceeds one of the
+// execution limits configured on the interpreter. Unlike a runtimeError it
+// is caused by the host's policy rather than by a bug in the script.
+type limitError struct {
+	token  token
+	reason string
+}
+
+func (e *limitError) Error() string {
+	if e.token.line == 0 {
+		return fmt.Sprintf("[Limit Error] %s", e.reason)
+	}
+	return fmt.Sprintf("[Limit Error at line %d:%d] %s", e.token.line, e.token.column, e.reason)
+}
+
+// step accounts for one unit of work and aborts execution if the step
+// budget is exhausted or the interpreter's context is done. t is used to
+// report where execution stopped and may be the zero token.
+func (i *interpreter) step(t token) {
+	i.steps++
+	if i.maxSteps > 0 && i.steps > i.maxSteps {
+		hadRuntimeError = true
+		panic(&limitError{token: t, reason: fmt.Sprintf("Step limit of %d exceeded.", i.maxSteps)})
+	}
+	select {
+	case <-i.done:
+		i.abort(t)
+	default:
+	}
+	if i.tasks > 0 && i.steps%yieldSteps == 0 {
+		i.yield()
+	}
+}
+
+// abort aborts execution once the interpreter's context is done.
+func (i *interpreter) abort(t token) {
+	hadRuntimeError = true
+	panic(&limitError{token: t, reason: fmt.Sprintf("Execution aborted: %v.", i.execCtx.Err())})
+}
+
+// allocate accounts for a newly allocated object.
+func (i *interpreter) allocate(t token) {
+	i.allocations++
+	if i.maxAllocations > 0 && i.allocations > i.maxAllocations {
+		hadRuntimeError = true
+		panic(&limitError{token: t, reason: fmt.Sprintf("Allocation limit of %d objects exceeded.", i.maxAllocations)})
+	}
+}
+
+// checkStringLength aborts execution if a string of n bytes is too long.
+func (i *interpreter) checkStringLength(t token, n int) {
+	if i.maxStringLength > 0 && n > i.maxStringLength {
+		hadRuntimeError = true
+		panic(&limitError{token: t, reason: fmt.Sprintf("String of %d bytes exceeds the limit of %d.", n, i.maxStringLength)})
+	}
+}
+
+// checkCollectionSize aborts execution if a list or map would grow to n
+// elements and that is too many.
+func (i *interpreter) checkCollectionSize(t token, n int) {
+	if i.maxCollectionSize > 0 && n > i.maxCollectionSize {
+		hadRuntimeError = true
+		panic(&limitError{token: t, reason: fmt.Sprintf("Collection of %d elements exceeds the limit of %d.", n, i.maxCollectionSize)})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStepLimit(t *testing.T) {
+	for _, c := range []struct {
+		name, source, want string
+	}{
+		{"block", "print 1;\n{\n  print 2;\n}\n{\n  print 3;\n}\n", "1\n2\n[Limit Error at line 5:1] Step limit of 1 exceeded.\n"},
+		{"loop", "print 1;\nwhile (true) {}\n", "1\n[Limit Error at line 2:14] Step limit of 1 exceeded.\n"},
+		{"call", "fun f() {}\nprint 1;\nf();\n", "1\n[Limit Error at line 1:9] Step limit of 1 exceeded.\n"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := glox(t, "-max-steps", "1", script(t, c.source))
+			if got := r.stdout + r.stderr; got != c.want || r.status != 1 {
+				t.Errorf("got %q (status %d), want %q", got, r.status, c.want)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	start := time.Now()
+	r := glox(t, "-timeout", "50ms", script(t, "print 1;\nwhile (true) {}\n"))
+	// The loop may be stopped at its keyword or at its body.
+	want := "] Execution aborted: context deadline exceeded.\n"
+	if r.stdout != "1\n" || !strings.HasPrefix(r.stderr, "[Limit Error at line 2:") || !strings.HasSuffix(r.stderr, want) || r.status != 1 {
+		t.Errorf("got %+v, want %q", r, want)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("took %v", d)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	var out, diag bytes.Buffer
+	it := newInterpreter(withContext(ctx), withOutput(&out), withDiagnostics(&diag))
+	err := run(it, "test.glox", "print 1;\nwhile (true) {}\n", false)
+	if err == nil || out.String() != "1\n" || !strings.Contains(diag.String(), "Execution aborted: context canceled.") {
+		t.Errorf("got %v with output %q and diagnostics %q", err, out.String(), diag.String())
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	source := script(t, "fun f(n) { return f(n + 1); }\nprint 1;\nf(0);\n")
+	r := glox(t, "-max-depth", "3", source)
+	want := `[Runtime Error at line 1:26] Stack overflow.
+  at f (script.glox:1)
+  at f (script.glox:1)
+  at f (script.glox:1)
+  at <script> (script.glox:3)
+`
+	if r.stdout != "1\n" || r.stderr != want || r.status != 1 {
+		t.Errorf("got %+v, want the error %q", r, want)
+	}
+	r = glox(t, "-max-depth", "100", source)
+	if want := "  ... 91 more frames ...\n"; !strings.Contains(r.stderr, want) || r.status != 1 {
+		t.Errorf("got %+v, want %q", r, want)
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	for _, c := range []struct {
+		name, flag, source, want string
+	}{
+		{
+			"allocations", "-max-allocs",
+			"class A {}\nfor (var i = 0; i < 10; i = i + 1) A();\n",
+			"[Limit Error at line 2:38] Allocation limit of 3 objects exceeded.\n",
+		},
+		{
+			"string", "-max-string",
+			"var s = \"ab\";\nwhile (true) s = s + s;\n",
+			"[Limit Error at line 2:20] String of 4 bytes exceeds the limit of 3.\n",
+		},
+		{
+			"list", "-max-collection",
+			"var l = [];\nwhile (true) push(l, 1);\n",
+			"[Limit Error at line 2:23] Collection of 4 elements exceeds the limit of 3.\n",
+		},
+		{
+			"map", "-max-collection",
+			"var m = {};\nvar i = 0;\nwhile (true) { m[i] = i; i = i + 1; }\n",
+			"[Limit Error at line 3:19] Collection of 4 elements exceeds the limit of 3.\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := glox(t, c.flag, "3", script(t, c.source))
+			if r.stdout != "" || r.stderr != c.want || r.status != 1 {
+				t.Errorf("got %+v, want the error %q", r, c.want)
+			}
+		})
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	r := glox(t, script(t, `fun inner(x) {
+  return x + nil;
+}
+fun outer() {
+  print 1;
+  return inner(1);
+}
+class C {
+  m() { outer(); }
+}
+C().m();
+`))
+	want := `[Runtime Error at line 2:12] Operands must be two numbers or two strings.
+  at inner (script.glox:2)
+  at outer (script.glox:6)
+  at m (script.glox:9)
+  at <script> (script.glox:11)
+`
+	if r.stdout != "1\n" || r.stderr != want || r.status != 1 {
+		t.Errorf("got %+v, want the error %q", r, want)
+	}
+}
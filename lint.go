@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The checks of glox lint. Their names are used in the output and in
+// suppression comments.
+const (
+	lintUnusedVariable  = "unused-variable"
+	lintUnusedParameter = "unused-parameter"
+	lintUnusedFunction  = "unused-function"
+	lintUnreachable     = "unreachable-code"
+	lintShadowing       = "shadowing"
+	lintUndeclared      = "undeclared-global"
+	lintNestedThis      = "nested-this"
+	lintArity           = "arity"
+)
+
+// lintIgnore starts a comment that suppresses warnings. A comment on its
+// own line applies to the next line, otherwise it applies to its own line.
+// It may be followed by the names of the checks to suppress; without names
+// it suppresses all of them.
+const lintIgnore = "lint:ignore"
+
+type lintWarning struct {
+	token   token
+	check   string
+	message string
+}
+
+// linter walks a resolved script keeping track of scopes the way the
+// resolver does, and relies on the references the resolver recorded in the
+// analysis to tell what each name refers to.
+type linter struct {
+	a        *analysis
+	warnings []lintWarning
+
+	// globals are the names declared at the top level.
+	globals map[string]token
+	// scopes are the local scopes enclosing the current node.
+	scopes []map[string]token
+	// functions are the names of the enclosing functions and methods,
+	// innermost last. method is the index of the innermost method among
+	// them, or -1 outside methods.
+	functions []token
+	method    int
+
+	// declared are the local variables and parameters and all functions,
+	// in declaration order, with the check that reports them unused.
+	declared []lintDecl
+	// reads counts the reads of each declaration, not counting those from
+	// within the body of the function it declares.
+	reads map[token]int
+	// assigned records the declarations that are assigned to.
+	assigned map[token]bool
+	// calls are checked once assignments are known.
+	calls []exprCall
+}
+
+type lintDecl struct {
+	name  token
+	check string
+}
+
+// lint returns the warnings for an analyzed script, in source order and
+// without the suppressed ones.
+func lint(a *analysis) []lintWarning {
+	l := &linter{a: a, globals: map[string]token{}, method: -1, reads: map[token]int{}, assigned: map[token]bool{}}
+	for _, s := range a.stmts {
+		if name := declaredName(s); name.lexeme != "" {
+			l.globals[name.lexeme] = name
+		}
+	}
+	l.stmts(a.stmts)
+
+	for _, d := range l.declared {
+		if l.reads[d.name] > 0 || strings.HasPrefix(d.name.lexeme, "_") || l.isTest(d) {
+			continue
+		}
+		switch d.check {
+		case lintUnusedFunction:
+			l.warn(d.name, d.check, "function '%s' is never called", d.name.lexeme)
+		case lintUnusedParameter:
+			l.warn(d.name, d.check, "parameter '%s' is never used", d.name.lexeme)
+		default:
+			l.warn(d.name, d.check, "variable '%s' is never used", d.name.lexeme)
+		}
+	}
+	for _, c := range l.calls {
+		l.checkArity(c)
+	}
+
+	ignored := lintSuppressions(a)
+	var ret []lintWarning
+	for _, w := range l.warnings {
+		checks, ok := ignored[w.token.line]
+		if ok && (len(checks) == 0 || checks[w.check]) {
+			continue
+		}
+		ret = append(ret, w)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return before(ret[i].token, ret[j].token.line, ret[j].token.column)
+	})
+	return ret
+}
+
+// lintSuppressions returns the checks suppressed by comments on each line.
+// An empty set suppresses all checks.
+func lintSuppressions(a *analysis) map[int]map[string]bool {
+	code := map[int]bool{}
+	for _, t := range a.tokens {
+		code[t.line] = true
+	}
+	ret := map[int]map[string]bool{}
+	for _, c := range a.comments {
+		text := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(c.lexeme, "//"), "/*"), "*/"))
+		if !strings.HasPrefix(text, lintIgnore) {
+			continue
+		}
+		line := c.line
+		if !code[line] {
+			line, _ = tokenEnd(c)
+			line++
+		}
+		checks := map[string]bool{}
+		for _, name := range strings.FieldsFunc(text[len(lintIgnore):], func(r rune) bool { return r == ',' || r == ' ' }) {
+			checks[name] = true
+		}
+		ret[line] = checks
+	}
+	return ret
+}
+
+func (l *linter) warn(t token, check, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, lintWarning{t, check, fmt.Sprintf(format, args...)})
+}
+
+// declare records a declaration and reports it if it shadows a variable
+// of an enclosing scope. Globals are only recorded if they are functions,
+// and classes are never recorded.
+func (l *linter) declare(name token, check string) {
+	if len(l.scopes) == 0 {
+		if check == lintUnusedFunction {
+			l.declared = append(l.declared, lintDecl{name, check})
+		}
+		return
+	}
+	if outer, ok := l.lookup(name.lexeme, len(l.scopes)-1); ok {
+		l.warn(name, lintShadowing, "declaration of '%s' shadows the one at line %d", name.lexeme, outer.line)
+	}
+	l.scopes[len(l.scopes)-1][name.lexeme] = name
+	if check != "" {
+		l.declared = append(l.declared, lintDecl{name, check})
+	}
+}
+
+// isTest reports whether d declares a test, which glox test calls.
+func (l *linter) isTest(d lintDecl) bool {
+	return d.check == lintUnusedFunction && l.globals[d.name.lexeme] == d.name && isTestFunction(d.name)
+}
+
+// lookup finds the declaration of name in the first n scopes, innermost
+// first, or among the globals.
+func (l *linter) lookup(name string, n int) (token, bool) {
+	for k := n - 1; k >= 0; k-- {
+		if t, ok := l.scopes[k][name]; ok {
+			return t, true
+		}
+	}
+	t, ok := l.globals[name]
+	return t, ok
+}
+
+func (l *linter) beginScope() {
+	l.scopes = append(l.scopes, map[string]token{})
+}
+
+func (l *linter) endScope() {
+	l.scopes = l.scopes[:len(l.scopes)-1]
+}
+
+func (l *linter) stmts(ss []stmt) {
+	for k, s := range ss {
+		if k > 0 {
+			if r, ok := ss[k-1].(stmtReturn); ok {
+				l.warn(stmtStart(s), lintUnreachable, "unreachable code after the return at line %d", r.keyword.line)
+				return
+			}
+		}
+		l.stmt(s)
+	}
+}
+
+func (l *linter) stmt(s stmt) {
+	switch s := s.(type) {
+	case stmtExpression:
+		l.expr(s.e)
+	case stmtPrint:
+		l.expr(s.e)
+	case stmtVar:
+		if s.initializer != nil {
+			l.expr(s.initializer)
+		}
+		l.declare(s.name, lintUnusedVariable)
+	case stmtBlock:
+		l.beginScope()
+		l.stmts(s.statements)
+		l.endScope()
+	case stmtIf:
+		l.expr(s.condition)
+		l.stmt(s.thenBranch)
+		if s.elseBranch != nil {
+			l.stmt(s.elseBranch)
+		}
+	case stmtWhile:
+		l.expr(s.condition)
+		l.stmt(s.body)
+	case stmtFor:
+		l.beginScope()
+		if s.initializer != nil {
+			l.stmt(s.initializer)
+		}
+		if s.condition != nil {
+			l.expr(s.condition)
+		}
+		if s.increment != nil {
+			l.expr(s.increment)
+		}
+		l.stmt(s.body)
+		l.endScope()
+	case stmtForIn:
+		l.expr(s.iterable)
+		l.beginScope()
+		l.declare(s.name, lintUnusedVariable)
+		l.stmt(s.body)
+		l.endScope()
+	case stmtReturn:
+		if s.value != nil {
+			l.expr(s.value)
+		}
+	case stmtYield:
+		if s.value != nil {
+			l.expr(s.value)
+		}
+	case stmtFunction:
+		l.declare(s.name, lintUnusedFunction)
+		l.function(s, false)
+	case stmtClass:
+		l.declare(s.name, "")
+		if s.superClass != nil {
+			l.expr(*s.superClass)
+		}
+		for _, m := range s.methods {
+			l.function(m, true)
+		}
+	}
+}
+
+func (l *linter) function(s stmtFunction, method bool) {
+	enclosing := l.method
+	if method {
+		l.method = len(l.functions)
+	}
+	l.functions = append(l.functions, s.name)
+	l.beginScope()
+	for _, p := range s.params {
+		l.declare(p, lintUnusedParameter)
+	}
+	l.stmts(s.body.statements)
+	l.endScope()
+	l.functions = l.functions[:len(l.functions)-1]
+	l.method = enclosing
+}
+
+// read counts a read of the variable name refers to, unless it happens in
+// the body of the function the variable names, as in a recursive call.
+func (l *linter) read(name token) {
+	d, ok := l.a.refs[name]
+	if !ok {
+		return
+	}
+	for _, f := range l.functions {
+		if f == d {
+			return
+		}
+	}
+	l.reads[d]++
+}
+
+func (l *linter) expr(e expr) {
+	switch e := e.(type) {
+	case exprBinary:
+		l.expr(e.left)
+		l.expr(e.right)
+	case exprLogical:
+		l.expr(e.left)
+		l.expr(e.right)
+	case exprGrouping:
+		l.expr(e.exp)
+	case exprUnary:
+		l.expr(e.right)
+	case exprVariable:
+		l.read(e.name)
+	case exprAssign:
+		l.expr(e.value)
+		if d, ok := l.a.refs[e.name]; ok {
+			l.assigned[d] = true
+		} else if _, ok := nativeNamed(e.name.file, e.name.lexeme); !ok {
+			l.warn(e.name, lintUndeclared, "assignment to undeclared variable '%s'", e.name.lexeme)
+		}
+	case exprCall:
+		l.expr(e.callee)
+		for _, a := range e.args {
+			l.expr(a)
+		}
+		l.calls = append(l.calls, e)
+	case exprSpawn:
+		l.expr(e.call)
+	case exprGet:
+		l.expr(e.obj)
+	case exprSet:
+		l.expr(e.obj)
+		l.expr(e.value)
+	case exprThis:
+		if l.method >= 0 && len(l.functions)-1 > l.method {
+			f := l.functions[len(l.functions)-1]
+			l.warn(e.name, lintNestedThis, "'this' is captured by the nested function '%s'", f.lexeme)
+		}
+	case exprSuper:
+	case exprIndex:
+		l.expr(e.obj)
+		l.expr(e.index)
+	case exprIndexSet:
+		l.expr(e.obj)
+		l.expr(e.index)
+		l.expr(e.value)
+	case exprList:
+		for _, el := range e.elements {
+			l.expr(el)
+		}
+	case exprMap:
+		for k := range e.keys {
+			l.expr(e.keys[k])
+			l.expr(e.values[k])
+		}
+	}
+}
+
+// checkArity reports a call to a function, class or native whose number of
+// parameters is known statically, because the variable that names it is
+// never assigned, and differs from the number of arguments.
+func (l *linter) checkArity(c exprCall) {
+	v, ok := c.callee.(exprVariable)
+	if !ok {
+		return
+	}
+	var what string
+	var arity int
+	if d, ok := l.a.refs[v.name]; ok {
+		s := l.a.decls[d]
+		if s == nil || l.assigned[d] || (s.kind != symbolFunction && s.kind != symbolClass) {
+			return
+		}
+		if arity, ok = l.a.arity(s, l.assigned); !ok {
+			return
+		}
+		what = s.signature()
+	} else if n, ok := nativeNamed(v.name.file, v.name.lexeme); ok {
+		what, arity = "native fun "+n.name, n.params
+	} else {
+		return
+	}
+	if len(c.args) != arity {
+		l.warn(c.paren, lintArity, "%s expects %s but is called with %d", what, countOf(arity, "argument"), len(c.args))
+	}
+}
+
+// lintJSON is the machine-readable form of a warning.
+type lintJSON struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	Check     string `json:"check"`
+	Message   string `json:"message"`
+}
+
+// lintCommand implements "glox lint". It exits with status 1 if it found
+// any warning or error.
+func lintCommand(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the warnings as a JSON array")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox lint [-json] path ...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	files, err := scriptFiles(fs.Args(), ".glox")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	out := []lintJSON{}
+	for _, name := range files {
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		a := analyze(filepath.Base(name), string(bs))
+		var ws []lintWarning
+		if a.err != nil {
+			ws = append(ws, lintWarning{a.err.token, "error", a.err.message})
+		}
+		if a.parsed {
+			ws = append(ws, lint(a)...)
+		}
+		for _, w := range ws {
+			endLine, endColumn := tokenEnd(w.token)
+			out = append(out, lintJSON{name, w.token.line, w.token.column, endLine, endColumn, w.check, w.message})
+		}
+	}
+
+	if *asJSON {
+		bs, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(bs))
+	} else {
+		for _, w := range out {
+			fmt.Printf("%s:%d:%d: %s (%s)\n", w.File, w.Line, w.Column, w.Message, w.Check)
+		}
+	}
+	if len(out) > 0 {
+		return 1
+	}
+	return 0
+}
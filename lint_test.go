@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestLintInheritedArity(t *testing.T) {
+	a := analyze("arity.glox", `class A { init(_x) {} }
+class B < A {}
+class C < B {}
+B(1);
+C();
+var D = A;
+class E < D {}
+E(1, 2);
+`)
+	var got []string
+	for _, w := range lint(a) {
+		if w.check == lintArity {
+			got = append(got, w.message)
+		}
+	}
+	want := "class C expects 1 argument but is called with 0"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("got %q, want [%q]", got, want)
+	}
+
+	described := false
+	for _, ref := range a.references(a.stmts[2].(stmtClass).name) {
+		if ref.line != 5 {
+			continue
+		}
+		described = true
+		if d, _ := a.describe(ref); d != "class C\nTakes 1 argument." {
+			t.Errorf("describe: got %q", d)
+		}
+	}
+	if !described {
+		t.Error("describe: no reference to C on line 5")
+	}
+}
@@ -0,0 +1,124 @@
+package loxrt
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// natives are the functions every script starts with.
+var natives = map[string]*Native{}
+
+func init() {
+	for _, n := range []*Native{
+		{Name: "len", Arity: 1, Fn: nativeLen},
+		{Name: "str", Arity: 1, Fn: nativeStr},
+		{Name: "push", Arity: 2, Fn: nativePush},
+		{Name: "keys", Arity: 1, Fn: nativeKeys},
+		{Name: "clock", Arity: 0, Fn: nativeClock},
+		{Name: "readLine", Arity: 0, Fn: nativeReadLine},
+		{Name: "readFile", Arity: 1, Fn: nativeReadFile},
+		{Name: "writeFile", Arity: 2, Fn: nativeWriteFile},
+		{Name: "getenv", Arity: 1, Fn: nativeGetenv},
+		{Name: "exit", Arity: 1, Fn: nativeExit},
+	} {
+		natives[n.Name] = n
+	}
+}
+
+func nativeLen(at Pos, args []Value) Value {
+	switch v := args[0].(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v))
+	case *List:
+		return int64(len(v.Elements))
+	case *Map:
+		return int64(len(v.keys))
+	}
+	Fail(at, "len() expects a string, list or map.")
+	return nil
+}
+
+func nativeStr(_ Pos, args []Value) Value {
+	return Stringify(args[0])
+}
+
+func nativePush(at Pos, args []Value) Value {
+	l, ok := args[0].(*List)
+	if !ok {
+		Fail(at, "push() expects a list.")
+	}
+	l.Elements = append(l.Elements, args[1])
+	return nil
+}
+
+func nativeKeys(at Pos, args []Value) Value {
+	m, ok := args[0].(*Map)
+	if !ok {
+		Fail(at, "keys() expects a map.")
+	}
+	return &List{Elements: append([]Value(nil), m.keys...)}
+}
+
+func nativeClock(Pos, []Value) Value {
+	return time.Now().Unix()
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func nativeReadLine(at Pos, _ []Value) Value {
+	out.Flush()
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil
+	} else if err != nil && err != io.EOF {
+		Fail(at, err.Error())
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+}
+
+func nativeReadFile(at Pos, args []Value) Value {
+	name, ok := args[0].(string)
+	if !ok {
+		Fail(at, "readFile() expects a file name.")
+	}
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		Fail(at, err.Error())
+	}
+	return string(bs)
+}
+
+func nativeWriteFile(at Pos, args []Value) Value {
+	name, ok := args[0].(string)
+	if !ok {
+		Fail(at, "writeFile() expects a file name.")
+	}
+	if err := ioutil.WriteFile(name, []byte(Stringify(args[1])), 0644); err != nil {
+		Fail(at, err.Error())
+	}
+	return nil
+}
+
+func nativeGetenv(at Pos, args []Value) Value {
+	name, ok := args[0].(string)
+	if !ok {
+		Fail(at, "getenv() expects a variable name.")
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return nil
+}
+
+func nativeExit(at Pos, args []Value) Value {
+	code, ok := args[0].(int64)
+	if !ok {
+		Fail(at, "exit() expects an integer status code.")
+	}
+	panic(&exitStatus{code: int(code)})
+}
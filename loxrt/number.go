@@ -0,0 +1,258 @@
+package loxrt
+
+import (
+	"fmt"
+	"math"
+)
+
+// Numbers are int64 or float64. Arithmetic on two integers stays integral
+// and fails on overflow; if either operand is a float64 the other one is
+// promoted and the result is a float64.
+
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func bothIntegers(left, right Value) (int64, int64, bool) {
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	return l, r, lok && rok
+}
+
+func checkNumberOperands(at Pos, left, right Value) {
+	if !isNumber(left) || !isNumber(right) {
+		Fail(at, "Operands must be numbers.")
+	}
+}
+
+func overflow(at Pos, op string, l, r int64) {
+	Fail(at, fmt.Sprintf("Integer overflow: %d %s %d.", l, op, r))
+}
+
+// Add adds two numbers or concatenates two strings.
+func Add(at Pos, left, right Value) Value {
+	if isNumber(left) && isNumber(right) {
+		if l, r, ok := bothIntegers(left, right); ok {
+			v := l + r
+			if (v > l) != (r > 0) {
+				overflow(at, "+", l, r)
+			}
+			return v
+		}
+		l, _ := toFloat(left)
+		r, _ := toFloat(right)
+		return l + r
+	}
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if !lok || !rok {
+		Fail(at, "Operands must be two numbers or two strings.")
+	}
+	return l + r
+}
+
+// Subtract subtracts two numbers.
+func Subtract(at Pos, left, right Value) Value {
+	checkNumberOperands(at, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		v := l - r
+		if (v < l) != (r > 0) {
+			overflow(at, "-", l, r)
+		}
+		return v
+	}
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	return l - r
+}
+
+// Multiply multiplies two numbers.
+func Multiply(at Pos, left, right Value) Value {
+	checkNumberOperands(at, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		if l == 0 || r == 0 {
+			return int64(0)
+		}
+		v := l * r
+		if v/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			overflow(at, "*", l, r)
+		}
+		return v
+	}
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	return l * r
+}
+
+// Divide divides two numbers. Integer division truncates.
+func Divide(at Pos, left, right Value) Value {
+	checkNumberOperands(at, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		if r == 0 {
+			Fail(at, "Division by zero")
+		}
+		if l == math.MinInt64 && r == -1 {
+			overflow(at, "/", l, r)
+		}
+		return l / r
+	}
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	if r == 0 {
+		Fail(at, "Division by zero")
+	}
+	return l / r
+}
+
+// Modulo returns the remainder of the division of two numbers.
+func Modulo(at Pos, left, right Value) Value {
+	checkNumberOperands(at, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		if r == 0 {
+			Fail(at, "Division by zero")
+		}
+		return l % r
+	}
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	if r == 0 {
+		Fail(at, "Division by zero")
+	}
+	return math.Mod(l, r)
+}
+
+// Negate negates a number.
+func Negate(at Pos, v Value) Value {
+	switch n := v.(type) {
+	case int64:
+		if n == math.MinInt64 {
+			Fail(at, fmt.Sprintf("Integer overflow: -(%d).", n))
+		}
+		return -n
+	case float64:
+		return -n
+	}
+	Fail(at, "Operand must be a number.")
+	return nil
+}
+
+func integers(at Pos, left, right Value) (int64, int64) {
+	l, r, ok := bothIntegers(left, right)
+	if !ok {
+		Fail(at, "Operands must be integers.")
+	}
+	return l, r
+}
+
+// BitAnd is the bitwise and of two integers.
+func BitAnd(at Pos, left, right Value) Value {
+	l, r := integers(at, left, right)
+	return l & r
+}
+
+// BitOr is the bitwise or of two integers.
+func BitOr(at Pos, left, right Value) Value {
+	l, r := integers(at, left, right)
+	return l | r
+}
+
+// BitXor is the bitwise exclusive or of two integers.
+func BitXor(at Pos, left, right Value) Value {
+	l, r := integers(at, left, right)
+	return l ^ r
+}
+
+// ShiftLeft shifts an integer left, failing if bits are lost.
+func ShiftLeft(at Pos, left, right Value) Value {
+	l, r := integers(at, left, right)
+	if r < 0 {
+		Fail(at, "Negative shift count.")
+	}
+	if r >= 64 {
+		if l != 0 {
+			overflow(at, "<<", l, r)
+		}
+		return int64(0)
+	}
+	v := l << uint(r)
+	if v>>uint(r) != l {
+		overflow(at, "<<", l, r)
+	}
+	return v
+}
+
+// ShiftRight shifts an integer right, keeping its sign.
+func ShiftRight(at Pos, left, right Value) Value {
+	l, r := integers(at, left, right)
+	if r < 0 {
+		Fail(at, "Negative shift count.")
+	}
+	if r >= 64 {
+		r = 63
+	}
+	return l >> uint(r)
+}
+
+// compare returns -1, 0 or 1 as left is smaller than, equal to or greater
+// than right, and false if either is NaN.
+func compare(at Pos, left, right Value) (int, bool) {
+	checkNumberOperands(at, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		}
+		return 0, true
+	}
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	switch {
+	case l < r:
+		return -1, true
+	case l > r:
+		return 1, true
+	case l != r:
+		return 0, false
+	}
+	return 0, true
+}
+
+// Less reports whether left < right.
+func Less(at Pos, left, right Value) Value {
+	c, ok := compare(at, left, right)
+	return ok && c < 0
+}
+
+// LessEqual reports whether left <= right.
+func LessEqual(at Pos, left, right Value) Value {
+	c, ok := compare(at, left, right)
+	return ok && c <= 0
+}
+
+// Greater reports whether left > right.
+func Greater(at Pos, left, right Value) Value {
+	c, ok := compare(at, left, right)
+	return ok && c > 0
+}
+
+// GreaterEqual reports whether left >= right.
+func GreaterEqual(at Pos, left, right Value) Value {
+	c, ok := compare(at, left, right)
+	return ok && c >= 0
+}
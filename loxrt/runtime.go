@@ -0,0 +1,374 @@
+package loxrt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pos is the position in the script of the token an operation reports its
+// errors at.
+type Pos struct {
+	Line, Column int
+}
+
+// Error is a runtime error. Operations raise it as a panic and Run prints
+// it.
+type Error struct {
+	Pos     Pos
+	Message string
+	// trace is the call stack at the point of the error, innermost first.
+	trace []traceLine
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[Runtime Error at line %d:%d] %s", e.Pos.Line, e.Pos.Column, e.Message) + formatTrace(e.trace)
+}
+
+// Fail raises a runtime error.
+func Fail(at Pos, message string) {
+	panic(&Error{Pos: at, Message: message})
+}
+
+// exitStatus is raised by the exit native.
+type exitStatus struct {
+	code int
+}
+
+// MaxCallDepth limits how deeply functions may recurse. Exceeding it raises
+// a "Stack overflow." error.
+var MaxCallDepth = 10000
+
+// file is the name of the script, which stack traces show.
+var file string
+
+var out = bufio.NewWriter(os.Stdout)
+
+// Run runs the body of a script, then prints the error that stopped it, if
+// any, and exits with the status glox would have.
+func Run(script string, body func()) {
+	file = script
+	code := 0
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				out.Flush()
+				switch r := r.(type) {
+				case *Error:
+					fmt.Fprintln(os.Stderr, r)
+					code = 1
+				case *exitStatus:
+					code = r.code
+				default:
+					panic(r)
+				}
+			}
+		}()
+		body()
+	}()
+	out.Flush()
+	os.Exit(code)
+}
+
+// Print prints a value on its own line.
+func Print(v Value) {
+	out.WriteString(Stringify(v))
+	out.WriteByte('\n')
+}
+
+// callFrame is an active call of a Lox function.
+type callFrame struct {
+	function string
+	// call is where the function was called from.
+	call Pos
+}
+
+type traceLine struct {
+	function string
+	line     int
+}
+
+var frames []callFrame
+
+// maxTraceLines bounds how many lines of a stack trace are printed; the
+// middle of longer traces is elided.
+const maxTraceLines = 10
+
+func stackTrace(at Pos) []traceLine {
+	ret := make([]traceLine, 0, len(frames)+1)
+	for k := len(frames) - 1; k >= 0; k-- {
+		ret = append(ret, traceLine{function: frames[k].function, line: at.Line})
+		at = frames[k].call
+	}
+	return append(ret, traceLine{function: "<script>", line: at.Line})
+}
+
+func formatTrace(trace []traceLine) string {
+	var b strings.Builder
+	for k, t := range trace {
+		if len(trace) > maxTraceLines && k == maxTraceLines/2 {
+			fmt.Fprintf(&b, "\n  ... %d more frames ...", len(trace)-maxTraceLines)
+		}
+		if len(trace) > maxTraceLines && k >= maxTraceLines/2 && k < len(trace)-maxTraceLines/2 {
+			continue
+		}
+		if file == "" {
+			fmt.Fprintf(&b, "\n  at %s (line %d)", t.function, t.line)
+		} else {
+			fmt.Fprintf(&b, "\n  at %s (%s:%d)", t.function, file, t.line)
+		}
+	}
+	return b.String()
+}
+
+// Call calls a function, a native or a class with arguments.
+func Call(at Pos, callee Value, args ...Value) Value {
+	switch f := callee.(type) {
+	case *Function:
+		checkArity(at, f.Arity, args)
+		return f.call(at, args)
+	case *Native:
+		checkArity(at, f.Arity, args)
+		return f.Fn(at, args)
+	case *Class:
+		checkArity(at, f.arity(), args)
+		inst := &Instance{Class: f, Fields: map[string]Value{}}
+		if init := f.FindMethod("init"); init != nil {
+			init.Bind(inst).call(at, args)
+		}
+		return inst
+	}
+	Fail(at, "Can only call functions and classes.")
+	return nil
+}
+
+func checkArity(at Pos, arity int, args []Value) {
+	if len(args) != arity {
+		Fail(at, fmt.Sprintf("Expected %d arguments but got %d.", arity, len(args)))
+	}
+}
+
+func (f *Function) call(at Pos, args []Value) Value {
+	if MaxCallDepth > 0 && len(frames) >= MaxCallDepth {
+		panic(&Error{Pos: at, Message: "Stack overflow.", trace: stackTrace(at)})
+	}
+	frames = append(frames, callFrame{function: f.Name, call: at})
+	defer func() {
+		if r := recover(); r != nil {
+			// The innermost frame the error goes through records the stack.
+			if err, ok := r.(*Error); ok && err.trace == nil {
+				err.trace = stackTrace(err.Pos)
+			}
+			frames = frames[:len(frames)-1]
+			panic(r)
+		}
+		frames = frames[:len(frames)-1]
+	}()
+	return f.Fn(args)
+}
+
+// Superclass checks that the superclass of a class is a class.
+func Superclass(at Pos, v Value) *Class {
+	c, ok := v.(*Class)
+	if !ok {
+		Fail(at, "Superclass must be a class.")
+	}
+	return c
+}
+
+// Super returns the method of the superclass of the class of a method,
+// bound to the instance the method was called on.
+func Super(at Pos, super *Class, this *Instance, name string) Value {
+	m := super.FindMethod(name)
+	if m == nil {
+		Fail(at, fmt.Sprintf("Undefined property '%s'.", name))
+	}
+	return m.Bind(this)
+}
+
+// Get returns a field of an instance or one of its methods, bound to it.
+func Get(at Pos, obj Value, name string) Value {
+	inst, ok := obj.(*Instance)
+	if !ok {
+		Fail(at, "only instances have properties.")
+	}
+	if v, ok := inst.Fields[name]; ok {
+		return v
+	}
+	if m := inst.Class.FindMethod(name); m != nil {
+		return m.Bind(inst)
+	}
+	Fail(at, fmt.Sprintf("Undefined property '%s'.", name))
+	return nil
+}
+
+// Fields checks that obj is an instance, before a field of it is set with
+// Set.
+func Fields(at Pos, obj Value) *Instance {
+	inst, ok := obj.(*Instance)
+	if !ok {
+		Fail(at, "Only instances have fields.")
+	}
+	return inst
+}
+
+// Set sets a field of an instance.
+func (i *Instance) Set(name string, v Value) Value {
+	i.Fields[name] = v
+	return v
+}
+
+// checkIndex ensures that index is an integer within [0, length).
+func checkIndex(at Pos, index Value, length int) int {
+	n, ok := index.(int64)
+	if !ok {
+		Fail(at, "Index must be an integer.")
+	}
+	if n < 0 || n >= int64(length) {
+		Fail(at, fmt.Sprintf("Index %d out of range [0, %d).", n, length))
+	}
+	return int(n)
+}
+
+// Index returns a character of a string, an element of a list or the
+// value of a map entry.
+func Index(at Pos, obj, index Value) Value {
+	switch obj := obj.(type) {
+	case string:
+		n := checkIndex(at, index, utf8.RuneCountInString(obj))
+		for _, r := range obj {
+			if n == 0 {
+				return string(r)
+			}
+			n--
+		}
+	case *List:
+		return obj.Elements[checkIndex(at, index, len(obj.Elements))]
+	case *Map:
+		return obj.entries[mapKey(at, index)]
+	default:
+		Fail(at, "Only strings, lists and maps can be indexed.")
+	}
+	return nil
+}
+
+// SetIndex sets an element of a list or the value of a map entry.
+func SetIndex(at Pos, obj, index, v Value) Value {
+	switch obj := obj.(type) {
+	case *List:
+		obj.Elements[checkIndex(at, index, len(obj.Elements))] = v
+	case *Map:
+		obj.set(mapKey(at, index), v)
+	default:
+		Fail(at, "Only list elements and map entries can be assigned.")
+	}
+	return v
+}
+
+// Iterate returns a function that returns the characters of a string, the
+// elements of a list or the keys of a map one by one, and false after the
+// last. The elements of a list are read as the loop goes, and the keys of a
+// map when it starts.
+func Iterate(at Pos, v Value) func() (Value, bool) {
+	switch v := v.(type) {
+	case string:
+		return func() (Value, bool) {
+			if v == "" {
+				return nil, false
+			}
+			r, n := utf8.DecodeRuneInString(v)
+			v = v[n:]
+			return string(r), true
+		}
+	case *List:
+		k := 0
+		return func() (Value, bool) {
+			if k >= len(v.Elements) {
+				return nil, false
+			}
+			k++
+			return v.Elements[k-1], true
+		}
+	case *Map:
+		keys := append([]Value(nil), v.keys...)
+		return func() (Value, bool) {
+			if len(keys) == 0 {
+				return nil, false
+			}
+			k := keys[0]
+			keys = keys[1:]
+			return k, true
+		}
+	}
+	Fail(at, "Can only iterate over lists, strings, maps and iterators.")
+	return nil
+}
+
+// Global is a variable of the global scope, which may be used before it is
+// defined and defined again.
+type Global struct {
+	name    string
+	value   Value
+	defined bool
+	// constant is where the global was declared if it is a constant.
+	constant *Pos
+}
+
+// NewGlobal returns an undefined global. A global named after a native
+// starts out as that native.
+func NewGlobal(name string) *Global {
+	g := &Global{name: name}
+	if n, ok := natives[name]; ok {
+		g.value, g.defined = n, true
+	}
+	return g
+}
+
+// Get returns the value of a global.
+func (g *Global) Get(at Pos) Value {
+	if !g.defined {
+		Fail(at, fmt.Sprintf("Undefined variable: '%s'", g.name))
+	}
+	return g.value
+}
+
+// Assign assigns to a global and returns the value.
+func (g *Global) Assign(at Pos, v Value) Value {
+	if !g.defined {
+		Fail(at, fmt.Sprintf("Undefined variable: '%s'", g.name))
+	}
+	if g.constant != nil {
+		Fail(at, fmt.Sprintf("Cannot assign to constant '%s' declared at line %d:%d.", g.name, g.constant.Line, g.constant.Column))
+	}
+	g.value = v
+	return v
+}
+
+// Define declares a global, which at declares, rejecting the
+// redeclaration of a constant.
+func (g *Global) Define(at Pos, v Value) {
+	if g.constant != nil {
+		Fail(at, fmt.Sprintf("Cannot redeclare constant '%s' declared at line %d:%d.", g.name, g.constant.Line, g.constant.Column))
+	}
+	g.value, g.defined = v, true
+}
+
+// DefineConstant declares a global constant.
+func (g *Global) DefineConstant(at Pos, v Value) {
+	g.Define(at, v)
+	g.constant = &at
+}
+
+// Assign assigns to a local variable and returns the value.
+func Assign(variable *Value, v Value) Value {
+	*variable = v
+	return v
+}
+
+// Read returns v. Generated code reads variables through it where Go could
+// otherwise read them out of order with the calls around them.
+func Read(v Value) Value {
+	return v
+}
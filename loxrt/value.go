@@ -0,0 +1,256 @@
+// Package loxrt is the runtime of the Go programs glox build generates from
+// Lox scripts. It implements the values of Lox and the operations on them
+// with the semantics and the error messages of the glox interpreter.
+package loxrt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a Lox value: nil, a bool, an int64, a float64, a string or one
+// of the pointer types of this package.
+type Value = interface{}
+
+// Function is a Lox function or a method bound to an instance.
+type Function struct {
+	Name  string
+	Arity int
+	Fn    func(args []Value) Value
+}
+
+// Native is a function implemented in Go.
+type Native struct {
+	Name  string
+	Arity int
+	Fn    func(at Pos, args []Value) Value
+}
+
+// Class is a Lox class.
+type Class struct {
+	Name    string
+	Super   *Class
+	Methods map[string]*Method
+}
+
+// Method is a method of a class, which Bind turns into a function whose
+// this is the given instance.
+type Method struct {
+	Arity int
+	Bind  func(this *Instance) *Function
+}
+
+// Instance is an instance of a class.
+type Instance struct {
+	Class  *Class
+	Fields map[string]Value
+}
+
+// List is a mutable, growable sequence of values.
+type List struct {
+	Elements []Value
+}
+
+// Map is a mutable map from strings, numbers or booleans to values, which
+// keeps the order its keys were added in.
+type Map struct {
+	keys    []Value
+	entries map[Value]Value
+	// key is the key Key validated, for the following call to Value.
+	key Value
+}
+
+// NewClass returns a class without methods. Super is nil for a class
+// without a superclass.
+func NewClass(name string, super *Class) *Class {
+	return &Class{Name: name, Super: super, Methods: map[string]*Method{}}
+}
+
+// FindMethod looks a method up in the class and its superclasses.
+func (c *Class) FindMethod(name string) *Method {
+	for ; c != nil; c = c.Super {
+		if m, ok := c.Methods[name]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+func (c *Class) arity() int {
+	if init := c.FindMethod("init"); init != nil {
+		return init.Arity
+	}
+	return 0
+}
+
+// NewList returns a list of the given elements.
+func NewList(elements ...Value) *List {
+	return &List{Elements: elements}
+}
+
+// NewMap returns an empty map. Its entries are added with Key and Value.
+func NewMap() *Map {
+	return &Map{entries: map[Value]Value{}}
+}
+
+// Key validates the key of the next entry of a map literal.
+func (m *Map) Key(at Pos, k Value) *Map {
+	m.key = mapKey(at, k)
+	return m
+}
+
+// Value adds the entry for the key passed to Key.
+func (m *Map) Value(v Value) *Map {
+	m.set(m.key, v)
+	m.key = nil
+	return m
+}
+
+func (m *Map) set(k, v Value) {
+	if _, ok := m.entries[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.entries[k] = v
+}
+
+// mapKey validates k and normalizes it so that keys which compare equal in
+// Lox (such as 1 and 1.0) find the same entry.
+func mapKey(at Pos, k Value) Value {
+	switch v := k.(type) {
+	case string, bool, int64:
+		return v
+	case float64:
+		if n := int64(v); float64(n) == v {
+			return n
+		}
+		return v
+	}
+	Fail(at, fmt.Sprintf("Map keys must be strings, numbers or booleans, got %s.", Stringify(k)))
+	return nil
+}
+
+// Truthy reports whether v counts as true: everything but nil and false
+// does.
+func Truthy(v Value) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return v != nil
+}
+
+// Not negates the truthiness of v.
+func Not(v Value) Value {
+	return !Truthy(v)
+}
+
+// Equal compares two values. Numbers are equal if they have the same value
+// whether they are integers or floats; objects are only equal to
+// themselves.
+func Equal(a, b Value) Value {
+	return equal(a, b)
+}
+
+// NotEqual is the negation of Equal.
+func NotEqual(a, b Value) Value {
+	return !equal(a, b)
+}
+
+func equal(a, b Value) bool {
+	if isNumber(a) && isNumber(b) {
+		if l, r, ok := bothIntegers(a, b); ok {
+			return l == r
+		}
+		l, _ := toFloat(a)
+		r, _ := toFloat(b)
+		return l == r
+	}
+	return a == b
+}
+
+// Stringify returns the textual representation of a value, as print shows
+// it.
+func Stringify(v Value) string {
+	var b strings.Builder
+	writeValue(&b, v, false, map[Value]bool{})
+	return b.String()
+}
+
+func writeValue(b *strings.Builder, v Value, quote bool, seen map[Value]bool) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("nil")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(formatFloat(v))
+	case string:
+		if quote {
+			b.WriteString(strconv.Quote(v))
+		} else {
+			b.WriteString(v)
+		}
+	case *List:
+		if seen[v] {
+			b.WriteString("[...]")
+			return
+		}
+		seen[v] = true
+		b.WriteByte('[')
+		for i, e := range v.Elements {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeValue(b, e, true, seen)
+		}
+		b.WriteByte(']')
+		delete(seen, v)
+	case *Map:
+		if seen[v] {
+			b.WriteString("{...}")
+			return
+		}
+		seen[v] = true
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeValue(b, k, true, seen)
+			b.WriteString(": ")
+			writeValue(b, v.entries[k], true, seen)
+		}
+		b.WriteByte('}')
+		delete(seen, v)
+	case *Function:
+		b.WriteString("<fn " + v.Name + ">")
+	case *Native:
+		b.WriteString("<native fn " + v.Name + ">")
+	case *Class:
+		b.WriteString("<class " + v.Name + ">")
+	case *Instance:
+		b.WriteString(v.Class.Name + " instance")
+	default:
+		b.WriteString("<unknown>")
+	}
+}
+
+// formatFloat prints whole floats without a fractional part and avoids
+// exponents for numbers of a reasonable magnitude.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
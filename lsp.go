@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The language server speaks the Language Server Protocol over stdin and
+// stdout. Documents are synchronized in full on every change and analyzed
+// with the scanner, parser and resolver; nothing is ever run.
+
+type rpcRequest struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+// rpcErrorResponse is a response to a failed request, which must not have
+// a result.
+type rpcErrorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *rpcError        `json:"error"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidParams  = -32602
+	rpcMethodNotFound = -32601
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspHover struct {
+	Contents lspMarkup `json:"contents"`
+	Range    lspRange  `json:"range"`
+}
+
+type lspMarkup struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+// Kinds of symbols and completion items defined by the protocol.
+const (
+	lspSymbolClass    = 5
+	lspSymbolMethod   = 6
+	lspSymbolFunction = 12
+	lspSymbolVariable = 13
+	lspSymbolConstant = 14
+
+	lspCompletionMethod   = 2
+	lspCompletionFunction = 3
+	lspCompletionVariable = 6
+	lspCompletionClass    = 7
+	lspCompletionKeyword  = 14
+	lspCompletionConstant = 21
+)
+
+// lspDocument is an open document. good is the latest analysis of it that
+// parsed, which answers queries while the user is in the middle of an edit
+// that does not parse.
+type lspDocument struct {
+	uri      string
+	lines    []string
+	analysis *analysis
+	good     *analysis
+}
+
+type lspServer struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*lspDocument
+	shutdown  bool
+}
+
+// lspCommand implements "glox lsp".
+func lspCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: glox lsp")
+		return 2
+	}
+	s := &lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, documents: map[string]*lspDocument{}}
+	return s.serve()
+}
+
+// serve handles messages until the client sends exit or closes the input.
+// It returns the exit status the protocol asks for.
+func (s *lspServer) serve() int {
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return 1
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.write(rpcErrorResponse{JSONRPC: "2.0", Error: &rpcError{rpcParseError, err.Error()}})
+			continue
+		}
+		if req.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, rerr := s.handle(req)
+		switch {
+		case req.ID == nil:
+		case rerr != nil:
+			s.write(rpcErrorResponse{JSONRPC: "2.0", ID: req.ID, Error: rerr})
+		default:
+			s.write(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+	}
+}
+
+func (s *lspServer) read() ([]byte, error) {
+	return readMessage(s.in)
+}
+
+// readMessage reads the content of the next message framed with a
+// Content-Length header, as both the language server and debug adapter
+// protocols do.
+func readMessage(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length header")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(in, body)
+	return body, err
+}
+
+func (s *lspServer) write(msg interface{}) {
+	writeMessage(s.out, msg)
+}
+
+// writeMessage writes msg as JSON framed with a Content-Length header.
+func writeMessage(out io.Writer, msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) handle(req rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // full
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider":     map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "glox"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+	case "textDocument/didClose":
+		var p lspTextDocumentPosition
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		delete(s.documents, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri": p.TextDocument.URI, "diagnostics": []lspDiagnostic{},
+		})
+	case "textDocument/definition", "textDocument/references", "textDocument/hover",
+		"textDocument/completion", "textDocument/documentSymbol":
+		var p lspTextDocumentPosition
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		d, ok := s.documents[p.TextDocument.URI]
+		if !ok {
+			return nil, &rpcError{rpcInvalidParams, "unknown document " + p.TextDocument.URI}
+		}
+		line, column := d.fromLSP(p.Position)
+		switch req.Method {
+		case "textDocument/definition":
+			return d.definition(line, column), nil
+		case "textDocument/references":
+			return d.references(line, column, p.Context.IncludeDeclaration), nil
+		case "textDocument/hover":
+			return d.hover(line, column), nil
+		case "textDocument/completion":
+			return d.completion(line, column), nil
+		default:
+			return d.documentSymbols(), nil
+		}
+	default:
+		if req.ID != nil {
+			return nil, &rpcError{rpcMethodNotFound, "method not supported: " + req.Method}
+		}
+	}
+	return nil, nil
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// update analyzes the new text of a document and publishes its
+// diagnostics.
+func (s *lspServer) update(uri, text string) {
+	d, ok := s.documents[uri]
+	if !ok {
+		d = &lspDocument{uri: uri}
+		s.documents[uri] = d
+	}
+	d.lines = strings.Split(text, "\n")
+	d.analysis = analyze(uriFile(uri), text)
+	if d.analysis.parsed {
+		d.good = d.analysis
+	}
+
+	diags := []lspDiagnostic{}
+	if err := d.analysis.err; err != nil {
+		t := err.token
+		if t.line == 0 {
+			t.line, t.column = 1, 1
+		}
+		diags = append(diags, lspDiagnostic{Range: d.tokenRange(t), Severity: 1, Source: "glox", Message: err.message})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diags})
+}
+
+// uriFile returns the base name of the file a document URI refers to.
+func uriFile(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return uri
+}
+
+// fromLSP converts a protocol position, which is 0-based and counts UTF-16
+// code units, into the 1-based line and rune column used by tokens.
+func (d *lspDocument) fromLSP(p lspPosition) (line, column int) {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return p.Line + 1, p.Character + 1
+	}
+	units, runes := 0, 0
+	for _, r := range d.lines[p.Line] {
+		if units >= p.Character {
+			break
+		}
+		units += utf16Len(r)
+		runes++
+	}
+	return p.Line + 1, runes + 1
+}
+
+// toLSP is the inverse of fromLSP.
+func (d *lspDocument) toLSP(line, column int) lspPosition {
+	p := lspPosition{Line: line - 1, Character: column - 1}
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return p
+	}
+	units, runes := 0, 0
+	for _, r := range d.lines[p.Line] {
+		if runes >= column-1 {
+			break
+		}
+		units += utf16Len(r)
+		runes++
+	}
+	p.Character = units + (column - 1 - runes)
+	return p
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
+
+func (d *lspDocument) tokenRange(t token) lspRange {
+	endLine, endColumn := tokenEnd(t)
+	return lspRange{d.toLSP(t.line, t.column), d.toLSP(endLine, endColumn)}
+}
+
+func (d *lspDocument) spanRange(start, end token) lspRange {
+	endLine, endColumn := tokenEnd(end)
+	return lspRange{d.toLSP(start.line, start.column), d.toLSP(endLine, endColumn)}
+}
+
+func (d *lspDocument) definition(line, column int) interface{} {
+	if d.good == nil {
+		return nil
+	}
+	t, ok := d.good.tokenAt(line, column)
+	if !ok {
+		return nil
+	}
+	decl, ok := d.good.declaration(t)
+	if !ok {
+		return nil
+	}
+	return lspLocation{d.uri, d.tokenRange(decl)}
+}
+
+func (d *lspDocument) references(line, column int, includeDeclaration bool) []lspLocation {
+	ret := []lspLocation{}
+	if d.good == nil {
+		return ret
+	}
+	t, ok := d.good.tokenAt(line, column)
+	if !ok {
+		return ret
+	}
+	decl, ok := d.good.declaration(t)
+	if !ok {
+		return ret
+	}
+	for _, ref := range d.good.references(decl) {
+		if ref == decl && !includeDeclaration {
+			continue
+		}
+		ret = append(ret, lspLocation{d.uri, d.tokenRange(ref)})
+	}
+	return ret
+}
+
+func (d *lspDocument) hover(line, column int) interface{} {
+	if d.good == nil {
+		return nil
+	}
+	t, ok := d.good.tokenAt(line, column)
+	if !ok {
+		return nil
+	}
+	text, ok := d.good.describe(t)
+	if !ok {
+		return nil
+	}
+	lines := strings.SplitN(text, "\n", 2)
+	value := "```lox\n" + lines[0] + "\n```"
+	if len(lines) > 1 {
+		value += "\n\n" + lines[1]
+	}
+	return lspHover{lspMarkup{"markdown", value}, d.tokenRange(t)}
+}
+
+func (d *lspDocument) completion(line, column int) []lspCompletionItem {
+	ret := []lspCompletionItem{}
+	if d.good == nil {
+		return ret
+	}
+	for name, s := range d.good.visibleNames(line, column) {
+		item := lspCompletionItem{Label: name, Kind: lspCompletionVariable}
+		switch {
+		case name == "this" || name == "super":
+			item.Kind = lspCompletionKeyword
+		case s == nil:
+			item.Kind = lspCompletionFunction
+			item.Detail = "native fun " + name
+		default:
+			switch s.kind {
+			case symbolFunction:
+				item.Kind = lspCompletionFunction
+			case symbolClass:
+				item.Kind = lspCompletionClass
+			case symbolMethod:
+				item.Kind = lspCompletionMethod
+			case symbolConstant:
+				item.Kind = lspCompletionConstant
+			}
+			item.Detail = s.signature()
+		}
+		ret = append(ret, item)
+	}
+	for kw := range literalToKeywordTokenType {
+		if kw != "this" && kw != "super" {
+			ret = append(ret, lspCompletionItem{Label: kw, Kind: lspCompletionKeyword})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Label < ret[j].Label })
+	return ret
+}
+
+func (d *lspDocument) documentSymbols() []lspDocumentSymbol {
+	if d.good == nil {
+		return []lspDocumentSymbol{}
+	}
+	return d.symbols(d.good.symbols)
+}
+
+func (d *lspDocument) symbols(ss []*symbol) []lspDocumentSymbol {
+	ret := []lspDocumentSymbol{}
+	for _, s := range ss {
+		ds := lspDocumentSymbol{
+			Name:           s.name.lexeme,
+			Detail:         s.signature(),
+			Kind:           lspSymbolVariable,
+			Range:          d.spanRange(s.start, s.end),
+			SelectionRange: d.tokenRange(s.name),
+		}
+		switch s.kind {
+		case symbolFunction:
+			ds.Kind = lspSymbolFunction
+		case symbolClass:
+			ds.Kind = lspSymbolClass
+		case symbolMethod:
+			ds.Kind = lspSymbolMethod
+		case symbolConstant:
+			ds.Kind = lspSymbolConstant
+		}
+		if len(s.children) > 0 {
+			ds.Children = d.symbols(s.children)
+		}
+		ret = append(ret, ds)
+	}
+	return ret
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// lspClient drives a language server over in-memory pipes. The server
+// may write a notification while the client writes a request, so messages
+// are read as they come and queued in out.
+type lspClient struct {
+	t      *testing.T
+	in     io.WriteCloser
+	out    chan []byte
+	id     int
+	status chan int
+	// notifications are the notifications received so far.
+	notifications []rpcNotification
+}
+
+func newLSPClient(t *testing.T) *lspClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	s := &lspServer{in: bufio.NewReader(inR), out: outW, documents: map[string]*lspDocument{}}
+	c := &lspClient{t: t, in: inW, out: make(chan []byte, 16), status: make(chan int, 1)}
+	go func() {
+		c.status <- s.serve()
+		outW.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(c.out)
+				return
+			}
+			c.out <- body
+		}
+	}()
+	return c
+}
+
+func (c *lspClient) send(method string, id interface{}, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != nil {
+		msg["id"] = id
+	}
+	writeMessage(c.in, msg)
+}
+
+// call sends a request and decodes the result of its response into result,
+// recording the notifications that arrive before it.
+func (c *lspClient) call(method string, params interface{}, result interface{}) {
+	c.t.Helper()
+	c.id++
+	c.send(method, c.id, params)
+	for {
+		body, ok := <-c.out
+		if !ok {
+			c.t.Fatalf("%s: the server closed its output", method)
+		}
+		var msg struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *rpcError       `json:"error"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.t.Fatalf("%s: %v in %s", method, err, body)
+		}
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, rpcNotification{Method: msg.Method, Params: msg.Params})
+			continue
+		}
+		if *msg.ID != c.id {
+			c.t.Fatalf("%s: got the response to request %d, want %d", method, *msg.ID, c.id)
+		}
+		if msg.Error != nil {
+			c.t.Fatalf("%s: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("%s: %v in %s", method, err, msg.Result)
+			}
+		}
+		return
+	}
+}
+
+const lspTestURI = "file:///tmp/test.glox"
+
+const lspTestSource = `fun add(a, b) {
+  return a + b;
+}
+var total = add(1, 2);
+print add(total, 3);
+`
+
+func position(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": lspTestURI},
+		"position":     lspPosition{line, character},
+		"context":      map[string]bool{"includeDeclaration": true},
+	}
+}
+
+func TestLSPSession(t *testing.T) {
+	c := newLSPClient(t)
+
+	var init struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.call("initialize", map[string]interface{}{}, &init)
+	for _, p := range []string{"definitionProvider", "referencesProvider", "hoverProvider", "completionProvider"} {
+		if init.Capabilities[p] == nil {
+			t.Errorf("initialize: missing capability %s", p)
+		}
+	}
+
+	c.send("textDocument/didOpen", nil, map[string]interface{}{
+		"textDocument": map[string]string{"uri": lspTestURI, "text": lspTestSource},
+	})
+
+	// add in "total = add(1, 2)" is defined on the first line.
+	var def lspLocation
+	c.call("textDocument/definition", position(3, 13), &def)
+	if want := (lspRange{lspPosition{0, 4}, lspPosition{0, 7}}); def.URI != lspTestURI || def.Range != want {
+		t.Errorf("definition: got %+v, want %v at %+v", def, lspTestURI, want)
+	}
+	if len(c.notifications) != 1 || c.notifications[0].Method != "textDocument/publishDiagnostics" {
+		t.Errorf("didOpen: got notifications %+v, want publishDiagnostics", c.notifications)
+	} else if diags := string(c.notifications[0].Params.(json.RawMessage)); !strings.Contains(diags, `"diagnostics":[]`) {
+		t.Errorf("didOpen: got diagnostics %s, want none", diags)
+	}
+
+	var refs []lspLocation
+	c.call("textDocument/references", position(0, 5), &refs)
+	var lines []int
+	for _, r := range refs {
+		lines = append(lines, r.Range.Start.Line)
+	}
+	if len(lines) != 3 || lines[0] != 0 || lines[1] != 3 || lines[2] != 4 {
+		t.Errorf("references: got lines %v, want [0 3 4]", lines)
+	}
+
+	var hover lspHover
+	c.call("textDocument/hover", position(4, 7), &hover)
+	if !strings.Contains(hover.Contents.Value, "fun add(a, b)") || !strings.Contains(hover.Contents.Value, "Takes 2 arguments.") {
+		t.Errorf("hover: got %q", hover.Contents.Value)
+	}
+
+	var items []lspCompletionItem
+	c.call("textDocument/completion", position(1, 9), &items)
+	found := map[string]int{}
+	for _, item := range items {
+		found[item.Label] = item.Kind
+	}
+	for name, kind := range map[string]int{
+		"a": lspCompletionVariable, "b": lspCompletionVariable, "add": lspCompletionFunction,
+		"total": lspCompletionVariable, "clock": lspCompletionFunction, "while": lspCompletionKeyword,
+	} {
+		if got, ok := found[name]; !ok || got != kind {
+			t.Errorf("completion: got %s of kind %d (present: %v), want kind %d", name, got, ok, kind)
+		}
+	}
+
+	// The range of a function starts at its fun keyword.
+	var symbols []lspDocumentSymbol
+	c.call("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]string{"uri": lspTestURI},
+	}, &symbols)
+	if len(symbols) == 0 || symbols[0].Name != "add" {
+		t.Errorf("documentSymbol: got %+v, want add first", symbols)
+	} else if want := (lspRange{lspPosition{0, 0}, lspPosition{2, 1}}); symbols[0].Range != want {
+		t.Errorf("documentSymbol: got the range %+v for add, want %+v", symbols[0].Range, want)
+	}
+
+	// An edit that does not parse is reported, while queries keep using the
+	// last version that did.
+	c.send("textDocument/didChange", nil, map[string]interface{}{
+		"textDocument":   map[string]string{"uri": lspTestURI},
+		"contentChanges": []map[string]string{{"text": lspTestSource + "print (;\n"}},
+	})
+	c.call("textDocument/definition", position(3, 13), &def)
+	if def.Range.Start.Line != 0 {
+		t.Errorf("definition after a broken edit: got %+v", def)
+	}
+	last := c.notifications[len(c.notifications)-1]
+	var diags struct {
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(last.Params.(json.RawMessage), &diags); err != nil || len(diags.Diagnostics) != 1 {
+		t.Errorf("didChange: got diagnostics %s, want one", last.Params)
+	} else if d := diags.Diagnostics[0]; d.Range.Start.Line != 5 {
+		t.Errorf("didChange: got a diagnostic at %+v, want line 5", d.Range)
+	}
+
+	c.call("shutdown", nil, nil)
+	c.send("exit", nil, nil)
+	if status := <-c.status; status != 0 {
+		t.Errorf("exit: got status %d, want 0", status)
+	}
+}
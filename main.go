@@ -7,13 +7,54 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+var (
+	maxSteps = flag.Int64("max-steps", 0, "abort scripts after this many steps (0 means no limit)")
+	timeout  = flag.Duration("timeout", 0, "abort scripts running longer than this (0 means no limit)")
+	maxDepth = flag.Int("max-depth", defaultMaxCallDepth, "maximum depth of nested function calls")
+
+	maxString = flag.Int("max-string", 0, "maximum length of a string in bytes (0 means no limit)")
+	maxElems  = flag.Int("max-collection", 0, "maximum number of elements in a list or map (0 means no limit)")
+	maxAllocs = flag.Int64("max-allocs", 0, "maximum number of allocated objects (0 means no limit)")
+
+	caps = flag.String("caps", "all", "comma separated capabilities granted to scripts: "+
+		strings.Join(allCapabilities(), ", ")+", all or none")
+
+	optimizeFlag = flag.Bool("optimize", false, "fold constants and remove dead branches before running scripts")
+	trace        = flag.Bool("trace", false, "log every statement executed and every call to stderr")
+	cover        = flag.String("cover", "", "record coverage, writing an HTML report and lcov.info to this directory")
+	profile      = flag.String("profile", "", "profile the script, writing a report to stderr and a pprof profile to this file")
+)
+
+// commands are the subcommands of glox. Each takes the arguments that
+// follow its name and returns the exit status.
+var commands = map[string]func(args []string) int{
+	"ast":     astCommand,
+	"build":   buildCommand,
+	"check":   checkCommand,
+	"compile": compileCommand,
+	"debug":   debugCommand,
+	"fmt":     fmtCommand,
+	"lint":    lintCommand,
+	"lsp":     lspCommand,
+	"test":    testCommand,
+	"tokens":  tokensCommand,
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	args := flag.Args()
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			os.Exit(cmd(args[1:]))
+		}
+	}
 	if len(args) > 1 {
-		fmt.Println("usage: glox [script]")
+		usage()
 		os.Exit(1)
 	} else if len(args) == 1 {
 		runFile(args[0])
@@ -22,52 +63,150 @@ func main() {
 	}
 }
 
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: glox [flags] [script]
+       glox ast [-json] file.glox
+       glox build [-o dir] file.glox
+       glox check path ...
+       glox compile [-o file.gloxc] file.glox
+       glox debug [-dap] [file.glox]
+       glox fmt [-w] [-l] [-d] [path ...]
+       glox lint [-json] path ...
+       glox lsp
+       glox test [-v] [-run regexp] [path ...]
+       glox tokens [-json] [-comments] file.glox`)
+	flag.PrintDefaults()
+}
+
 func runFile(name string) {
 	bs, err := ioutil.ReadFile(name)
 	if err != nil {
 		log.Fatal(err)
 	}
-	run(newInterpreter(), string(bs))
+	opts := interpreterOptions()
+	var prof *profiler
+	if *profile != "" {
+		prof = newProfiler(filepath.Base(name))
+		opts = append(opts, withHook(prof))
+	}
+	var cov *coverage
+	if *cover != "" {
+		if isCompiled(bs) {
+			log.Fatal("-cover needs the source of the script, not a compiled script")
+		}
+		cov = newCoverage()
+		opts = append(opts, withHook(cov))
+	}
+	if isCompiled(bs) {
+		var p *program
+		if p, err = decodeProgram(bs); err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		err = runProgram(newInterpreter(opts...), p)
+	} else {
+		err = run(newInterpreter(opts...), filepath.Base(name), string(bs), false)
+	}
+	if prof != nil {
+		writeProfile(prof, *profile)
+	}
+	if cov != nil && !hadParserError {
+		if err := writeCoverage(cov, name, string(bs), *cover); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if exit, ok := err.(*exitStatus); ok {
+		os.Exit(exit.code)
+	}
 	if hadParserError || hadRuntimeError || hadResolutionError {
 		os.Exit(1)
 	}
 }
 
+// writeProfile ends the profile and writes it out.
+func writeProfile(p *profiler, name string) {
+	p.finish()
+	p.writeReport(os.Stderr)
+	f, err := os.Create(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := p.writePprof(f); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// interpreterOptions translates the command line flags into interpreter
+// options.
+func interpreterOptions() []option {
+	granted, err := parseCapabilities(*caps)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := []option{
+		withStepLimit(*maxSteps), withTimeout(*timeout), withMaxCallDepth(*maxDepth),
+		withMaxStringLength(*maxString), withMaxCollectionSize(*maxElems), withMaxAllocations(*maxAllocs),
+		withCapabilities(granted...),
+	}
+	if *trace {
+		opts = append(opts, withHook(&tracer{}))
+	}
+	if *optimizeFlag {
+		opts = append(opts, withOptimizer())
+	}
+	return opts
+}
+
 func runPrompt() {
 	scanner := bufio.NewScanner(os.Stdin)
-	it := newInterpreter()
+	it := newInterpreter(interpreterOptions()...)
 	for {
 		fmt.Print("> ")
 		if !scanner.Scan() {
 			break
 		}
-		run(it, scanner.Text())
+		if exit, ok := run(it, "", scanner.Text(), true).(*exitStatus); ok {
+			os.Exit(exit.code)
+		}
 		hadParserError = false
 		hadRuntimeError = false
 		hadResolutionError = false
 	}
 }
 
-func run(it *interpreter, s string) {
-	sc := &scanner{source: s}
+// run executes the source s read from the given file. When echo is set, as
+// in the REPL, the value of a top-level expression statement is printed.
+// It returns the error interpret returned, if execution got that far.
+func run(it *interpreter, file, s string, echo bool) error {
+	sc := &scanner{source: s, file: file}
 	ts := sc.scanTokens()
 	if hadParserError {
-		return
+		return nil
 	}
 	p := &parser{tokens: ts}
 	ss := p.parse()
 	if hadParserError {
-		return
+		return nil
+	}
+	if echo {
+		for k, st := range ss {
+			if es, ok := st.(stmtExpression); ok {
+				es.echo = true
+				ss[k] = es
+			}
+		}
 	}
 
 	r := &resolver{inter: it, scopes: nil}
 	r.resolve(ss)
 	if hadResolutionError {
-		return
+		return nil
 	}
-
-	it.interpret(ss)
-	if hadRuntimeError {
-		return
+	if it.optimize {
+		ss = optimize(it, ss)
 	}
+
+	return it.interpret(ss)
 }
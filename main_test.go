@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs glox instead of the tests when GLOX_MAIN is set, so that
+// tests can run the command in a process of its own.
+func TestMain(m *testing.M) {
+	if os.Getenv("GLOX_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// result is what a run of glox printed and its exit status.
+type result struct {
+	stdout, stderr string
+	status         int
+}
+
+// glox runs the glox command with the given arguments.
+func glox(t *testing.T, args ...string) result {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "GLOX_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return result{stdout: stdout.String(), stderr: stderr.String(), status: cmd.ProcessState.ExitCode()}
+}
+
+// script writes source to a file of its own and returns its path.
+func script(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.glox")
+	if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestASTPositions(t *testing.T) {
+	r := glox(t, "ast", script(t, "var a = 1;\nfun f(x) {\n  return x;\n}\nclass C {\n  m() {}\n}\n"))
+	for _, want := range []string{"(Function @2:1-4:2 ", "(Class @5:1-7:2 ", "(Method @6:3-6:9 "} {
+		if !strings.Contains(r.stdout, want) {
+			t.Errorf("got %s, want %q", r.stdout, want)
+		}
+	}
+}
+
+func TestOptimizerKeepsBehavior(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("programs", "*.glox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			want := glox(t, f)
+			if got := glox(t, "-optimize", f); got != want {
+				t.Errorf("optimized: got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import "unicode/utf8"
+
+// nativeFunction is a callable implemented in Go.
+type nativeFunction struct {
+	name   string
+	params int
+	// capability must be granted to the script for the native to be
+	// available. Natives without one are always available.
+	capability string
+	fn         func(i *interpreter, paren token, args []interface{}) interface{}
+}
+
+var _ callable = nativeFunction{}
+
+func (n nativeFunction) call(i *interpreter, paren token, args []interface{}) interface{} {
+	return n.fn(i, paren, args)
+}
+
+func (n nativeFunction) arity() int {
+	return n.params
+}
+
+func (n nativeFunction) String() string {
+	return stringify(n)
+}
+
+// natives are defined in the global environment of every interpreter that
+// grants their capability.
+var natives = map[string]nativeFunction{
+	"len":  {name: "len", params: 1, fn: nativeLen},
+	"str":  {name: "str", params: 1, fn: nativeStr},
+	"push": {name: "push", params: 2, fn: nativePush},
+	"keys": {name: "keys", params: 1, fn: nativeKeys},
+
+	"await":   {name: "await", params: 1, fn: nativeAwait},
+	"channel": {name: "channel", params: 1, fn: nativeChannel},
+	"send":    {name: "send", params: 2, fn: nativeSend},
+	"recv":    {name: "recv", params: 1, fn: nativeRecv},
+	"close":   {name: "close", params: 1, fn: nativeClose},
+	"select":  {name: "select", params: 1, fn: nativeSelect},
+
+	"clock":     {name: "clock", params: 0, capability: capabilityTime, fn: nativeClock},
+	"readLine":  {name: "readLine", params: 0, capability: capabilityIORead, fn: nativeReadLine},
+	"readFile":  {name: "readFile", params: 1, capability: capabilityIORead, fn: nativeReadFile},
+	"writeFile": {name: "writeFile", params: 2, capability: capabilityIOWrite, fn: nativeWriteFile},
+	"sleep":     {name: "sleep", params: 1, capability: capabilityTime, fn: nativeSleep},
+	"getenv":    {name: "getenv", params: 1, capability: capabilityEnv, fn: nativeGetenv},
+	"exit":      {name: "exit", params: 1, capability: capabilityProcess, fn: nativeExit},
+}
+
+// nativeLen returns the number of characters (runes) in a string or the
+// number of elements in a list or map.
+func nativeLen(_ *interpreter, paren token, args []interface{}) interface{} {
+	switch v := args[0].(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v))
+	case *loxList:
+		return int64(len(v.elements))
+	case *loxMap:
+		return int64(len(v.keys))
+	}
+	reportRuntimeError(paren, "len() expects a string, list or map.")
+	return nil
+}
+
+// nativeStr converts any value to its string representation.
+func nativeStr(i *interpreter, paren token, args []interface{}) interface{} {
+	s := stringify(args[0])
+	i.checkStringLength(paren, len(s))
+	return s
+}
+
+// nativePush appends a value to the end of a list.
+func nativePush(i *interpreter, paren token, args []interface{}) interface{} {
+	l, ok := args[0].(*loxList)
+	if !ok {
+		reportRuntimeError(paren, "push() expects a list.")
+	}
+	i.checkCollectionSize(paren, len(l.elements)+1)
+	l.elements = append(l.elements, args[1])
+	return nil
+}
+
+// nativeKeys returns the keys of a map in insertion order.
+func nativeKeys(i *interpreter, paren token, args []interface{}) interface{} {
+	m, ok := args[0].(*loxMap)
+	if !ok {
+		reportRuntimeError(paren, "keys() expects a map.")
+	}
+	i.allocate(paren)
+	return &loxList{elements: append([]interface{}(nil), m.keys...)}
+}
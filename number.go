@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Numbers are represented either as int64 (integer literals and the
+// results of integer arithmetic) or as float64. Arithmetic on two integers
+// stays integral and fails on overflow; if either operand is a float64 the
+// other one is promoted and the result is a float64.
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// toFloat converts a number to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// bothIntegers reports whether left and right are both int64.
+func bothIntegers(left, right interface{}) (int64, int64, bool) {
+	l, lok := left.(int64)
+	r, rok := right.(int64)
+	return l, r, lok && rok
+}
+
+func (i *interpreter) arithmetic(op token, left, right interface{}) interface{} {
+	i.checkNumberOperands(op, left, right)
+	if l, r, ok := bothIntegers(left, right); ok {
+		return i.integerArithmetic(op, l, r)
+	}
+
+	l, _ := toFloat(left)
+	r, _ := toFloat(right)
+	switch op.tt {
+	case tokenTypePlus:
+		return l + r
+	case tokenTypeMinus:
+		return l - r
+	case tokenTypeStar:
+		return l * r
+	case tokenTypeSlash:
+		if r == 0 {
+			reportRuntimeError(op, "Division by zero")
+		}
+		return l / r
+	case tokenTypePercent:
+		if r == 0 {
+			reportRuntimeError(op, "Division by zero")
+		}
+		return math.Mod(l, r)
+	}
+	return nil
+}
+
+func (i *interpreter) integerArithmetic(op token, l, r int64) interface{} {
+	switch op.tt {
+	case tokenTypePlus:
+		v := l + r
+		if (v > l) != (r > 0) {
+			i.overflow(op, l, r)
+		}
+		return v
+	case tokenTypeMinus:
+		v := l - r
+		if (v < l) != (r > 0) {
+			i.overflow(op, l, r)
+		}
+		return v
+	case tokenTypeStar:
+		if l == 0 || r == 0 {
+			return int64(0)
+		}
+		v := l * r
+		if v/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+			i.overflow(op, l, r)
+		}
+		return v
+	case tokenTypeSlash, tokenTypePercent:
+		if r == 0 {
+			reportRuntimeError(op, "Division by zero")
+		}
+		if op.tt == tokenTypePercent {
+			return l % r
+		}
+		if l == math.MinInt64 && r == -1 {
+			i.overflow(op, l, r)
+		}
+		return l / r
+	}
+	return nil
+}
+
+func (i *interpreter) bitwise(op token, left, right interface{}) interface{} {
+	l, r, ok := bothIntegers(left, right)
+	if !ok {
+		reportRuntimeError(op, "Operands must be integers.")
+	}
+	switch op.tt {
+	case tokenTypeAmpersand:
+		return l & r
+	case tokenTypePipe:
+		return l | r
+	case tokenTypeCaret:
+		return l ^ r
+	case tokenTypeLessLess:
+		if r < 0 {
+			reportRuntimeError(op, "Negative shift count.")
+		}
+		if r >= 64 {
+			if l != 0 {
+				i.overflow(op, l, r)
+			}
+			return int64(0)
+		}
+		v := l << uint(r)
+		if v>>uint(r) != l {
+			i.overflow(op, l, r)
+		}
+		return v
+	case tokenTypeGreaterGreater:
+		if r < 0 {
+			reportRuntimeError(op, "Negative shift count.")
+		}
+		if r >= 64 {
+			r = 63
+		}
+		return l >> uint(r)
+	}
+	return nil
+}
+
+// compare evaluates a comparison operator on two numbers.
+func (i *interpreter) compare(op token, left, right interface{}) bool {
+	i.checkNumberOperands(op, left, right)
+	var c int
+	if l, r, ok := bothIntegers(left, right); ok {
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		}
+	} else {
+		l, _ := toFloat(left)
+		r, _ := toFloat(right)
+		switch {
+		case l < r:
+			c = -1
+		case l > r:
+			c = 1
+		case l != r:
+			// NaN is neither smaller, greater nor equal.
+			return false
+		}
+	}
+
+	switch op.tt {
+	case tokenTypeGreater:
+		return c > 0
+	case tokenTypeGreaterEqual:
+		return c >= 0
+	case tokenTypeLess:
+		return c < 0
+	case tokenTypeLessEqual:
+		return c <= 0
+	}
+	return false
+}
+
+func (i *interpreter) negate(op token, v interface{}) interface{} {
+	i.checkNumberOperand(op, v)
+	if n, ok := v.(int64); ok {
+		if n == math.MinInt64 {
+			reportRuntimeError(op, fmt.Sprintf("Integer overflow: -(%d).", n))
+		}
+		return -n
+	}
+	return -v.(float64)
+}
+
+func (i *interpreter) overflow(op token, l, r int64) {
+	reportRuntimeError(op, fmt.Sprintf("Integer overflow: %d %s %d.", l, op.lexeme, r))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/mathetake/glox/loxrt"
+)
+
+func TestNumbers(t *testing.T) {
+	for _, c := range []struct {
+		expr, want string
+	}{
+		// Integer arithmetic fails on overflow, unless a float64 operand
+		// promotes it.
+		{"9223372036854775807 + 1", "[Runtime Error at line 1:27] Integer overflow: 9223372036854775807 + 1.\n"},
+		{"-9223372036854775807 - 2", "[Runtime Error at line 1:28] Integer overflow: -9223372036854775807 - 2.\n"},
+		{"4611686018427387904 * 2", "[Runtime Error at line 1:27] Integer overflow: 4611686018427387904 * 2.\n"},
+		{"(-9223372036854775807 - 1) / -1", "[Runtime Error at line 1:34] Integer overflow: -9223372036854775808 / -1.\n"},
+		{"-(-9223372036854775807 - 1)", "[Runtime Error at line 1:7] Integer overflow: -(-9223372036854775808).\n"},
+		{"9223372036854775807 + 1.0", "9223372036854776000\n"},
+		{"4611686018427387904 * 2.0", "9223372036854776000\n"},
+
+		// Shifts.
+		{"1 << 62", "4611686018427387904\n"},
+		{"1 << 63", "[Runtime Error at line 1:9] Integer overflow: 1 << 63.\n"},
+		{"1 << 64", "[Runtime Error at line 1:9] Integer overflow: 1 << 64.\n"},
+		{"0 << 64", "0\n"},
+		{"8 >> 64", "0\n"},
+		{"-8 >> 1", "-4\n"},
+		{"-8 >> 100", "-1\n"},
+		{"1 << -1", "[Runtime Error at line 1:9] Negative shift count.\n"},
+		{"8 >> -1", "[Runtime Error at line 1:9] Negative shift count.\n"},
+		{"1.5 & 1", "[Runtime Error at line 1:11] Operands must be integers.\n"},
+
+		// Mixed arithmetic promotes integers to float64.
+		{"7 / 2", "3\n"},
+		{"7 / 2.0", "3.5\n"},
+		{"1 + 0.5", "1.5\n"},
+		{"3 - 0.5", "2.5\n"},
+		{"1 == 1.0", "true\n"},
+		{"2 < 2.5", "true\n"},
+		{"1 / 0.0", "[Runtime Error at line 1:9] Division by zero\n"},
+
+		// The sign of a remainder is that of the dividend.
+		{"-7 % 3", "-1\n"},
+		{"7 % -3", "1\n"},
+		{"-7 % 3.0", "-1\n"},
+		{"7.5 % -2", "1.5\n"},
+		{"1 % 0", "[Runtime Error at line 1:9] Division by zero\n"},
+
+		// Literals.
+		{"0xff + 0XFF", "510\n"},
+		{"0b101 + 0B0", "5\n"},
+		{"0x7fffffffffffffff", "9223372036854775807\n"},
+		{"1000000", "1000000\n"},
+	} {
+		t.Run(c.expr, func(t *testing.T) {
+			hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+			var out bytes.Buffer
+			run(newInterpreter(withOutput(&out), withDiagnostics(&out)), "test.glox", "print "+c.expr+";", false)
+			if got := out.String(); got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	for _, c := range []struct {
+		source string
+		want   interface{}
+		err    string
+	}{
+		{source: "0", want: int64(0)},
+		{source: "0x7fffffffffffffff", want: int64(9223372036854775807)},
+		{source: "0b1111", want: int64(15)},
+		{source: "0XaF", want: int64(175)},
+		{source: "1.50", want: 1.5},
+		{source: "9223372036854775807", want: int64(9223372036854775807)},
+		{source: "0x8000000000000000", err: "integer literal 0x8000000000000000 overflows int64"},
+		{source: "9223372036854775808", err: "integer literal 9223372036854775808 overflows int64"},
+		{source: "0x", err: "number literal has no digits"},
+		{source: "0b", err: "number literal has no digits"},
+		{source: "0b102", err: "invalid digit '2' in number literal"},
+		{source: "0xg", err: "invalid digit 'g' in number literal"},
+	} {
+		s := &scanner{source: c.source, file: "test.glox", quiet: true}
+		ts := s.scanTokens()
+		hadParserError = false
+		if c.err != "" {
+			if s.err == nil || s.err.message != c.err {
+				t.Errorf("%s: got the error %v, want %q", c.source, s.err, c.err)
+			}
+			continue
+		}
+		if s.err != nil || len(ts) != 2 || ts[0].literal != c.want {
+			t.Errorf("%s: got %v, %v, want %#v", c.source, ts, s.err, c.want)
+		}
+	}
+}
+
+// TestRuntimeNumbers checks that the number operations of loxrt, which
+// programs written by glox build use, agree with those of the interpreter.
+func TestRuntimeNumbers(t *testing.T) {
+	operands := []interface{}{
+		int64(0), int64(1), int64(-1), int64(2), int64(7), int64(-7), int64(3), int64(-3),
+		int64(63), int64(64), int64(100), int64(math.MaxInt64), int64(math.MinInt64),
+		0.0, 0.5, -2.5, 7.5, 3.0, math.Inf(1), math.NaN(), "a", nil,
+	}
+	binary := []struct {
+		tt tokenType
+		op string
+		fn func(loxrt.Pos, loxrt.Value, loxrt.Value) loxrt.Value
+	}{
+		{tokenTypePlus, "+", loxrt.Add},
+		{tokenTypeMinus, "-", loxrt.Subtract},
+		{tokenTypeStar, "*", loxrt.Multiply},
+		{tokenTypeSlash, "/", loxrt.Divide},
+		{tokenTypePercent, "%", loxrt.Modulo},
+		{tokenTypeAmpersand, "&", loxrt.BitAnd},
+		{tokenTypePipe, "|", loxrt.BitOr},
+		{tokenTypeCaret, "^", loxrt.BitXor},
+		{tokenTypeLessLess, "<<", loxrt.ShiftLeft},
+		{tokenTypeGreaterGreater, ">>", loxrt.ShiftRight},
+		{tokenTypeLess, "<", loxrt.Less},
+		{tokenTypeLessEqual, "<=", loxrt.LessEqual},
+		{tokenTypeGreater, ">", loxrt.Greater},
+		{tokenTypeGreaterEqual, ">=", loxrt.GreaterEqual},
+	}
+	it := newInterpreter()
+	for _, b := range binary {
+		for _, l := range operands {
+			for _, r := range operands {
+				op := token{tt: b.tt, lexeme: b.op, line: 1, column: 1}
+				want := outcome(func() interface{} {
+					return it.evaluate(exprBinary{left: exprLiteral{value: l}, operator: op, right: exprLiteral{value: r}})
+				})
+				got := outcome(func() interface{} { return b.fn(loxrt.Pos{Line: 1, Column: 1}, l, r) })
+				if got != want {
+					t.Errorf("%#v %s %#v: got %s from loxrt, want %s", l, b.op, r, got, want)
+				}
+			}
+		}
+	}
+	for _, v := range operands {
+		op := token{tt: tokenTypeMinus, lexeme: "-", line: 1, column: 1}
+		want := outcome(func() interface{} { return it.evaluate(exprUnary{operator: op, right: exprLiteral{value: v}}) })
+		got := outcome(func() interface{} { return loxrt.Negate(loxrt.Pos{Line: 1, Column: 1}, v) })
+		if got != want {
+			t.Errorf("-%#v: got %s from loxrt, want %s", v, got, want)
+		}
+	}
+	hadRuntimeError = false
+}
+
+// outcome describes the value f returns, with its type, or the runtime
+// error it raises.
+func outcome(f func() interface{}) (ret string) {
+	defer func() {
+		if r := recover(); r != nil {
+			ret = fmt.Sprint(r)
+		}
+	}()
+	v := f()
+	return fmt.Sprintf("%T %v", v, v)
+}
@@ -0,0 +1,277 @@
+package main
+
+// optimizer rewrites a resolved script so that it does less work at run
+// time without changing what it does. It folds operations on constants,
+// drops the branches of if statements and the while loops whose condition
+// is constant, and removes double negations where only truthiness matters
+// or the operand is a boolean anyway. An operation on constants that fails
+// is left alone, so that its error is still raised when, and if, it runs.
+type optimizer struct {
+	// inter evaluates the operations on constants, with its limits.
+	inter *interpreter
+}
+
+// withOptimizer makes run optimize scripts before interpreting them.
+func withOptimizer() option {
+	return func(i *interpreter) {
+		i.optimize = true
+	}
+}
+
+func optimize(i *interpreter, ss []stmt) []stmt {
+	return (&optimizer{inter: i}).stmts(ss)
+}
+
+func (o *optimizer) stmts(ss []stmt) []stmt {
+	var ret []stmt
+	for _, s := range ss {
+		if s = o.stmt(s); s != nil {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// body optimizes a statement that must remain, such as the body of a loop,
+// by replacing it with an empty block if it was removed.
+func (o *optimizer) body(s stmt) stmt {
+	if ret := o.stmt(s); ret != nil {
+		return ret
+	}
+	start := stmtStart(s)
+	return stmtBlock{open: start, close: start}
+}
+
+// stmt returns the optimized statement, or nil if it does nothing.
+func (o *optimizer) stmt(s stmt) stmt {
+	switch s := s.(type) {
+	case stmtExpression:
+		s.e = o.expr(s.e)
+		return s
+	case stmtPrint:
+		s.e = o.expr(s.e)
+		return s
+	case stmtVar:
+		if s.initializer != nil {
+			s.initializer = o.expr(s.initializer)
+		}
+		return s
+	case stmtReturn:
+		if s.value != nil {
+			s.value = o.expr(s.value)
+		}
+		return s
+	case stmtYield:
+		if s.value != nil {
+			s.value = o.expr(s.value)
+		}
+		return s
+	case stmtBlock:
+		s.statements = o.stmts(s.statements)
+		return s
+	case stmtIf:
+		s.condition = o.condition(s.condition)
+		if v, ok := constant(s.condition); ok {
+			if o.inter.isTruthy(v) {
+				return o.stmt(s.thenBranch)
+			} else if s.elseBranch != nil {
+				return o.stmt(s.elseBranch)
+			}
+			return nil
+		}
+		s.thenBranch = o.body(s.thenBranch)
+		if s.elseBranch != nil {
+			s.elseBranch = o.stmt(s.elseBranch)
+		}
+		return s
+	case stmtWhile:
+		s.condition = o.condition(s.condition)
+		if v, ok := constant(s.condition); ok && !o.inter.isTruthy(v) {
+			return nil
+		}
+		s.body = o.body(s.body)
+		return s
+	case stmtFor:
+		if s.initializer != nil {
+			s.initializer = o.stmt(s.initializer)
+		}
+		if s.condition != nil {
+			s.condition = o.condition(s.condition)
+		}
+		if s.increment != nil {
+			s.increment = o.expr(s.increment)
+		}
+		s.body = o.body(s.body)
+		return s
+	case stmtForIn:
+		s.iterable = o.expr(s.iterable)
+		s.body = o.body(s.body)
+		return s
+	case stmtFunction:
+		return o.function(s)
+	case stmtClass:
+		methods := make([]stmtFunction, len(s.methods))
+		for k, m := range s.methods {
+			methods[k] = o.function(m)
+		}
+		s.methods = methods
+		return s
+	}
+	return s
+}
+
+func (o *optimizer) function(s stmtFunction) stmtFunction {
+	s.body.statements = o.stmts(s.body.statements)
+	return s
+}
+
+// condition optimizes an expression whose value only matters for its
+// truthiness, where !!x can be replaced with x.
+func (o *optimizer) condition(e expr) expr {
+	e = o.expr(e)
+	for {
+		x, ok := doubleNegation(e)
+		if !ok {
+			return e
+		}
+		e = x
+	}
+}
+
+func (o *optimizer) expr(e expr) expr {
+	switch e := e.(type) {
+	case exprBinary:
+		e.left, e.right = o.expr(e.left), o.expr(e.right)
+		if _, ok := constant(e.left); !ok {
+			return e
+		}
+		if _, ok := constant(e.right); !ok {
+			return e
+		}
+		return o.fold(e, func() interface{} { return o.inter.visitBinaryExpr(e) })
+	case exprLogical:
+		e.left, e.right = o.expr(e.left), o.expr(e.right)
+		v, ok := constant(e.left)
+		if !ok {
+			return e
+		}
+		// The left operand is the result if it decides the outcome.
+		if o.inter.isTruthy(v) == (e.operator.tt == tokenTypeOr) {
+			return e.left
+		}
+		return e.right
+	case exprGrouping:
+		e.exp = o.expr(e.exp)
+		if _, ok := constant(e.exp); ok {
+			return e.exp
+		}
+		return e
+	case exprUnary:
+		e.right = o.expr(e.right)
+		if x, ok := doubleNegation(e); ok && isBoolean(x) {
+			return x
+		}
+		if _, ok := constant(e.right); !ok {
+			return e
+		}
+		return o.fold(e, func() interface{} { return o.inter.visitUnaryExpr(e) })
+	case exprAssign:
+		e.value = o.expr(e.value)
+		return e
+	case exprCall:
+		e.callee = o.expr(e.callee)
+		e.args = o.exprs(e.args)
+		return e
+	case exprSpawn:
+		e.call = o.expr(e.call).(exprCall)
+		return e
+	case exprGet:
+		e.obj = o.expr(e.obj)
+		return e
+	case exprSet:
+		e.obj, e.value = o.expr(e.obj), o.expr(e.value)
+		return e
+	case exprIndex:
+		e.obj, e.index = o.expr(e.obj), o.expr(e.index)
+		return e
+	case exprIndexSet:
+		e.obj, e.index, e.value = o.expr(e.obj), o.expr(e.index), o.expr(e.value)
+		return e
+	case exprList:
+		e.elements = o.exprs(e.elements)
+		return e
+	case exprMap:
+		e.keys, e.values = o.exprs(e.keys), o.exprs(e.values)
+		return e
+	}
+	return e
+}
+
+func (o *optimizer) exprs(es []expr) []expr {
+	ret := make([]expr, len(es))
+	for k, e := range es {
+		ret[k] = o.expr(e)
+	}
+	return ret
+}
+
+// fold replaces e with a literal of the value it computes, unless computing
+// it fails.
+func (o *optimizer) fold(e expr, value func() interface{}) (ret expr) {
+	failed := hadRuntimeError
+	defer func() {
+		if r := recover(); r != nil {
+			switch r.(type) {
+			case *runtimeError, *limitError:
+				hadRuntimeError = failed
+				ret = e
+			default:
+				panic(r)
+			}
+		}
+	}()
+	v := value()
+	// The literal keeps the position of the expression it replaces.
+	start := exprStart(e)
+	return exprLiteral{value: v, token: token{file: start.file, line: start.line, column: start.column}}
+}
+
+// constant returns the value of e if it is a literal.
+func constant(e expr) (interface{}, bool) {
+	if l, ok := e.(exprLiteral); ok {
+		return l.value, true
+	}
+	return nil, false
+}
+
+// doubleNegation returns x if e is !!x.
+func doubleNegation(e expr) (expr, bool) {
+	if outer, ok := e.(exprUnary); ok && outer.operator.tt == tokenTypeBang {
+		if inner, ok := outer.right.(exprUnary); ok && inner.operator.tt == tokenTypeBang {
+			return inner.right, true
+		}
+	}
+	return nil, false
+}
+
+// isBoolean reports whether e always evaluates to true or false.
+func isBoolean(e expr) bool {
+	switch e := e.(type) {
+	case exprLiteral:
+		_, ok := e.value.(bool)
+		return ok
+	case exprUnary:
+		return e.operator.tt == tokenTypeBang
+	case exprBinary:
+		switch e.operator.tt {
+		case tokenTypeEqualEqual, tokenTypeBangEqual, tokenTypeGreater, tokenTypeGreaterEqual,
+			tokenTypeLess, tokenTypeLessEqual:
+			return true
+		}
+	case exprLogical:
+		return isBoolean(e.left) && isBoolean(e.right)
+	case exprGrouping:
+		return isBoolean(e.exp)
+	}
+	return false
+}
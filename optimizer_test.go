@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// optimizedTree returns the syntax tree of source after optimization,
+// printed as glox ast does.
+func optimizedTree(t *testing.T, source string) string {
+	t.Helper()
+	hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	ts := (&scanner{source: source, file: "test.glox"}).scanTokens()
+	ss := (&parser{tokens: ts}).parse()
+	it := newInterpreter()
+	(&resolver{inter: it}).resolve(ss)
+	if hadParserError || hadResolutionError {
+		t.Fatalf("%q does not resolve", source)
+	}
+	root := newASTNode("Program", token{line: 1, column: 1}, ts[len(ts)-1])
+	root.childList("statements", (&astBuilder{locals: it.locals}).stmts(optimize(it, ss)))
+	var b strings.Builder
+	root.writeSExpr(&b, 0)
+	return b.String()
+}
+
+func TestOptimizer(t *testing.T) {
+	for _, c := range []struct {
+		name, source, output string
+		// has and lacks are what the optimized tree contains and does not.
+		has, lacks []string
+	}{
+		{
+			name:   "arithmetic",
+			source: "print 60 * 60 * 24;",
+			output: "86400\n",
+			has:    []string{"value=86400"},
+			lacks:  []string{"(Binary"},
+		},
+		{
+			name:   "concatenation",
+			source: `print "a" + "b";`,
+			output: "ab\n",
+			has:    []string{`value="ab"`},
+			lacks:  []string{"(Binary"},
+		},
+		{
+			name:   "if false",
+			source: "if (false) { print 1; } print 2;",
+			output: "2\n",
+			lacks:  []string{"(If", "value=1"},
+		},
+		{
+			name:   "if false with else",
+			source: "if (1 > 2) print 1; else print 2;",
+			output: "2\n",
+			lacks:  []string{"(If", "value=1"},
+		},
+		{
+			name:   "while false",
+			source: "while (false) { print 1; } print 2;",
+			output: "2\n",
+			lacks:  []string{"(While", "value=1"},
+		},
+		{
+			name:   "double negation of a number",
+			source: "var x = 1; print !!x;",
+			output: "true\n",
+			has:    []string{"(Unary"},
+		},
+		{
+			name:   "double negation in a condition",
+			source: `var x = 1; if (!!x) print "yes";`,
+			output: "yes\n",
+			lacks:  []string{"(Unary"},
+		},
+		{
+			name:   "division by zero never called",
+			source: `fun f() { return 1 / 0; } print "ok";`,
+			output: "ok\n",
+			has:    []string{"(Binary @1:18-1:23 operator=/"},
+		},
+		{
+			name:   "division by zero",
+			source: `print "before"; print 1 / 0;`,
+			output: "before\n",
+			has:    []string{"(Binary @1:23-1:28 operator=/"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := script(t, c.source)
+			plain := glox(t, path)
+			if plain.stdout != c.output {
+				t.Errorf("got %q, want %q", plain.stdout, c.output)
+			}
+			if optimized := glox(t, "-optimize", path); optimized != plain {
+				t.Errorf("optimized: got %+v, want %+v", optimized, plain)
+			}
+
+			tree := optimizedTree(t, c.source)
+			for _, s := range c.has {
+				if !strings.Contains(tree, s) {
+					t.Errorf("got the tree %s, want %q in it", tree, s)
+				}
+			}
+			for _, s := range c.lacks {
+				if strings.Contains(tree, s) {
+					t.Errorf("got the tree %s, want no %q in it", tree, s)
+				}
+			}
+		})
+	}
+}
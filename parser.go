@@ -2,19 +2,22 @@ package main
 
 import (
 	"fmt"
-	"runtime/debug"
 )
 
 type parser struct {
 	tokens  []token
 	current int
+
+	// err is the error that stopped parsing, if any. quiet keeps it from
+	// being printed.
+	err   *syntaxError
+	quiet bool
 }
 
 func (p *parser) parse() []stmt {
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Println(err)
-			fmt.Println(string(debug.Stack()))
+			p.err = recoverSyntaxError(err, p.quiet)
 		}
 	}()
 
@@ -39,8 +42,10 @@ func (p *parser) assignment() expr {
 			return exprAssign{name: ev.name, value: v}
 		} else if get, ok := expr.(exprGet); ok {
 			return exprSet{name: get.name, obj: get.obj, value: v}
+		} else if idx, ok := expr.(exprIndex); ok {
+			return exprIndexSet{obj: idx.obj, index: idx.index, value: v, bracket: idx.bracket}
 		}
-		reportRuntimeError(equal, "Invalid assignment target")
+		reportParserError(equal, "Invalid assignment target.")
 	}
 	return expr
 }
@@ -66,10 +71,13 @@ func (p *parser) and() expr {
 }
 
 func (p *parser) declaration() stmt {
-	if p.match(tokenTypeVar) {
+	if p.match(tokenTypeVar, tokenTypeConst) {
 		return p.varDeclaration()
 	} else if p.match(tokenTypeFun) {
-		return p.fun("function")
+		keyword := p.previous()
+		s := p.fun("function")
+		s.keyword = keyword
+		return s
 	} else if p.match(tokenTypeClass) {
 		return p.classDeclaration()
 	}
@@ -77,6 +85,7 @@ func (p *parser) declaration() stmt {
 }
 
 func (p *parser) classDeclaration() stmt {
+	keyword := p.previous()
 	name := p.consume(tokenTypeIdentifier, "Expect class name.")
 
 	var super *exprVariable
@@ -91,36 +100,64 @@ func (p *parser) classDeclaration() stmt {
 		methods = append(methods, p.fun("method"))
 	}
 
-	p.consume(tokenTypeRightBrace, "Expect '}' after class body")
+	close := p.consume(tokenTypeRightBrace, "Expect '}' after class body")
 	return stmtClass{
+		keyword:    keyword,
 		methods:    methods,
 		name:       name,
 		superClass: super,
+		close:      close,
 	}
 }
 
 func (p *parser) fun(kind string) stmtFunction {
+	generator := p.match(tokenTypeStar)
 	name := p.consume(tokenTypeIdentifier, fmt.Sprintf("Expect %s name.", kind))
 	p.consume(tokenTypeLeftParen, fmt.Sprintf("Expect '(' after %s name of %v", kind, name))
 
 	var ps []token
+	var types []*typeAnnotation
 	for !p.check(tokenTypeRightParen) {
 		ps = append(ps, p.consume(tokenTypeIdentifier, "Expect parameter name."))
+		types = append(types, p.typeAnnotation())
 		if !p.match(tokenTypeComma) {
 			break
 		}
 	}
 
 	p.consume(tokenTypeRightParen, "Expect ')' after parameters")
+	ret := p.typeAnnotation()
 	p.consume(tokenTypeLeftBrace, fmt.Sprintf("Expect '{' before %s body", kind))
 	body := p.blockStatement().(stmtBlock)
 	return stmtFunction{
-		params: ps,
-		body:   body,
-		name:   name,
+		params:     ps,
+		paramTypes: types,
+		returnType: ret,
+		body:       body,
+		name:       name,
+		generator:  generator,
 	}
 }
 
+// typeAnnotation parses the optional ': type' after a name or a parameter
+// list and returns nil if there is none.
+func (p *parser) typeAnnotation() *typeAnnotation {
+	if !p.match(tokenTypeColon) {
+		return nil
+	}
+	var name token
+	if p.match(tokenTypeNil, tokenTypeFun) {
+		name = p.previous()
+	} else {
+		name = p.consume(tokenTypeIdentifier, "Expect type after ':'.")
+	}
+	t := &typeAnnotation{name: name, end: name}
+	if p.match(tokenTypeQuestion) {
+		t.nullable, t.end = true, p.previous()
+	}
+	return t
+}
+
 func (p *parser) statement() stmt {
 	if p.match(tokenTypePrint) {
 		return p.printStatement()
@@ -135,6 +172,8 @@ func (p *parser) statement() stmt {
 		return p.forStatement()
 	} else if p.match(tokenTypeReturn) {
 		return p.returnStatement()
+	} else if p.match(tokenTypeYield) {
+		return p.yieldStatement()
 	}
 	return p.expressionStatement()
 }
@@ -146,14 +185,35 @@ func (p *parser) returnStatement() stmt {
 		exp = p.expression()
 	}
 	p.consume(tokenTypeSemicolon, "Expect ';' at the end of return statement")
-	return &stmtReturn{
+	return stmtReturn{
 		keyword: k,
 		value:   exp,
 	}
 }
 
+func (p *parser) yieldStatement() stmt {
+	k := p.previous()
+	var exp expr
+	if !p.check(tokenTypeSemicolon) {
+		exp = p.expression()
+	}
+	p.consume(tokenTypeSemicolon, "Expect ';' after yield value.")
+	return stmtYield{keyword: k, value: exp}
+}
+
 func (p *parser) forStatement() stmt {
-	p.consume(tokenTypeLeftParen, "Expect '(' after 'while'.")
+	keyword := p.previous()
+	p.consume(tokenTypeLeftParen, "Expect '(' after 'for'.")
+
+	// in is only a keyword after the variable of a for-in loop.
+	if p.check(tokenTypeIdentifier) && p.current+1 < len(p.tokens) &&
+		p.tokens[p.current+1].tt == tokenTypeIdentifier && p.tokens[p.current+1].lexeme == "in" {
+		name := p.advance()
+		p.advance()
+		iterable := p.expression()
+		p.consume(tokenTypeRightParen, "Expect ')' after for-in iterable.")
+		return stmtForIn{keyword: keyword, name: name, iterable: iterable, body: p.statement()}
+	}
 
 	var init stmt
 	if p.match(tokenTypeSemicolon) {
@@ -166,8 +226,6 @@ func (p *parser) forStatement() stmt {
 	var cond expr
 	if !p.check(tokenTypeSemicolon) {
 		cond = p.expression()
-	} else {
-		cond = exprLiteral{value: true}
 	}
 
 	p.consume(tokenTypeSemicolon, "Expect ';' after loop condition.")
@@ -179,33 +237,30 @@ func (p *parser) forStatement() stmt {
 
 	p.consume(tokenTypeRightParen, "Expect ')' after condition")
 
-	body := p.statement()
-	if incr != nil {
-		body = stmtBlock{statements: []stmt{body, stmtExpression{e: incr}}}
-	}
-
-	body = stmtWhile{
-		condition: cond,
-		body:      body,
-	}
-	if init != nil {
-		body = stmtBlock{statements: []stmt{init, body}}
+	return stmtFor{
+		keyword:     keyword,
+		initializer: init,
+		condition:   cond,
+		increment:   incr,
+		body:        p.statement(),
 	}
-	return body
 }
 
 func (p *parser) whileStatement() stmt {
+	keyword := p.previous()
 	p.consume(tokenTypeLeftParen, "Expect '(' after 'while'.")
 	cond := p.expression()
 	p.consume(tokenTypeRightParen, "Expect ')' after condition")
 	body := p.statement()
 	return stmtWhile{
+		keyword:   keyword,
 		condition: cond,
 		body:      body,
 	}
 }
 
 func (p *parser) ifStatement() stmt {
+	keyword := p.previous()
 	p.consume(tokenTypeLeftParen, "Expect '(' after 'if'.")
 	cond := p.expression()
 	p.consume(tokenTypeRightParen, "Expect ')' after if condition.")
@@ -217,6 +272,7 @@ func (p *parser) ifStatement() stmt {
 	}
 
 	return stmtIf{
+		keyword:    keyword,
 		condition:  cond,
 		thenBranch: thenBr,
 		elseBranch: elseBr,
@@ -224,31 +280,40 @@ func (p *parser) ifStatement() stmt {
 }
 
 func (p *parser) blockStatement() stmt {
+	open := p.previous()
 	var ss []stmt
 	for !p.check(tokenTypeRightBrace) && !p.isAtEnd() {
 		ss = append(ss, p.declaration())
 	}
 
-	p.consume(tokenTypeRightBrace, "Expect '}' after block.")
-	return stmtBlock{statements: ss}
+	close := p.consume(tokenTypeRightBrace, "Expect '}' after block.")
+	return stmtBlock{statements: ss, open: open, close: close}
 }
 
 func (p *parser) printStatement() stmt {
+	keyword := p.previous()
 	e := p.expression()
 	p.consume(tokenTypeSemicolon, "Expect ';' after expression.")
-	return stmtPrint{e: e}
+	return stmtPrint{keyword: keyword, e: e}
 }
 
 func (p *parser) varDeclaration() stmt {
+	keyword := p.previous()
 	n := p.consume(tokenTypeIdentifier, "Expect variable name.")
+	typ := p.typeAnnotation()
 	var init expr
-	if p.match(tokenTypeEqual) {
+	if keyword.tt == tokenTypeConst {
+		p.consume(tokenTypeEqual, "Expect '=' after constant name.")
+		init = p.expression()
+	} else if p.match(tokenTypeEqual) {
 		init = p.expression()
 	}
 
 	p.consume(tokenTypeSemicolon, "Expect ';' after variable declaration.")
 	return stmtVar{
+		keyword:     keyword,
 		name:        n,
+		typ:         typ,
 		initializer: init,
 	}
 }
@@ -283,9 +348,11 @@ func (p *parser) comparison() expr {
 	return e
 }
 
+// addition parses the additive operators. As in Go, '|' and '^' share
+// the precedence of '+' and '-'.
 func (p *parser) addition() expr {
 	e := p.multiplication()
-	for p.match(tokenTypeMinus, tokenTypePlus) {
+	for p.match(tokenTypeMinus, tokenTypePlus, tokenTypePipe, tokenTypeCaret) {
 		o := p.previous()
 		r := p.multiplication()
 		e = exprBinary{left: e, right: r, operator: o}
@@ -293,9 +360,12 @@ func (p *parser) addition() expr {
 	return e
 }
 
+// multiplication parses the multiplicative operators. As in Go, '%',
+// '&' and the shifts share the precedence of '*' and '/'.
 func (p *parser) multiplication() expr {
 	e := p.unary()
-	for p.match(tokenTypeSlash, tokenTypeStar) {
+	for p.match(tokenTypeSlash, tokenTypeStar, tokenTypePercent,
+		tokenTypeAmpersand, tokenTypeLessLess, tokenTypeGreaterGreater) {
 		o := p.previous()
 		r := p.unary()
 		e = exprBinary{left: e, right: r, operator: o}
@@ -311,6 +381,14 @@ func (p *parser) unary() expr {
 			right:    p.unary(),
 		}
 	}
+	if p.match(tokenTypeSpawn) {
+		keyword := p.previous()
+		call, ok := p.call().(exprCall)
+		if !ok {
+			reportParserError(keyword, "Expect a call after 'spawn'.")
+		}
+		return exprSpawn{keyword: keyword, call: call}
+	}
 	return p.call()
 }
 
@@ -322,6 +400,10 @@ func (p *parser) call() expr {
 		} else if p.match(tokenTypeDot) {
 			name := p.consume(tokenTypeIdentifier, "Expect property name after '.'.")
 			pr = exprGet{name: name, obj: pr}
+		} else if p.match(tokenTypeLeftBracket) {
+			index := p.expression()
+			bracket := p.consume(tokenTypeRightBracket, "Expect ']' after index.")
+			pr = exprIndex{obj: pr, index: index, bracket: bracket}
 		} else {
 			break
 		}
@@ -353,17 +435,22 @@ func (p *parser) finishCall(callee expr) expr {
 func (p *parser) primary() expr {
 	switch {
 	case p.match(tokenTypeFalse):
-		return exprLiteral{value: false}
+		return exprLiteral{value: false, token: p.previous()}
 	case p.match(tokenTypeTrue):
-		return exprLiteral{value: true}
+		return exprLiteral{value: true, token: p.previous()}
 	case p.match(tokenTypeNil):
-		return exprLiteral{value: nil}
+		return exprLiteral{value: nil, token: p.previous()}
 	case p.match(tokenTypeNumber, tokenTypeString):
-		return exprLiteral{value: p.previous().literal}
+		return exprLiteral{value: p.previous().literal, token: p.previous()}
 	case p.match(tokenTypeLeftParen):
+		open := p.previous()
 		e := p.expression()
-		p.consume(tokenTypeRightParen, "Expect ')' after expression.")
-		return exprGrouping{exp: e}
+		close := p.consume(tokenTypeRightParen, "Expect ')' after expression.")
+		return exprGrouping{exp: e, open: open, close: close}
+	case p.match(tokenTypeLeftBracket):
+		return p.listLiteral()
+	case p.match(tokenTypeLeftBrace):
+		return p.mapLiteral()
 	case p.match(tokenTypeSuper):
 		k := p.previous()
 		p.consume(tokenTypeDot, "Expect '.' after 'super'.")
@@ -379,6 +466,34 @@ func (p *parser) primary() expr {
 	return nil
 }
 
+func (p *parser) listLiteral() expr {
+	bracket := p.previous()
+	var es []expr
+	for !p.check(tokenTypeRightBracket) {
+		es = append(es, p.expression())
+		if !p.match(tokenTypeComma) {
+			break
+		}
+	}
+	close := p.consume(tokenTypeRightBracket, "Expect ']' after list elements.")
+	return exprList{bracket: bracket, close: close, elements: es}
+}
+
+func (p *parser) mapLiteral() expr {
+	brace := p.previous()
+	var ks, vs []expr
+	for !p.check(tokenTypeRightBrace) {
+		ks = append(ks, p.expression())
+		p.consume(tokenTypeColon, "Expect ':' after map key.")
+		vs = append(vs, p.expression())
+		if !p.match(tokenTypeComma) {
+			break
+		}
+	}
+	close := p.consume(tokenTypeRightBrace, "Expect '}' after map entries.")
+	return exprMap{brace: brace, close: close, keys: ks, values: vs}
+}
+
 func (p *parser) consume(t tokenType, msg string) token {
 	if p.check(t) {
 		return p.advance()
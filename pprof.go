@@ -0,0 +1,158 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// writePprof writes the sampled stacks in the gzipped protocol buffer
+// format of pprof, so that `go tool pprof` can open the profile. Each
+// location is a line of a Lox function.
+func (p *profiler) writePprof(out io.Writer) error {
+	b := &protoBuffer{}
+	indices := map[string]int64{}
+	str := func(s string) int64 {
+		n, ok := indices[s]
+		if !ok {
+			n = int64(len(indices))
+			indices[s] = n
+		}
+		return n
+	}
+	str("")
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func() {
+			b.int64Field(1, str(typ))
+			b.int64Field(2, str(unit))
+		})
+	}
+	valueType(1, "samples", "count")
+	valueType(1, "time", "nanoseconds")
+
+	functions := map[token]uint64{}
+	var functionOrder []token
+	locations := map[profileFrame]uint64{}
+	var locationOrder []profileFrame
+	for _, key := range p.sampleOrder {
+		s := p.samples[key]
+		ids := make([]uint64, len(s.stack))
+		for k, f := range s.stack {
+			if _, ok := functions[f.function]; !ok {
+				functions[f.function] = uint64(len(functions) + 1)
+				functionOrder = append(functionOrder, f.function)
+			}
+			id, ok := locations[f]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locations[f] = id
+				locationOrder = append(locationOrder, f)
+			}
+			// pprof lists the innermost frame first.
+			ids[len(ids)-1-k] = id
+		}
+		b.message(2, func() {
+			b.packedField(1, ids)
+			b.packedField(2, []uint64{uint64(s.count), uint64(s.time)})
+		})
+	}
+	for _, f := range locationOrder {
+		b.message(4, func() {
+			b.int64Field(1, int64(locations[f]))
+			b.message(4, func() {
+				b.int64Field(1, int64(functions[f.function]))
+				b.int64Field(2, int64(f.line.line))
+			})
+		})
+	}
+	for _, f := range functionOrder {
+		name := f.lexeme
+		if f == p.script {
+			// pprof drops names in angle brackets, as it does C++ templates.
+			name = strings.Trim(name, "<>")
+		}
+		b.message(5, func() {
+			b.int64Field(1, int64(functions[f]))
+			b.int64Field(2, str(name))
+			b.int64Field(3, str(f.lexeme))
+			b.int64Field(4, str(f.file))
+			b.int64Field(5, int64(f.line))
+		})
+	}
+
+	table := make([]string, len(indices))
+	for s, n := range indices {
+		table[n] = s
+	}
+	for _, s := range table {
+		b.bytesField(6, []byte(s))
+	}
+	b.int64Field(9, p.start.UnixNano())
+	b.int64Field(10, int64(p.last.Sub(p.start)))
+	// The period type refers to strings added above.
+	valueType(11, "time", "nanoseconds")
+	b.int64Field(12, int64(profileSamplePeriod))
+
+	z := gzip.NewWriter(out)
+	if _, err := z.Write(b.bytes); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// protoBuffer encodes protocol buffer messages. Fields are written in the
+// order they are added, nested messages through message.
+type protoBuffer struct {
+	bytes []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.bytes = append(b.bytes, byte(x)|0x80)
+		x >>= 7
+	}
+	b.bytes = append(b.bytes, byte(x))
+}
+
+func (b *protoBuffer) key(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// int64Field writes a varint field, leaving it out when it is zero as
+// proto3 does.
+func (b *protoBuffer) int64Field(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(uint64(x))
+}
+
+func (b *protoBuffer) bytesField(field int, bs []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(bs)))
+	b.bytes = append(b.bytes, bs...)
+}
+
+func (b *protoBuffer) packedField(field int, xs []uint64) {
+	inner := &protoBuffer{}
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytesField(field, inner.bytes)
+}
+
+// message writes the fields added by fill as a nested message.
+func (b *protoBuffer) message(field int, fill func()) {
+	outer := b.bytes
+	b.bytes = nil
+	fill()
+	inner := b.bytes
+	b.bytes = outer
+	b.bytesField(field, inner)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// profiler is a hook that measures where a script spends its time. Every
+// event charges the time since the previous one to the function and line
+// executing, which gives exact exclusive times. A function or line is
+// charged inclusive time while it is anywhere on the call stack, counted
+// once however many times recursion put it there. Samples of the whole
+// stack are taken every profileSamplePeriod for the pprof profile. Tasks
+// and generators run on interpreters of their own, each with its own call
+// stack.
+type profiler struct {
+	now   func() time.Time
+	start time.Time
+	last  time.Time
+
+	// script stands for the top level of the script as a function.
+	script token
+	// stacks are the call stacks of the interpreters, and current the
+	// interpreter of the last event, which ran since.
+	stacks    map[*interpreter][]profileFrame
+	current   *interpreter
+	functions map[token]*profileEntry
+	lines     map[profileLine]*profileEntry
+	// functionOrder and lineOrder keep the order in which entries were
+	// first seen, to break ties in reports.
+	functionOrder []token
+	lineOrder     []profileLine
+
+	lastSample  time.Time
+	samples     map[string]*profileSample
+	sampleOrder []string
+}
+
+// profileSamplePeriod is how often the profiler samples the call stack.
+const profileSamplePeriod = 100 * time.Microsecond
+
+type profileFrame struct {
+	function token
+	line     profileLine
+}
+
+type profileLine struct {
+	file string
+	line int
+}
+
+// profileEntry accumulates the measurements of a function or line. active
+// counts how many times it is on the call stack, and since is when that
+// count last became positive.
+type profileEntry struct {
+	name                 string
+	count                int64
+	inclusive, exclusive time.Duration
+	active               int
+	since                time.Time
+}
+
+type profileSample struct {
+	stack []profileFrame
+	count int64
+	time  time.Duration
+}
+
+// profileScript is the name of the top level of the script in reports.
+const profileScript = "<script>"
+
+// newProfiler returns a profiler for a script read from the given file.
+func newProfiler(file string) *profiler {
+	p := &profiler{
+		now:       time.Now,
+		stacks:    map[*interpreter][]profileFrame{},
+		functions: map[token]*profileEntry{},
+		lines:     map[profileLine]*profileEntry{},
+		samples:   map[string]*profileSample{},
+	}
+	p.script = token{lexeme: profileScript, file: file}
+	return p
+}
+
+var _ hook = &profiler{}
+
+func (p *profiler) statement(i *interpreter, s stmt) {
+	now := p.charge(i)
+	t := stmtStart(s)
+	stack := p.stacks[i]
+	top := &stack[len(stack)-1]
+	l := profileLine{t.file, t.line}
+	if top.line != l {
+		p.deactivate(p.line(top.line), now)
+		top.line = l
+		p.activate(p.line(l), now)
+	}
+	p.line(l).count++
+}
+
+func (p *profiler) enter(i *interpreter, f loxFunction, _ []interface{}) {
+	p.charge(i)
+	name := f.declaration.name
+	p.push(i, name, profileLine{name.file, name.line})
+	p.function(name).count++
+}
+
+func (p *profiler) leave(i *interpreter, _ loxFunction, _ interface{}, _ bool) {
+	now := p.charge(i)
+	p.pop(i, now)
+}
+
+func (p *profiler) push(i *interpreter, f token, l profileLine) {
+	now := p.last
+	p.stacks[i] = append(p.stacks[i], profileFrame{f, l})
+	p.activate(p.function(f), now)
+	p.activate(p.line(l), now)
+}
+
+func (p *profiler) pop(i *interpreter, now time.Time) {
+	stack := p.stacks[i]
+	top := stack[len(stack)-1]
+	if len(stack) == 1 {
+		delete(p.stacks, i)
+	} else {
+		p.stacks[i] = stack[:len(stack)-1]
+	}
+	p.deactivate(p.line(top.line), now)
+	p.deactivate(p.function(top.function), now)
+}
+
+// finish stops the profile, closing the frames a failed script left open.
+func (p *profiler) finish() {
+	if p.start.IsZero() {
+		return
+	}
+	now := p.charge(p.current)
+	for i := range p.stacks {
+		for len(p.stacks[i]) > 0 {
+			p.pop(i, now)
+		}
+	}
+}
+
+// charge charges the time elapsed since the last event to the top of the
+// stack of the interpreter that ran, makes i the current one and returns
+// the current time.
+func (p *profiler) charge(i *interpreter) time.Time {
+	now := p.now()
+	if p.start.IsZero() {
+		// The profile starts with the script, after it was parsed.
+		p.start, p.last, p.lastSample, p.current = now, now, now, i
+		p.push(i, p.script, profileLine{file: p.script.file})
+	}
+	d := now.Sub(p.last)
+	p.last = now
+	if stack := p.stacks[p.current]; len(stack) > 0 {
+		top := stack[len(stack)-1]
+		p.function(top.function).exclusive += d
+		p.line(top.line).exclusive += d
+		if now.Sub(p.lastSample) >= profileSamplePeriod {
+			p.sample(stack, now.Sub(p.lastSample))
+			p.lastSample = now
+		}
+	}
+	p.current = i
+	return now
+}
+
+func (p *profiler) sample(stack []profileFrame, d time.Duration) {
+	var key strings.Builder
+	for _, f := range stack {
+		fmt.Fprintf(&key, "%s:%d:%d/%d;", f.function.file, f.function.line, f.function.column, f.line.line)
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &profileSample{stack: append([]profileFrame(nil), stack...)}
+		p.samples[key.String()] = s
+		p.sampleOrder = append(p.sampleOrder, key.String())
+	}
+	s.count++
+	s.time += d
+}
+
+func (p *profiler) activate(e *profileEntry, now time.Time) {
+	if e.active == 0 {
+		e.since = now
+	}
+	e.active++
+}
+
+func (p *profiler) deactivate(e *profileEntry, now time.Time) {
+	e.active--
+	if e.active == 0 {
+		e.inclusive += now.Sub(e.since)
+	}
+}
+
+func (p *profiler) function(name token) *profileEntry {
+	e, ok := p.functions[name]
+	if !ok {
+		e = &profileEntry{name: profileScript}
+		if name != p.script {
+			e.name = fmt.Sprintf("%s (%s)", name.lexeme, lineLabel(name.file, name.line))
+		}
+		p.functions[name] = e
+		p.functionOrder = append(p.functionOrder, name)
+	}
+	return e
+}
+
+func (p *profiler) line(l profileLine) *profileEntry {
+	e, ok := p.lines[l]
+	if !ok {
+		e = &profileEntry{name: lineLabel(l.file, l.line)}
+		p.lines[l] = e
+		p.lineOrder = append(p.lineOrder, l)
+	}
+	return e
+}
+
+// maxProfileLines is the number of lines listed in the text report.
+const maxProfileLines = 20
+
+// writeReport writes the text report: functions by inclusive time and the
+// lines that took the most exclusive time.
+func (p *profiler) writeReport(out io.Writer) {
+	total := p.last.Sub(p.start)
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(out, "profile: %v total\n", total.Round(time.Microsecond))
+
+	var fs []*profileEntry
+	for _, name := range p.functionOrder {
+		if name != p.script {
+			fs = append(fs, p.functions[name])
+		}
+	}
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].inclusive > fs[j].inclusive })
+	fmt.Fprintln(w, "calls\tinclusive\t%\texclusive\t%\t\tfunction")
+	for _, e := range fs {
+		fmt.Fprintf(w, "%d\t%v\t%s\t%v\t%s\t\t%s\n", e.count,
+			e.inclusive.Round(time.Microsecond), percent(e.inclusive, total),
+			e.exclusive.Round(time.Microsecond), percent(e.exclusive, total), e.name)
+	}
+	fmt.Fprintln(w, "\t\t\t\t\t\t")
+
+	var ls []*profileEntry
+	for _, l := range p.lineOrder {
+		if l.line > 0 {
+			ls = append(ls, p.lines[l])
+		}
+	}
+	sort.SliceStable(ls, func(i, j int) bool { return ls[i].exclusive > ls[j].exclusive })
+	if len(ls) > maxProfileLines {
+		ls = ls[:maxProfileLines]
+	}
+	fmt.Fprintln(w, "count\tinclusive\t%\texclusive\t%\t\tline")
+	for _, e := range ls {
+		fmt.Fprintf(w, "%d\t%v\t%s\t%v\t%s\t\t%s\n", e.count,
+			e.inclusive.Round(time.Microsecond), percent(e.inclusive, total),
+			e.exclusive.Round(time.Microsecond), percent(e.exclusive, total), e.name)
+	}
+	w.Flush()
+}
+
+func percent(d, total time.Duration) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(total))
+}
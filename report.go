@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+)
 
 var (
 	hadParserError     bool
@@ -8,24 +12,72 @@ var (
 	hadResolutionError bool
 )
 
-func reportParserError(t token, message string) {
-	r := func(line int, where, message string) string {
-		hadParserError = true
-		return fmt.Sprintf("[Parse Error line at %d] Error %s: %s \n", line, where, message)
+// syntaxError is an error found while scanning, parsing or resolving a
+// script. It is raised as a panic and recovered by the pass that found it.
+type syntaxError struct {
+	token   token
+	message string
+	// text is the message as printed for the user.
+	text string
+}
+
+func (e *syntaxError) Error() string {
+	return e.text
+}
+
+// recoverSyntaxError handles the value v recovered by a scanner, parser or
+// resolver. Syntax errors are printed unless quiet is set. Any other value
+// is a bug in glox and is printed with its Go stack trace, to stderr when
+// quiet. The error is returned in both cases.
+func recoverSyntaxError(v interface{}, quiet bool) *syntaxError {
+	if err, ok := v.(*syntaxError); ok {
+		if !quiet {
+			fmt.Println(err)
+		}
+		return err
 	}
-	if t.tt == tokenTypeEOF {
-		panic(r(t.line, " at end", message))
+	if quiet {
+		fmt.Fprintln(os.Stderr, v)
+		fmt.Fprintln(os.Stderr, string(debug.Stack()))
 	} else {
-		panic(r(t.line, " at '"+t.lexeme+"'", message))
+		fmt.Println(v)
+		fmt.Println(string(debug.Stack()))
+	}
+	msg := fmt.Sprint(v)
+	return &syntaxError{message: msg, text: msg}
+}
+
+func reportParserError(t token, message string) {
+	hadParserError = true
+	where := " at '" + t.lexeme + "'"
+	if t.tt == tokenTypeEOF {
+		where = " at end"
 	}
+	panic(&syntaxError{token: t, message: message,
+		text: fmt.Sprintf("[Parse Error line at %d:%d] Error %s: %s \n", t.line, t.column, where, message)})
+}
+
+// runtimeError is raised (as a panic) when a script fails at run time and
+// is recovered by interpreter.interpret.
+type runtimeError struct {
+	token   token
+	message string
+	// trace is the Lox call stack at the point of the error, if known.
+	trace []traceLine
+}
+
+func (e *runtimeError) Error() string {
+	return fmt.Sprintf("[Runtime Error at line %d:%d] %s", e.token.line, e.token.column, e.message) +
+		formatTrace(e.trace)
 }
 
 func reportRuntimeError(t token, message string) {
 	hadRuntimeError = true
-	panic(fmt.Sprintf("[Runtime Error at line %d] %s\n", t.line, message))
+	panic(&runtimeError{token: t, message: message})
 }
 
 func reportResolutionError(t token, message string) {
 	hadResolutionError = true
-	panic(fmt.Sprintf("[Resolution Error at line %d] %s\n", t.line, message))
+	panic(&syntaxError{token: t, message: message,
+		text: fmt.Sprintf("[Resolution Error at line %d:%d] %s\n", t.line, t.column, message)})
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"runtime/debug"
 )
 
 type resolver struct {
@@ -10,6 +9,25 @@ type resolver struct {
 	scopes              []map[string]bool
 	currentFunctionType functionType
 	currentClass        classType
+	// globals are the names declared at the top level of the script, with
+	// the token that declared them.
+	globals map[string]token
+	// declared parallels scopes with the token that declared each name.
+	declared []map[string]token
+	// constants are the declaring tokens of constants.
+	constants map[token]bool
+	// refs, if not nil, is filled with the declaring token of every
+	// variable reference and declaration that could be resolved. Editor
+	// tooling uses it; the interpreter does not need it.
+	refs map[token]token
+	// natives are the references to natives that have a capability, which
+	// compile saves so that they can be checked when the script is loaded.
+	natives []token
+
+	// err is the error that stopped resolution, if any. quiet keeps it from
+	// being printed.
+	err   *syntaxError
+	quiet bool
 }
 
 type functionType int
@@ -19,6 +37,7 @@ const (
 	functionTypeFunction
 	functionTypeInitializer
 	functionTypeMethod
+	functionTypeGenerator
 )
 
 type classType int
@@ -32,19 +51,38 @@ const (
 func (r *resolver) resolve(ss []stmt) {
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Println(err)
-			fmt.Println(string(debug.Stack()))
+			r.err = recoverSyntaxError(err, r.quiet)
 		}
 	}()
+	r.globals = map[string]token{}
+	for _, s := range ss {
+		var name token
+		switch s := s.(type) {
+		case stmtVar:
+			name = s.name
+		case stmtFunction:
+			name = s.name
+		case stmtClass:
+			name = s.name
+		default:
+			continue
+		}
+		r.globals[name.lexeme] = name
+		if r.refs != nil {
+			r.refs[name] = name
+		}
+	}
 	r.resolveStatements(ss)
 }
 
 func (r *resolver) pushScope(scope map[string]bool) {
 	r.scopes = append(r.scopes, scope)
+	r.declared = append(r.declared, map[string]token{})
 }
 
 func (r *resolver) popScope() {
 	r.scopes = r.scopes[:len(r.scopes)-1]
+	r.declared = r.declared[:len(r.declared)-1]
 }
 
 func (r *resolver) peekScope() map[string]bool {
@@ -117,6 +155,44 @@ func (r *resolver) visitWhileStatement(s stmtWhile) interface{} {
 	return nil
 }
 
+func (r *resolver) visitForStatement(s stmtFor) interface{} {
+	if s.initializer != nil {
+		r.beginScope()
+		r.resolveStatement(s.initializer)
+	}
+	if s.condition != nil {
+		r.resolveExpression(s.condition)
+	}
+	r.resolveStatement(s.body)
+	if s.increment != nil {
+		r.resolveExpression(s.increment)
+	}
+	if s.initializer != nil {
+		r.endScope()
+	}
+	return nil
+}
+
+func (r *resolver) visitYieldStatement(s stmtYield) interface{} {
+	if r.currentFunctionType != functionTypeGenerator {
+		reportResolutionError(s.keyword, "Cannot yield outside of a generator.")
+	}
+	if s.value != nil {
+		r.resolveExpression(s.value)
+	}
+	return nil
+}
+
+func (r *resolver) visitForInStatement(s stmtForIn) interface{} {
+	r.resolveExpression(s.iterable)
+	r.beginScope()
+	r.declare(s.name)
+	r.define(s.name)
+	r.resolveStatement(s.body)
+	r.endScope()
+	return nil
+}
+
 func (r *resolver) visitReturnStatement(s stmtReturn) interface{} {
 	if r.currentFunctionType == functionTypeNone {
 		reportResolutionError(s.keyword, "Cannot return from top-level code.")
@@ -125,6 +201,9 @@ func (r *resolver) visitReturnStatement(s stmtReturn) interface{} {
 		if r.currentFunctionType == functionTypeInitializer {
 			reportResolutionError(s.keyword, "Cannot return from an initializer.")
 		}
+		if r.currentFunctionType == functionTypeGenerator {
+			reportResolutionError(s.keyword, "Cannot return a value from a generator.")
+		}
 		r.resolveExpression(s.value)
 	}
 	return nil
@@ -178,6 +257,12 @@ func (r *resolver) visitBlockStatement(s stmtBlock) interface{} {
 }
 
 func (r *resolver) visitVarStatement(s stmtVar) interface{} {
+	if s.constant() {
+		if r.constants == nil {
+			r.constants = map[token]bool{}
+		}
+		r.constants[s.name] = true
+	}
 	r.declare(s.name)
 	if s.initializer != nil {
 		r.resolveExpression(s.initializer)
@@ -189,6 +274,7 @@ func (r *resolver) visitVarStatement(s stmtVar) interface{} {
 func (r *resolver) visitAssignExpr(e exprAssign) interface{} {
 	r.resolveExpression(e.value)
 	r.resolveLocal(e, e.name)
+	r.checkConstant(e.name)
 	return nil
 }
 
@@ -202,6 +288,34 @@ func (r *resolver) visitSetExpr(e exprSet) interface{} {
 	return nil
 }
 
+func (r *resolver) visitIndexExpr(e exprIndex) interface{} {
+	r.resolveExpression(e.obj)
+	r.resolveExpression(e.index)
+	return nil
+}
+
+func (r *resolver) visitIndexSetExpr(e exprIndexSet) interface{} {
+	r.resolveExpression(e.obj)
+	r.resolveExpression(e.index)
+	r.resolveExpression(e.value)
+	return nil
+}
+
+func (r *resolver) visitListExpr(e exprList) interface{} {
+	for _, el := range e.elements {
+		r.resolveExpression(el)
+	}
+	return nil
+}
+
+func (r *resolver) visitMapExpr(e exprMap) interface{} {
+	for k := range e.keys {
+		r.resolveExpression(e.keys[k])
+		r.resolveExpression(e.values[k])
+	}
+	return nil
+}
+
 func (r *resolver) visitThisExpr(e exprThis) interface{} {
 	if r.currentClass == classTypeNone {
 		reportResolutionError(e.name, "Cannot use 'this' outside of a class.")
@@ -221,6 +335,11 @@ func (r *resolver) visitSuperExpr(e exprSuper) interface{} {
 	return nil
 }
 
+func (r *resolver) visitSpawnExpr(e exprSpawn) interface{} {
+	r.resolveExpression(e.call)
+	return nil
+}
+
 func (r *resolver) visitVariableExpr(e exprVariable) interface{} {
 	if !r.isScopeEmpty() {
 		defined, ok := r.peekScope()[e.name.lexeme]
@@ -229,11 +348,41 @@ func (r *resolver) visitVariableExpr(e exprVariable) interface{} {
 		}
 	}
 
-	r.resolveLocal(e, e.name)
+	if !r.resolveLocal(e, e.name) {
+		r.checkCapability(e.name)
+	}
 	return nil
 }
 
+// checkCapability rejects a reference to a global that names a native whose
+// capability has not been granted, unless the script defines that global.
+func (r *resolver) checkCapability(name token) {
+	if _, ok := r.globals[name.lexeme]; ok {
+		return
+	}
+	if n, ok := natives[name.lexeme]; ok && n.capability != "" {
+		r.natives = append(r.natives, name)
+	}
+	if _, ok := r.inter.globals.values[name.lexeme]; ok {
+		return
+	}
+	if c, denied := r.inter.deniedNative(name.lexeme); denied {
+		reportCapabilityError(name, c)
+	}
+}
+
+func reportCapabilityError(name token, capability string) {
+	reportResolutionError(name, fmt.Sprintf(
+		"'%s' requires the '%s' capability, which has not been granted to this script.", name.lexeme, capability))
+}
+
 func (r *resolver) resolveFunctionStmt(s stmtFunction, t functionType) {
+	if s.generator {
+		if t == functionTypeInitializer {
+			reportResolutionError(s.name, "An initializer cannot be a generator.")
+		}
+		t = functionTypeGenerator
+	}
 	enclosing := r.currentFunctionType
 	r.currentFunctionType = t
 	r.beginScope()
@@ -260,10 +409,35 @@ func (r *resolver) resolveExpression(e expr) {
 	e.accept(r)
 }
 
-func (r *resolver) resolveLocal(e expr, name token) {
+// resolveLocal records the scope distance of a local variable reference. It
+// reports whether name was found in an enclosing scope; if not, it refers to
+// a global.
+func (r *resolver) resolveLocal(e expr, name token) bool {
 	for i := len(r.scopes) - 1; i >= 0; i-- {
 		if defined := r.scopes[i][name.lexeme]; defined {
-			r.inter.resolveLocal(e, len(r.scopes)-1-i)
+			r.inter.resolveLocal(name, len(r.scopes)-1-i)
+			if decl, ok := r.declared[i][name.lexeme]; ok && r.refs != nil {
+				r.refs[name] = decl
+			}
+			return true
+		}
+	}
+	if decl, ok := r.globals[name.lexeme]; ok && r.refs != nil {
+		r.refs[name] = decl
+	}
+	return false
+}
+
+// checkConstant rejects an assignment to a local constant. Assignments to
+// global constants are rejected when they run, since a global variable may
+// be declared again as a constant after the assignment has run, and the
+// REPL resolves each input without the globals of the previous ones.
+func (r *resolver) checkConstant(name token) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if defined := r.scopes[i][name.lexeme]; defined {
+			if decl := r.declared[i][name.lexeme]; r.constants[decl] {
+				reportResolutionError(name, constantAssignment(name, decl))
+			}
 			return
 		}
 	}
@@ -286,6 +460,10 @@ func (r *resolver) declare(name token) {
 		reportResolutionError(name, "Variable with this name already declared in this scope.")
 	} else {
 		p[name.lexeme] = false
+		r.declared[len(r.declared)-1][name.lexeme] = name
+		if r.refs != nil {
+			r.refs[name] = name
+		}
 	}
 }
 
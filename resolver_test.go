@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+// TestConstants checks where assigning to and redeclaring a constant fail:
+// at resolution for locals and at run time for globals.
+func TestConstants(t *testing.T) {
+	for _, c := range []struct {
+		name, source, stdout, stderr string
+		status                       int
+	}{
+		{
+			name:   "local assignment",
+			source: "print 1;\n{\n  const x = 1;\n  x = 2;\n}\n",
+			stdout: "[Resolution Error at line 4:3] Cannot assign to constant 'x' declared at line 3:9.\n\n",
+			status: 1,
+		},
+		{
+			name:   "local assignment in a closure",
+			source: "print 1;\n{\n  const x = 1;\n  fun f() { x = 2; }\n}\n",
+			stdout: "[Resolution Error at line 4:13] Cannot assign to constant 'x' declared at line 3:9.\n\n",
+			status: 1,
+		},
+		{
+			name:   "local redeclaration",
+			source: "print 1;\n{\n  const x = 1;\n  var x = 2;\n}\n",
+			stdout: "[Resolution Error at line 4:7] Variable with this name already declared in this scope.\n\n",
+			status: 1,
+		},
+		{
+			name:   "global assignment",
+			source: "const x = 1;\nprint x;\nx = 2;\n",
+			stdout: "1\n",
+			stderr: "[Runtime Error at line 3:1] Cannot assign to constant 'x' declared at line 1:7.\n",
+			status: 1,
+		},
+		{
+			name:   "global assignment in a function",
+			source: "fun f() { x = 2; }\nconst x = 1;\nprint x;\nf();\n",
+			stdout: "1\n",
+			stderr: "[Runtime Error at line 1:11] Cannot assign to constant 'x' declared at line 2:7.\n  at f (script.glox:1)\n  at <script> (script.glox:4)\n",
+			status: 1,
+		},
+		{
+			name:   "global redeclaration",
+			source: "const x = 1;\nprint x;\nvar x = 2;\n",
+			stdout: "1\n",
+			stderr: "[Runtime Error at line 3:5] Cannot redeclare constant 'x' declared at line 1:7.\n",
+			status: 1,
+		},
+		{
+			name:   "global redeclaration as a function",
+			source: "const x = 1;\nprint x;\nfun x() {}\n",
+			stdout: "1\n",
+			stderr: "[Runtime Error at line 3:5] Cannot redeclare constant 'x' declared at line 1:7.\n",
+			status: 1,
+		},
+		{
+			name:   "variable that becomes a constant",
+			source: "var x = 1;\nfun f() { x = 2; }\nf();\nconst x = x + 1;\nprint x;\n",
+			stdout: "3\n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			want := result{stdout: c.stdout, stderr: c.stderr, status: c.status}
+			if r := glox(t, script(t, c.source)); r != want {
+				t.Errorf("got %+v, want %+v", r, want)
+			}
+		})
+	}
+}
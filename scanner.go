@@ -1,19 +1,51 @@
 package main
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// scanner works on the UTF-8 encoded source. start and current are byte
+// offsets into source while line and column count lines and runes so that
+// positions reported to the user are independent of the encoding.
 type scanner struct {
 	source string
+	// file names the script being scanned. It is recorded in every token.
+	file   string
 	tokens []token
 
-	start, current, line int
+	// keepComments makes the scanner record comments in comments instead of
+	// discarding them. They are never part of tokens.
+	keepComments bool
+	comments     []token
+
+	start, current int
+	line, column   int
+
+	startLine, startColumn int
+
+	// err is the error that stopped scanning, if any. quiet keeps it from
+	// being printed.
+	err   *syntaxError
+	quiet bool
 }
 
 func (s *scanner) scanTokens() []token {
+	defer func() {
+		if err := recover(); err != nil {
+			s.err = recoverSyntaxError(err, s.quiet)
+		}
+	}()
+
+	if s.line == 0 {
+		s.line = 1
+	}
 	for !s.isAtEnd() {
 		s.start = s.current
+		s.startLine, s.startColumn = s.line, s.column+1
 		s.scanToken()
 	}
 
@@ -22,6 +54,8 @@ func (s *scanner) scanTokens() []token {
 		lexeme:  "",
 		literal: nil,
 		line:    s.line,
+		column:  s.column + 1,
+		file:    s.file,
 	})
 	return s.tokens
 }
@@ -30,9 +64,19 @@ func (s *scanner) isAtEnd() bool {
 	return s.current >= len(s.source)
 }
 
-func (s *scanner) advance() byte {
-	s.current++
-	return s.source[s.current-1]
+func (s *scanner) advance() rune {
+	c, size := utf8.DecodeRuneInString(s.source[s.current:])
+	if c == utf8.RuneError && size == 1 {
+		reportParserError(s.errorToken(), "invalid UTF-8 encoding")
+	}
+	s.current += size
+	if c == '\n' {
+		s.line++
+		s.column = 0
+	} else {
+		s.column++
+	}
+	return c
 }
 
 func (s *scanner) scanToken() {
@@ -45,8 +89,16 @@ func (s *scanner) scanToken() {
 		s.addToken(tokenTypeLeftBrace, nil)
 	case '}':
 		s.addToken(tokenTypeRightBrace, nil)
+	case '[':
+		s.addToken(tokenTypeLeftBracket, nil)
+	case ']':
+		s.addToken(tokenTypeRightBracket, nil)
 	case ',':
 		s.addToken(tokenTypeComma, nil)
+	case ':':
+		s.addToken(tokenTypeColon, nil)
+	case '?':
+		s.addToken(tokenTypeQuestion, nil)
 	case '.':
 		s.addToken(tokenTypeDot, nil)
 	case '-':
@@ -57,6 +109,14 @@ func (s *scanner) scanToken() {
 		s.addToken(tokenTypeSemicolon, nil)
 	case '*':
 		s.addToken(tokenTypeStar, nil)
+	case '%':
+		s.addToken(tokenTypePercent, nil)
+	case '&':
+		s.addToken(tokenTypeAmpersand, nil)
+	case '|':
+		s.addToken(tokenTypePipe, nil)
+	case '^':
+		s.addToken(tokenTypeCaret, nil)
 	case '!':
 		if s.match('=') {
 			s.addToken(tokenTypeBangEqual, nil)
@@ -72,12 +132,16 @@ func (s *scanner) scanToken() {
 	case '>':
 		if s.match('=') {
 			s.addToken(tokenTypeGreaterEqual, nil)
+		} else if s.match('>') {
+			s.addToken(tokenTypeGreaterGreater, nil)
 		} else {
 			s.addToken(tokenTypeGreater, nil)
 		}
 	case '<':
 		if s.match('=') {
 			s.addToken(tokenTypeLessEqual, nil)
+		} else if s.match('<') {
+			s.addToken(tokenTypeLessLess, nil)
 		} else {
 			s.addToken(tokenTypeLess, nil)
 		}
@@ -86,43 +150,50 @@ func (s *scanner) scanToken() {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			s.addComment()
 		} else if s.match('*') {
-			for s.peek() != '*' && !s.isAtEnd() {
+			for !(s.peek() == '*' && s.peekNext() == '/') && !s.isAtEnd() {
 				s.advance()
 			}
-			if s.isAtEnd() || s.peekNext() != '/' {
-				reportParserError(token{line: s.line}, "unterminated comment")
+			if s.isAtEnd() {
+				reportParserError(s.errorToken(), "unterminated comment")
 				return
 			}
 			s.advance()
 			s.advance()
+			s.addComment()
 		} else {
 			s.addToken(tokenTypeSlash, nil)
 		}
-	case ' ', '\r', '\t':
-	case '\n':
-		s.line++
+	case ' ', '\r', '\t', '\n':
 	case '"':
 		s.parseString()
 	default:
 		if s.isDigit(c) {
-			s.parseNumber()
+			s.parseNumber(c)
 		} else if s.isAlpha(c) {
 			s.parseIdentifier()
 		} else {
-			reportParserError(token{line: s.line}, "unexpected character")
+			t := s.errorToken()
+			t.lexeme = string(c)
+			reportParserError(t, "unexpected character")
 		}
 	}
 }
 
-func (s *scanner) isDigit(c byte) bool {
+func (s *scanner) isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
 
-func (s *scanner) isAlpha(c byte) bool {
-	return (c >= 'a' && c <= 'z') ||
-		(c >= 'A' && c <= 'Z') ||
-		c == '_'
+// isAlpha reports whether c can start an identifier. Any Unicode letter is
+// accepted so that identifiers may be written in scripts other than Latin.
+func (s *scanner) isAlpha(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+// isAlphaNumeric reports whether c can continue an identifier.
+func (s *scanner) isAlphaNumeric(c rune) bool {
+	return s.isAlpha(c) || unicode.IsDigit(c) || unicode.In(c, unicode.Mn, unicode.Mc)
 }
 
 func (s *scanner) parseString() {
@@ -131,7 +202,7 @@ func (s *scanner) parseString() {
 	}
 
 	if s.isAtEnd() {
-		reportParserError(token{line: s.line}, "unterminated string")
+		reportParserError(s.errorToken(), "unterminated string")
 		return
 	}
 
@@ -139,7 +210,20 @@ func (s *scanner) parseString() {
 	s.addToken(tokenTypeString, s.source[s.start+1:s.current-1])
 }
 
-func (s *scanner) parseNumber() {
+// parseNumber scans a number literal whose first digit is first. Literals
+// with a fractional part become float64, all others int64. Integers may
+// also be written in hexadecimal (0x) or binary (0b).
+func (s *scanner) parseNumber(first rune) {
+	if first == '0' && (s.peek() == 'x' || s.peek() == 'X') {
+		s.advance()
+		s.parseInteger(16, s.isHexDigit)
+		return
+	} else if first == '0' && (s.peek() == 'b' || s.peek() == 'B') {
+		s.advance()
+		s.parseInteger(2, func(c rune) bool { return c == '0' || c == '1' })
+		return
+	}
+
 	for s.isDigit(s.peek()) {
 		s.advance()
 	}
@@ -149,18 +233,56 @@ func (s *scanner) parseNumber() {
 		for s.isDigit(s.peek()) {
 			s.advance()
 		}
+	} else {
+		s.parseInteger(10, s.isDigit)
+		return
 	}
 
 	v, err := strconv.ParseFloat(s.source[s.start:s.current], 64)
 	if err != nil {
-		reportParserError(token{line: s.line}, err.Error())
+		reportParserError(s.errorToken(), err.Error())
+		return
+	}
+	s.addToken(tokenTypeNumber, v)
+}
+
+// parseInteger consumes the remaining digits of an integer literal in the
+// given base and adds it as an int64 token.
+func (s *scanner) parseInteger(base int, isDigit func(rune) bool) {
+	for isDigit(s.peek()) {
+		s.advance()
+	}
+	if s.isAlphaNumeric(s.peek()) {
+		reportParserError(s.errorToken(), fmt.Sprintf("invalid digit %q in number literal", s.peek()))
+		return
+	}
+
+	digits := s.source[s.start:s.current]
+	if base != 10 {
+		digits = digits[2:]
+	}
+	if digits == "" {
+		reportParserError(s.errorToken(), "number literal has no digits")
+		return
+	}
+	v, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			reportParserError(s.errorToken(), fmt.Sprintf("integer literal %s overflows int64", s.source[s.start:s.current]))
+			return
+		}
+		reportParserError(s.errorToken(), err.Error())
 		return
 	}
 	s.addToken(tokenTypeNumber, v)
 }
 
+func (s *scanner) isHexDigit(c rune) bool {
+	return s.isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 func (s *scanner) parseIdentifier() {
-	for s.isAlpha(s.peek()) || s.isDigit(s.peek()) {
+	for s.isAlphaNumeric(s.peek()) {
 		s.advance()
 	}
 	tt, ok := literalToKeywordTokenType[s.source[s.start:s.current]]
@@ -170,28 +292,34 @@ func (s *scanner) parseIdentifier() {
 	s.addToken(tt, nil)
 }
 
-func (s *scanner) match(char byte) bool {
+func (s *scanner) match(char rune) bool {
 	if s.isAtEnd() {
 		return false
-	} else if s.source[s.current] != char {
+	} else if s.peek() != char {
 		return false
 	}
-	s.current++
+	s.advance()
 	return true
 }
 
-func (s *scanner) peek() byte {
+func (s *scanner) peek() rune {
 	if s.isAtEnd() {
 		return '\000'
 	}
-	return s.source[s.current]
+	c, _ := utf8.DecodeRuneInString(s.source[s.current:])
+	return c
 }
 
-func (s *scanner) peekNext() byte {
-	s.current++
-	ret := s.peek()
-	s.current--
-	return ret
+func (s *scanner) peekNext() rune {
+	if s.isAtEnd() {
+		return '\000'
+	}
+	_, size := utf8.DecodeRuneInString(s.source[s.current:])
+	if s.current+size >= len(s.source) {
+		return '\000'
+	}
+	c, _ := utf8.DecodeRuneInString(s.source[s.current+size:])
+	return c
 }
 
 func (s *scanner) addToken(tt tokenType, literal interface{}) {
@@ -199,6 +327,27 @@ func (s *scanner) addToken(tt tokenType, literal interface{}) {
 		tt:      tt,
 		lexeme:  s.source[s.start:s.current],
 		literal: literal,
-		line:    s.line,
+		line:    s.startLine,
+		column:  s.startColumn,
+		file:    s.file,
+	})
+}
+
+// addComment records the comment just scanned if comments are kept.
+func (s *scanner) addComment() {
+	if !s.keepComments {
+		return
+	}
+	s.comments = append(s.comments, token{
+		tt:     tokenTypeComment,
+		lexeme: strings.TrimRight(s.source[s.start:s.current], " \t\r"),
+		line:   s.startLine,
+		column: s.startColumn,
+		file:   s.file,
 	})
 }
+
+// errorToken returns a token pointing at the position the scanner is at.
+func (s *scanner) errorToken() token {
+	return token{line: s.line, column: s.column, file: s.file}
+}
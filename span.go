@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// The syntax tree keeps the tokens that delimit each node rather than
+// explicit positions. The functions below recover the first and last token
+// of a node, which is enough to know where it starts and ends.
+
+// exprStart returns the first token of e.
+func exprStart(e expr) token {
+	switch e := e.(type) {
+	case exprBinary:
+		return exprStart(e.left)
+	case exprLogical:
+		return exprStart(e.left)
+	case exprGrouping:
+		return e.open
+	case exprLiteral:
+		return e.token
+	case exprUnary:
+		return e.operator
+	case exprVariable:
+		return e.name
+	case exprAssign:
+		return e.name
+	case exprCall:
+		return exprStart(e.callee)
+	case exprSpawn:
+		return e.keyword
+	case exprGet:
+		return exprStart(e.obj)
+	case exprSet:
+		return exprStart(e.obj)
+	case exprThis:
+		return e.name
+	case exprSuper:
+		return e.keyword
+	case exprIndex:
+		return exprStart(e.obj)
+	case exprIndexSet:
+		return exprStart(e.obj)
+	case exprList:
+		return e.bracket
+	case exprMap:
+		return e.brace
+	}
+	return token{}
+}
+
+// exprEnd returns the last token of e.
+func exprEnd(e expr) token {
+	switch e := e.(type) {
+	case exprBinary:
+		return exprEnd(e.right)
+	case exprLogical:
+		return exprEnd(e.right)
+	case exprGrouping:
+		return e.close
+	case exprLiteral:
+		return e.token
+	case exprUnary:
+		return exprEnd(e.right)
+	case exprVariable:
+		return e.name
+	case exprAssign:
+		return exprEnd(e.value)
+	case exprCall:
+		return e.paren
+	case exprSpawn:
+		return e.call.paren
+	case exprGet:
+		return e.name
+	case exprSet:
+		return exprEnd(e.value)
+	case exprThis:
+		return e.name
+	case exprSuper:
+		return e.method
+	case exprIndex:
+		return e.bracket
+	case exprIndexSet:
+		return exprEnd(e.value)
+	case exprList:
+		return e.close
+	case exprMap:
+		return e.close
+	}
+	return token{}
+}
+
+// stmtStart returns the first token of s.
+func stmtStart(s stmt) token {
+	switch s := s.(type) {
+	case stmtExpression:
+		return exprStart(s.e)
+	case stmtPrint:
+		return s.keyword
+	case stmtVar:
+		return s.keyword
+	case stmtBlock:
+		return s.open
+	case stmtIf:
+		return s.keyword
+	case stmtWhile:
+		return s.keyword
+	case stmtFor:
+		return s.keyword
+	case stmtForIn:
+		return s.keyword
+	case stmtYield:
+		return s.keyword
+	case stmtFunction:
+		if s.keyword.tt == tokenTypeFun {
+			return s.keyword
+		}
+		return s.name
+	case stmtReturn:
+		return s.keyword
+	case stmtClass:
+		return s.keyword
+	}
+	return token{}
+}
+
+// stmtEnd returns the last token of s, not counting a final semicolon.
+func stmtEnd(s stmt) token {
+	switch s := s.(type) {
+	case stmtExpression:
+		return exprEnd(s.e)
+	case stmtPrint:
+		return exprEnd(s.e)
+	case stmtVar:
+		if s.initializer != nil {
+			return exprEnd(s.initializer)
+		}
+		return s.name
+	case stmtBlock:
+		return s.close
+	case stmtIf:
+		if s.elseBranch != nil {
+			return stmtEnd(s.elseBranch)
+		}
+		return stmtEnd(s.thenBranch)
+	case stmtWhile:
+		return stmtEnd(s.body)
+	case stmtFor:
+		return stmtEnd(s.body)
+	case stmtForIn:
+		return stmtEnd(s.body)
+	case stmtYield:
+		if s.value != nil {
+			return exprEnd(s.value)
+		}
+		return s.keyword
+	case stmtFunction:
+		return s.body.close
+	case stmtReturn:
+		if s.value != nil {
+			return exprEnd(s.value)
+		}
+		return s.keyword
+	case stmtClass:
+		return s.close
+	}
+	return token{}
+}
+
+// tokenEnd returns the line and column just past the end of t. Tokens such
+// as strings and block comments may span several lines.
+func tokenEnd(t token) (line, column int) {
+	if n := strings.LastIndexByte(t.lexeme, '\n'); n >= 0 {
+		return t.line + strings.Count(t.lexeme, "\n"), utf8.RuneCountInString(t.lexeme[n+1:]) + 1
+	}
+	return t.line, t.column + utf8.RuneCountInString(t.lexeme)
+}
+
+// lineLabel names a source line as file:line, or as line n in the REPL.
+func lineLabel(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
@@ -11,13 +11,18 @@ type stmtVisitor interface {
 	visitBlockStatement(s stmtBlock) interface{}
 	visitIfStatement(s stmtIf) interface{}
 	visitWhileStatement(s stmtWhile) interface{}
+	visitForStatement(s stmtFor) interface{}
 	visitFunctionStatement(s stmtFunction) interface{}
 	visitReturnStatement(s stmtReturn) interface{}
 	visitClassStatement(s stmtClass) interface{}
+	visitForInStatement(s stmtForIn) interface{}
+	visitYieldStatement(s stmtYield) interface{}
 }
 
 type stmtExpression struct {
 	e expr
+	// echo is set for expressions entered in the REPL, whose value is printed.
+	echo bool
 }
 
 func (s stmtExpression) accept(v stmtVisitor) interface{} {
@@ -25,7 +30,8 @@ func (s stmtExpression) accept(v stmtVisitor) interface{} {
 }
 
 type stmtPrint struct {
-	e expr
+	keyword token
+	e       expr
 }
 
 var _ stmt = stmtPrint{}
@@ -34,9 +40,16 @@ func (s stmtPrint) accept(v stmtVisitor) interface{} {
 	return v.visitPrintStatement(s)
 }
 
+// stmtVar declares a variable, or a constant if keyword is const. typ is
+// its type annotation, or nil.
 type stmtVar struct {
-	name        token
-	initializer expr
+	keyword, name token
+	typ           *typeAnnotation
+	initializer   expr
+}
+
+func (s stmtVar) constant() bool {
+	return s.keyword.tt == tokenTypeConst
 }
 
 func (s stmtVar) accept(v stmtVisitor) interface{} {
@@ -44,7 +57,8 @@ func (s stmtVar) accept(v stmtVisitor) interface{} {
 }
 
 type stmtBlock struct {
-	statements []stmt
+	statements  []stmt
+	open, close token
 }
 
 func (s stmtBlock) accept(v stmtVisitor) interface{} {
@@ -52,6 +66,7 @@ func (s stmtBlock) accept(v stmtVisitor) interface{} {
 }
 
 type stmtIf struct {
+	keyword                token
 	condition              expr
 	thenBranch, elseBranch stmt
 }
@@ -61,6 +76,7 @@ func (s stmtIf) accept(v stmtVisitor) interface{} {
 }
 
 type stmtWhile struct {
+	keyword   token
 	condition expr
 	body      stmt
 }
@@ -69,10 +85,46 @@ func (s stmtWhile) accept(v stmtVisitor) interface{} {
 	return v.visitWhileStatement(s)
 }
 
+// stmtFor is a for loop. Any of initializer, condition and increment may be
+// nil. The initializer is scoped to the loop.
+type stmtFor struct {
+	keyword     token
+	initializer stmt
+	condition   expr
+	increment   expr
+	body        stmt
+}
+
+func (s stmtFor) accept(v stmtVisitor) interface{} {
+	return v.visitForStatement(s)
+}
+
+// stmtForIn runs its body once for each value of iterable, with name bound
+// to a fresh variable holding the value.
+type stmtForIn struct {
+	keyword  token
+	name     token
+	iterable expr
+	body     stmt
+}
+
+func (s stmtForIn) accept(v stmtVisitor) interface{} {
+	return v.visitForInStatement(s)
+}
+
+// stmtFunction declares a function or a method. paramTypes holds the type
+// annotation of each parameter and returnType that of the result; any of
+// them may be nil. Calling a generator returns an iterator over the values
+// its body yields. keyword is the fun keyword, which a method does not
+// have.
 type stmtFunction struct {
-	params []token
-	body   stmtBlock
-	name   token
+	keyword    token
+	params     []token
+	paramTypes []*typeAnnotation
+	returnType *typeAnnotation
+	body       stmtBlock
+	name       token
+	generator  bool
 }
 
 func (s stmtFunction) accept(v stmtVisitor) interface{} {
@@ -88,12 +140,44 @@ func (s stmtReturn) accept(v stmtVisitor) interface{} {
 	return v.visitReturnStatement(s)
 }
 
+// stmtYield hands a value, or nil if there is none, to the caller of the
+// next method of the generator's iterator.
+type stmtYield struct {
+	keyword token
+	value   expr
+}
+
+func (s stmtYield) accept(v stmtVisitor) interface{} {
+	return v.visitYieldStatement(s)
+}
+
 type stmtClass struct {
+	keyword    token
 	methods    []stmtFunction
 	name       token
 	superClass *exprVariable
+	// close is the brace that ends the class body.
+	close token
 }
 
 func (s stmtClass) accept(v stmtVisitor) interface{} {
 	return v.visitClassStatement(s)
 }
+
+// typeAnnotation is a type written after a name, as in var x: number. It is
+// checked by glox check and ignored when the script runs. The type is a
+// class name or one of the names of builtinTypes, and a trailing question
+// mark allows nil too.
+type typeAnnotation struct {
+	name     token
+	nullable bool
+	// end is the last token of the annotation.
+	end token
+}
+
+func (t *typeAnnotation) String() string {
+	if t.nullable {
+		return t.name.lexeme + "?"
+	}
+	return t.name.lexeme
+}
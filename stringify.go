@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stringify returns the canonical textual representation of a Lox value.
+// It is used by print, by the str() native and by the REPL.
+func stringify(v interface{}) string {
+	var b strings.Builder
+	writeValue(&b, v, false, map[interface{}]bool{})
+	return b.String()
+}
+
+// writeValue writes v to b. Strings nested in lists and maps are quoted so
+// that ["1"] and [1] can be told apart. seen guards against collections that
+// contain themselves.
+func writeValue(b *strings.Builder, v interface{}, quote bool, seen map[interface{}]bool) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("nil")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(formatFloat(v))
+	case string:
+		if quote {
+			b.WriteString(strconv.Quote(v))
+		} else {
+			b.WriteString(v)
+		}
+	case *loxList:
+		if seen[v] {
+			b.WriteString("[...]")
+			return
+		}
+		seen[v] = true
+		b.WriteByte('[')
+		for i, e := range v.elements {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeValue(b, e, true, seen)
+		}
+		b.WriteByte(']')
+		delete(seen, v)
+	case *loxMap:
+		if seen[v] {
+			b.WriteString("{...}")
+			return
+		}
+		seen[v] = true
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeValue(b, k, true, seen)
+			b.WriteString(": ")
+			writeValue(b, v.entries[k], true, seen)
+		}
+		b.WriteByte('}')
+		delete(seen, v)
+	case loxFunction:
+		b.WriteString("<fn " + v.declaration.name.lexeme + ">")
+	case nativeFunction:
+		b.WriteString("<native fn " + v.name + ">")
+	case loxClass:
+		b.WriteString("<class " + v.name + ">")
+	case loxInstance:
+		b.WriteString(v.klass.name + " instance")
+	case *loxTask:
+		b.WriteString("<task " + v.name + ">")
+	case *loxChannel:
+		b.WriteString("<channel>")
+	case *loxIterator:
+		b.WriteString("<iterator " + v.name + ">")
+	default:
+		b.WriteString("<unknown>")
+	}
+}
+
+// formatFloat prints whole floats without a fractional part, as Lox does,
+// and avoids exponents for numbers of a reasonable magnitude.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
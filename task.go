@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Scripts run concurrently with spawn: "spawn f(x)" calls f on a goroutine
+// of its own and evaluates to a task, whose result await(task) waits for.
+// Tasks talk over channels. Only one goroutine runs Lox code at a time: the
+// one holding the interpreter's lock, which it releases while it waits for
+// a channel, a task or a host call, and every yieldSteps steps. Values
+// shared between tasks therefore need no locking of their own.
+
+// yieldSteps is how many steps a goroutine takes before it lets the others
+// run, while there are tasks.
+const yieldSteps = 1000
+
+// loxTask is a call running on a goroutine of its own.
+type loxTask struct {
+	name string
+	// finished is set when the call ends, with its result in value or what
+	// aborted it in err. awaiters are the goroutines waiting for that, and
+	// awaited is set once anything has.
+	finished bool
+	value    interface{}
+	err      interface{}
+	awaiters []*waiter
+	awaited  bool
+}
+
+// loxChannel passes values between tasks. Its buffer holds up to capacity
+// values; receivers and senders are the goroutines waiting on it, in the
+// order they started to.
+type loxChannel struct {
+	capacity  int
+	buffer    []interface{}
+	closed    bool
+	receivers []*waiter
+	senders   []*waiter
+}
+
+// waiter is a goroutine waiting in wait for the first of its cases that
+// can proceed. Whoever lets one proceed fires the waiter, which records
+// the outcome and wakes it.
+type waiter struct {
+	cases []waitCase
+	wake  chan struct{}
+	fired bool
+	// chosen is the case that proceeded, with the value it received and
+	// whether it received one. sentOnClosed is set if the case was a send
+	// on a channel that was closed, deadlock if no case ever can proceed.
+	chosen       int
+	value        interface{}
+	ok           bool
+	sentOnClosed bool
+	deadlock     bool
+}
+
+// waitCase is a receive from or a send on a channel, or the end of a task.
+type waitCase struct {
+	ch    *loxChannel
+	send  bool
+	value interface{}
+	task  *loxTask
+}
+
+func (i *interpreter) visitSpawnExpr(e exprSpawn) interface{} {
+	i.step(e.call.paren)
+	callee := i.evaluate(e.call.callee)
+
+	var args []interface{}
+	for _, a := range e.call.args {
+		args = append(args, i.evaluate(a))
+	}
+
+	f, ok := callee.(callable)
+	if !ok {
+		reportRuntimeError(e.call.paren, "Can only spawn functions and classes.")
+	} else if len(args) != f.arity() {
+		reportRuntimeError(e.call.paren, fmt.Sprintf("Expected %d arguments but got %d.",
+			f.arity(), len(args)))
+	}
+
+	i.allocate(e.keyword)
+	t := &loxTask{name: callableName(f)}
+	task := &interpreter{shared: i.shared, env: i.globals, hooks: i.hooks}
+	i.tasks++
+	go task.run(t, f, e.call.paren, args)
+	return t
+}
+
+// callableName is the name of a function, native or class.
+func callableName(f callable) string {
+	switch f := f.(type) {
+	case loxFunction:
+		return f.declaration.name.lexeme
+	case nativeFunction:
+		return f.name
+	case loxClass:
+		return f.name
+	}
+	return "?"
+}
+
+// run calls f on the goroutine of a task.
+func (i *interpreter) run(t *loxTask, f callable, paren token, args []interface{}) {
+	i.lock.Lock()
+	failed := hadRuntimeError
+	defer func() {
+		if r := recover(); r != nil {
+			// The error is await's to report, or interpret's if nothing
+			// awaits the task.
+			hadRuntimeError = failed
+			t.err = r
+			i.failures = append(i.failures, t)
+		}
+		i.tasks--
+		t.finished = true
+		for len(t.awaiters) > 0 {
+			w := t.awaiters[0]
+			i.fire(w, w.caseOf(nil, t), nil, true)
+		}
+		i.checkDeadlock()
+		i.lock.Unlock()
+	}()
+	t.value = f.call(i, paren, args)
+}
+
+// yield lets other goroutines run Lox code.
+func (i *interpreter) yield() {
+	i.lock.Unlock()
+	runtime.Gosched()
+	i.lock.Lock()
+}
+
+// unlocked calls fn, which must not touch the interpreter, with the lock
+// released.
+func (i *interpreter) unlocked(fn func()) {
+	i.lock.Unlock()
+	defer i.lock.Lock()
+	fn()
+}
+
+// wait blocks until one of cases can proceed, with the lock released, and
+// returns the index of that case, the value it received and whether it
+// received one. It aborts execution if the interpreter's context is done
+// first, and fails if every goroutine running Lox code is waiting, since
+// then none of them could ever proceed. t is where a failure is reported.
+func (i *interpreter) wait(t token, cases ...waitCase) (chosen int, value interface{}, ok bool) {
+	w := &waiter{cases: cases}
+	for k := range cases {
+		if i.proceed(w, k) {
+			return w.result(t)
+		}
+	}
+	w.wake = make(chan struct{}, 1)
+	for _, c := range cases {
+		switch {
+		case c.task != nil:
+			c.task.awaiters = append(c.task.awaiters, w)
+		case c.send:
+			c.ch.senders = append(c.ch.senders, w)
+		default:
+			c.ch.receivers = append(c.ch.receivers, w)
+		}
+	}
+	i.waiting = append(i.waiting, w)
+	i.checkDeadlock()
+	i.unlocked(func() {
+		select {
+		case <-w.wake:
+		case <-i.done:
+		}
+	})
+	if !w.fired {
+		i.unpark(w)
+		i.abort(t)
+	}
+	return w.result(t)
+}
+
+// result returns the outcome of a fired waiter, failing at t if there is
+// none.
+func (w *waiter) result(t token) (chosen int, value interface{}, ok bool) {
+	switch {
+	case w.sentOnClosed:
+		reportRuntimeError(t, "Send on a closed channel.")
+	case w.deadlock:
+		reportRuntimeError(t, "Deadlock: no task is left that could unblock this.")
+	}
+	return w.chosen, w.value, w.ok
+}
+
+// proceed lets the case k of a waiter that has not parked yet proceed if
+// it can right away, and reports whether it did.
+func (i *interpreter) proceed(w *waiter, k int) bool {
+	c := w.cases[k]
+	w.chosen = k
+	switch {
+	case c.task != nil:
+		if !c.task.finished {
+			return false
+		}
+		w.ok = true
+	case c.send:
+		ch := c.ch
+		if ch.closed {
+			w.sentOnClosed = true
+		} else if len(ch.receivers) > 0 {
+			r := ch.receivers[0]
+			i.fire(r, r.caseOf(ch, nil), c.value, true)
+		} else if len(ch.buffer) < ch.capacity {
+			ch.buffer = append(ch.buffer, c.value)
+		} else {
+			return false
+		}
+	default:
+		ch := c.ch
+		if len(ch.buffer) > 0 {
+			w.value, w.ok = ch.buffer[0], true
+			ch.buffer = ch.buffer[1:]
+			if len(ch.senders) > 0 {
+				s := ch.senders[0]
+				k := s.caseOf(ch, nil)
+				ch.buffer = append(ch.buffer, s.cases[k].value)
+				i.fire(s, k, nil, false)
+			}
+		} else if len(ch.senders) > 0 {
+			s := ch.senders[0]
+			k := s.caseOf(ch, nil)
+			w.value, w.ok = s.cases[k].value, true
+			i.fire(s, k, nil, false)
+		} else if !ch.closed {
+			return false
+		}
+	}
+	w.fired = true
+	return true
+}
+
+// caseOf returns the index of the case of w that waits on ch or task.
+func (w *waiter) caseOf(ch *loxChannel, task *loxTask) int {
+	for k, c := range w.cases {
+		if c.ch == ch && c.task == task {
+			return k
+		}
+	}
+	panic("waiter does not wait on this")
+}
+
+// fire lets the case chosen of a parked waiter proceed with the value it
+// received, and wakes it.
+func (i *interpreter) fire(w *waiter, chosen int, value interface{}, ok bool) {
+	i.unpark(w)
+	w.fired, w.chosen, w.value, w.ok = true, chosen, value, ok
+	w.wake <- struct{}{}
+}
+
+// unpark removes a waiter from everything it waits on.
+func (i *interpreter) unpark(w *waiter) {
+	for _, c := range w.cases {
+		switch {
+		case c.task != nil:
+			c.task.awaiters = without(c.task.awaiters, w)
+		case c.send:
+			c.ch.senders = without(c.ch.senders, w)
+		default:
+			c.ch.receivers = without(c.ch.receivers, w)
+		}
+	}
+	i.waiting = without(i.waiting, w)
+}
+
+func without(ws []*waiter, w *waiter) []*waiter {
+	for k := range ws {
+		if ws[k] == w {
+			return append(ws[:k:k], ws[k+1:]...)
+		}
+	}
+	return ws
+}
+
+// checkDeadlock fails the last goroutine that started to wait if every
+// goroutine running Lox code waits: the main body, while interpret runs
+// it, and the tasks. Goroutines that sleep or read are not waiting, since
+// they go on by themselves. It is called whenever a goroutine starts to
+// wait or a task ends, and also wakes settle.
+func (i *interpreter) checkDeadlock() {
+	select {
+	case i.settled <- struct{}{}:
+	default:
+	}
+	if !i.interpreting || len(i.waiting) == 0 || len(i.waiting) < i.tasks+1 {
+		return
+	}
+	w := i.waiting[len(i.waiting)-1]
+	w.deadlock = true
+	i.fire(w, 0, nil, false)
+}
+
+// settle runs the tasks once the main body has ended, until each has ended
+// or waits. It aborts execution if the interpreter's context is done first.
+func (i *interpreter) settle() {
+	i.interpreting = false
+	for len(i.waiting) < i.tasks {
+		aborted := false
+		i.unlocked(func() {
+			select {
+			case <-i.settled:
+			case <-i.done:
+				aborted = true
+			}
+		})
+		if aborted {
+			i.abort(token{})
+		}
+	}
+}
+
+// reportFailures prints the errors of the tasks that failed without being
+// awaited, and returns the first unless err is already set. A task that
+// called exit exits the script.
+func (i *interpreter) reportFailures(err error) error {
+	for _, t := range i.failures {
+		if t.awaited {
+			continue
+		}
+		e, ok := t.err.(error)
+		if !ok {
+			e = fmt.Errorf("%v", t.err)
+		}
+		if _, exit := e.(*exitStatus); !exit {
+			hadRuntimeError = true
+			fmt.Fprintln(i.diagnostics, e)
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	i.failures = nil
+	return err
+}
+
+// nativeAwait waits for a task to end and returns its result. An error that
+// aborted the task is raised again.
+func nativeAwait(i *interpreter, paren token, args []interface{}) interface{} {
+	t, ok := args[0].(*loxTask)
+	if !ok {
+		reportRuntimeError(paren, "await() expects a task.")
+	}
+	t.awaited = true
+	i.wait(paren, waitCase{task: t})
+	switch err := t.err.(type) {
+	case nil:
+		return t.value
+	case *runtimeError:
+		reportRuntimeError(paren, fmt.Sprintf("Task %s failed at line %d:%d: %s",
+			t.name, err.token.line, err.token.column, err.message))
+	case *limitError:
+		hadRuntimeError = true
+	}
+	panic(t.err)
+}
+
+// nativeChannel returns a channel that buffers up to capacity values.
+func nativeChannel(i *interpreter, paren token, args []interface{}) interface{} {
+	n, ok := args[0].(int64)
+	if !ok || n < 0 {
+		reportRuntimeError(paren, "channel() expects a non-negative integer capacity.")
+	}
+	i.checkCollectionSize(paren, int(n))
+	i.allocate(paren)
+	return &loxChannel{capacity: int(n)}
+}
+
+func channelArg(paren token, v interface{}, native string) *loxChannel {
+	ch, ok := v.(*loxChannel)
+	if !ok {
+		reportRuntimeError(paren, native+"() expects a channel.")
+	}
+	return ch
+}
+
+// nativeSend sends a value on a channel, waiting for room in its buffer or
+// for a receiver.
+func nativeSend(i *interpreter, paren token, args []interface{}) interface{} {
+	ch := channelArg(paren, args[0], "send")
+	if ch.closed {
+		reportRuntimeError(paren, "Send on a closed channel.")
+	}
+	i.wait(paren, waitCase{ch: ch, send: true, value: args[1]})
+	return nil
+}
+
+// nativeRecv receives a value from a channel, waiting for one to be sent.
+// It returns nil once the channel is closed and empty.
+func nativeRecv(i *interpreter, paren token, args []interface{}) interface{} {
+	ch := channelArg(paren, args[0], "recv")
+	_, v, _ := i.wait(paren, waitCase{ch: ch})
+	return v
+}
+
+// nativeClose closes a channel. Receivers get the values still buffered,
+// then nil.
+func nativeClose(i *interpreter, paren token, args []interface{}) interface{} {
+	ch := channelArg(paren, args[0], "close")
+	if ch.closed {
+		reportRuntimeError(paren, "Channel is already closed.")
+	}
+	ch.closed = true
+	for len(ch.receivers) > 0 {
+		r := ch.receivers[0]
+		i.fire(r, r.caseOf(ch, nil), nil, false)
+	}
+	for len(ch.senders) > 0 {
+		s := ch.senders[0]
+		s.sentOnClosed = true
+		i.fire(s, s.caseOf(ch, nil), nil, false)
+	}
+	return nil
+}
+
+// nativeSelect waits until one of a list of channels has a value or is
+// closed, and returns a list of that channel and the value it received,
+// which is nil if it was closed.
+func nativeSelect(i *interpreter, paren token, args []interface{}) interface{} {
+	l, ok := args[0].(*loxList)
+	if !ok || len(l.elements) == 0 {
+		reportRuntimeError(paren, "select() expects a list of channels.")
+	}
+	cases := make([]waitCase, len(l.elements))
+	for k, e := range l.elements {
+		ch, ok := e.(*loxChannel)
+		if !ok {
+			reportRuntimeError(paren, "select() expects a list of channels.")
+		}
+		cases[k] = waitCase{ch: ch}
+	}
+	chosen, value, _ := i.wait(paren, cases...)
+	i.allocate(paren)
+	return &loxList{elements: []interface{}{l.elements[chosen], value}}
+}
+
+// nativeSleep waits for a number of seconds, letting other tasks run.
+func nativeSleep(i *interpreter, paren token, args []interface{}) interface{} {
+	seconds, ok := toFloat(args[0])
+	if !ok || seconds < 0 {
+		reportRuntimeError(paren, "sleep() expects a non-negative number of seconds.")
+	}
+	timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+	defer timer.Stop()
+	aborted := false
+	i.unlocked(func() {
+		select {
+		case <-timer.C:
+		case <-i.done:
+			aborted = true
+		}
+	})
+	if aborted {
+		i.abort(paren)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// runSource runs a script in a fresh interpreter and returns what it
+// printed and the error that stopped it.
+func runSource(source string) (string, error) {
+	hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	var out, diag bytes.Buffer
+	err := run(newInterpreter(withOutput(&out), withDiagnostics(&diag)), "test.glox", source, false)
+	return out.String(), err
+}
+
+func TestDeadlock(t *testing.T) {
+	for _, tc := range []struct {
+		name, source, output string
+	}{
+		{"main alone", "recv(channel(0));", ""},
+		{"task ended", "var c = channel(0); fun f() { sleep(0.01); } spawn f(); recv(c);", ""},
+		{"all waiting", "var c = channel(0); fun f() { recv(c); } await(spawn f());", ""},
+		{"after work", `var c = channel(0);
+fun f() { send(c, 1); send(c, 2); }
+spawn f();
+print recv(c); print recv(c); recv(c);`, "1\n2\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := runSource(tc.source)
+			if err == nil || !strings.Contains(err.Error(), "Deadlock") {
+				t.Errorf("got error %v, want a deadlock", err)
+			}
+			if out != tc.output {
+				t.Errorf("got output %q, want %q", out, tc.output)
+			}
+		})
+	}
+}
+
+func TestChannels(t *testing.T) {
+	source := `var jobs = channel(0);
+var results = channel(2);
+fun worker() {
+  var j = recv(jobs);
+  while (j != nil) { send(results, j * 2); j = recv(jobs); }
+}
+var ts = [spawn worker(), spawn worker(), spawn worker()];
+fun feed() { for (var k = 0; k < 100; k = k + 1) send(jobs, k); close(jobs); }
+spawn feed();
+var sum = 0;
+for (var k = 0; k < 100; k = k + 1) sum = sum + recv(results);
+for (t in ts) await(t);
+print sum;
+var a = channel(1);
+var b = channel(1);
+send(b, "b");
+print select([a, b])[1];
+close(a);
+print recv(a);
+`
+	out, err := runSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "9900\nb\nnil\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestUnawaitedTaskFails(t *testing.T) {
+	out, err := runSource("fun bad() { return 1 + nil; }\nspawn bad();\nprint \"done\";\n")
+	if out != "done\n" {
+		t.Errorf("got output %q, want done", out)
+	}
+	if e, ok := err.(*runtimeError); !ok || e.token.line != 1 || !hadRuntimeError {
+		t.Errorf("got error %v, want the error of bad", err)
+	}
+
+	// An awaited failure is await's to report.
+	_, err = runSource("fun bad() { return 1 + nil; }\nvar t = spawn bad();\nsleep(0.01);\nawait(t);\n")
+	if e, ok := err.(*runtimeError); !ok || e.token.line != 4 {
+		t.Errorf("got error %v, want the error of await", err)
+	}
+}
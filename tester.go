@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// testFileSuffix ends the names of the scripts glox test runs.
+const testFileSuffix = "_test.glox"
+
+// isTestFunction reports whether a top-level function of a test file is a
+// test.
+func isTestFunction(name token) bool {
+	return strings.HasSuffix(name.file, testFileSuffix) && strings.HasPrefix(name.lexeme, "test")
+}
+
+func testCommand(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "print the name and output of every test")
+	run := fs.String("run", "", "only run the tests whose name matches this regular expression")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox test [-v] [-run regexp] [path ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	re, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := scriptFiles(paths, testFileSuffix)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(files) == 0 {
+		fmt.Println("no test files")
+		return 0
+	}
+
+	passed, failed := 0, 0
+	for _, name := range files {
+		bs, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		p, f := runTests(os.Stdout, name, string(bs), re, *verbose)
+		passed += p
+		failed += f
+	}
+	if failed > 0 {
+		fmt.Printf("FAIL: %s passed, %d failed\n", countOf(passed, "test"), failed)
+		return 1
+	}
+	fmt.Printf("ok: %s passed\n", countOf(passed, "test"))
+	return 0
+}
+
+// runTests runs the tests of the script read from path whose name matches
+// re, reporting to out, and returns how many passed and failed. A script
+// that does not compile counts as one failure.
+func runTests(out io.Writer, path, source string, re *regexp.Regexp, verbose bool) (passed, failed int) {
+	defer func() {
+		hadParserError, hadResolutionError, hadRuntimeError = false, false, false
+	}()
+	file := filepath.Base(path)
+	sc := &scanner{source: source, file: file, quiet: true}
+	ts := sc.scanTokens()
+	err := sc.err
+	var ss []stmt
+	if err == nil {
+		p := &parser{tokens: ts, quiet: true}
+		ss = p.parse()
+		err = p.err
+	}
+	if err == nil {
+		r := &resolver{inter: newInterpreter(), quiet: true}
+		r.resolve(ss)
+		err = r.err
+	}
+	if err != nil {
+		fmt.Fprintf(out, "FAIL\t%s\n    %s\n", path, strings.TrimSpace(err.Error()))
+		return 0, 1
+	}
+
+	for _, s := range ss {
+		f, ok := s.(stmtFunction)
+		if !ok || !isTestFunction(f.name) || !re.MatchString(f.name.lexeme) {
+			continue
+		}
+		if verbose {
+			fmt.Fprintf(out, "=== RUN   %s\n", f.name.lexeme)
+		}
+		start := time.Now()
+		output, err := runTest(ss, f)
+		elapsed := time.Since(start).Seconds()
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "--- FAIL: %s (%.2fs)\n", f.name.lexeme, elapsed)
+			fmt.Fprint(out, indent(testFailure(err)+"\n"+output))
+			continue
+		}
+		passed++
+		if verbose {
+			fmt.Fprintf(out, "--- PASS: %s (%.2fs)\n", f.name.lexeme, elapsed)
+			fmt.Fprint(out, indent(output))
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(out, "FAIL\t%s\t%d of %s failed\n", path, failed, countOf(passed+failed, "test"))
+	} else {
+		fmt.Fprintf(out, "ok  \t%s\t%s passed\n", path, countOf(passed, "test"))
+	}
+	return passed, failed
+}
+
+// runTest runs a script in a fresh interpreter and then calls the test
+// function declared in it. It returns what the script printed and the
+// error that failed the test, if any.
+func runTest(ss []stmt, test stmtFunction) (string, error) {
+	if len(test.params) > 0 {
+		return "", &runtimeError{token: test.name, message: "Test functions take no parameters."}
+	}
+	var out bytes.Buffer
+	it := newInterpreter(append(interpreterOptions(), withOutput(&out), withDiagnostics(ioutil.Discard), withAssertions())...)
+	(&resolver{inter: it, quiet: true}).resolve(ss)
+	if it.optimize {
+		ss = optimize(it, ss)
+	}
+	if err := it.interpret(ss); err != nil {
+		return out.String(), err
+	}
+	call := exprCall{callee: exprVariable{name: test.name}, paren: test.name}
+	err := it.interpret([]stmt{stmtExpression{e: call}})
+	return out.String(), err
+}
+
+// testFailure describes the error that failed a test, starting with where
+// it happened.
+func testFailure(err error) string {
+	switch err := err.(type) {
+	case *runtimeError:
+		return fmt.Sprintf("%s:%d:%d: %s%s", err.token.file, err.token.line, err.token.column,
+			err.message, formatTrace(err.trace))
+	case *exitStatus:
+		return fmt.Sprintf("the script called exit(%d)", err.code)
+	}
+	return err.Error()
+}
+
+// indent indents every line of s.
+func indent(s string) string {
+	var b strings.Builder
+	for _, l := range strings.SplitAfter(s, "\n") {
+		if strings.TrimSpace(l) != "" {
+			b.WriteString("    " + l)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAssertionsOnlyInTests(t *testing.T) {
+	source := "fun testEqual() { assertEqual([1, 2], [1, 2]); }\nfun testFails() { assert(false); }\n"
+	var out bytes.Buffer
+	passed, failed := runTests(&out, "a_test.glox", source, regexp.MustCompile(""), false)
+	if passed != 1 || failed != 1 || !strings.Contains(out.String(), "Assertion failed.") {
+		t.Errorf("got %d passed and %d failed:\n%s", passed, failed, out.String())
+	}
+
+	it := newInterpreter(withOutput(ioutil.Discard), withDiagnostics(ioutil.Discard))
+	if err := run(it, "script.glox", "assert(true);", false); err == nil {
+		t.Error("assert is defined outside tests")
+	}
+	if _, ok := analyze("script.glox", "").visibleNames(1, 1)["assertEqual"]; ok {
+		t.Error("assertEqual is offered outside tests")
+	}
+	if _, ok := analyze("a_test.glox", "").visibleNames(1, 1)["assertEqual"]; !ok {
+		t.Error("assertEqual is not offered in tests")
+	}
+}
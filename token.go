@@ -10,13 +10,21 @@ const (
 	tokenTypeRightParen
 	tokenTypeLeftBrace
 	tokenTypeRightBrace
+	tokenTypeLeftBracket
+	tokenTypeRightBracket
 	tokenTypeComma
+	tokenTypeColon
 	tokenTypeDot
 	tokenTypeMinus
 	tokenTypePlus
 	tokenTypeSemicolon
 	tokenTypeSlash
 	tokenTypeStar
+	tokenTypePercent
+	tokenTypeAmpersand
+	tokenTypePipe
+	tokenTypeCaret
+	tokenTypeQuestion
 
 	// one or two chars
 	tokenTypeBang
@@ -27,6 +35,8 @@ const (
 	tokenTypeGreaterEqual
 	tokenTypeLess
 	tokenTypeLessEqual
+	tokenTypeLessLess
+	tokenTypeGreaterGreater
 
 	// literals
 	tokenTypeIdentifier
@@ -36,6 +46,7 @@ const (
 	// keywords
 	tokenTypeAnd
 	tokenTypeClass
+	tokenTypeConst
 	tokenTypeElse
 	tokenTypeFalse
 	tokenTypeFun
@@ -45,11 +56,16 @@ const (
 	tokenTypeOr
 	tokenTypePrint
 	tokenTypeReturn
+	tokenTypeSpawn
 	tokenTypeSuper
 	tokenTypeThis
 	tokenTypeTrue
 	tokenTypeVar
 	tokenTypeWhile
+	tokenTypeYield
+
+	// comments are only produced by scanners that keep them
+	tokenTypeComment
 
 	tokenTypeEOF
 )
@@ -59,11 +75,16 @@ type token struct {
 	lexeme  string
 	literal interface{}
 	line    int
+	// column is the 1-based position of the token in its line counted in runes.
+	column int
+	// file is the name of the script the token was read from, if any.
+	file string
 }
 
 var literalToKeywordTokenType = map[string]tokenType{
 	"and":    tokenTypeAnd,
 	"class":  tokenTypeClass,
+	"const":  tokenTypeConst,
 	"else":   tokenTypeElse,
 	"false":  tokenTypeFalse,
 	"for":    tokenTypeFor,
@@ -73,13 +94,78 @@ var literalToKeywordTokenType = map[string]tokenType{
 	"or":     tokenTypeOr,
 	"print":  tokenTypePrint,
 	"return": tokenTypeReturn,
+	"spawn":  tokenTypeSpawn,
 	"super":  tokenTypeSuper,
 	"this":   tokenTypeThis,
 	"true":   tokenTypeTrue,
 	"var":    tokenTypeVar,
 	"while":  tokenTypeWhile,
+	"yield":  tokenTypeYield,
+}
+
+var tokenTypeNames = [...]string{
+	tokenTypeLeftParen:      "LeftParen",
+	tokenTypeRightParen:     "RightParen",
+	tokenTypeLeftBrace:      "LeftBrace",
+	tokenTypeRightBrace:     "RightBrace",
+	tokenTypeLeftBracket:    "LeftBracket",
+	tokenTypeRightBracket:   "RightBracket",
+	tokenTypeComma:          "Comma",
+	tokenTypeColon:          "Colon",
+	tokenTypeDot:            "Dot",
+	tokenTypeMinus:          "Minus",
+	tokenTypePlus:           "Plus",
+	tokenTypeSemicolon:      "Semicolon",
+	tokenTypeSlash:          "Slash",
+	tokenTypeStar:           "Star",
+	tokenTypePercent:        "Percent",
+	tokenTypeAmpersand:      "Ampersand",
+	tokenTypePipe:           "Pipe",
+	tokenTypeCaret:          "Caret",
+	tokenTypeQuestion:       "Question",
+	tokenTypeBang:           "Bang",
+	tokenTypeBangEqual:      "BangEqual",
+	tokenTypeEqual:          "Equal",
+	tokenTypeEqualEqual:     "EqualEqual",
+	tokenTypeGreater:        "Greater",
+	tokenTypeGreaterEqual:   "GreaterEqual",
+	tokenTypeLess:           "Less",
+	tokenTypeLessEqual:      "LessEqual",
+	tokenTypeLessLess:       "LessLess",
+	tokenTypeGreaterGreater: "GreaterGreater",
+	tokenTypeIdentifier:     "Identifier",
+	tokenTypeString:         "String",
+	tokenTypeNumber:         "Number",
+	tokenTypeAnd:            "And",
+	tokenTypeClass:          "Class",
+	tokenTypeConst:          "Const",
+	tokenTypeElse:           "Else",
+	tokenTypeFalse:          "False",
+	tokenTypeFun:            "Fun",
+	tokenTypeFor:            "For",
+	tokenTypeIf:             "If",
+	tokenTypeNil:            "Nil",
+	tokenTypeOr:             "Or",
+	tokenTypePrint:          "Print",
+	tokenTypeReturn:         "Return",
+	tokenTypeSpawn:          "Spawn",
+	tokenTypeSuper:          "Super",
+	tokenTypeThis:           "This",
+	tokenTypeTrue:           "True",
+	tokenTypeVar:            "Var",
+	tokenTypeWhile:          "While",
+	tokenTypeYield:          "Yield",
+	tokenTypeComment:        "Comment",
+	tokenTypeEOF:            "EOF",
+}
+
+func (tt tokenType) String() string {
+	if tt < 0 || int(tt) >= len(tokenTypeNames) {
+		return fmt.Sprintf("tokenType(%d)", int(tt))
+	}
+	return tokenTypeNames[tt]
 }
 
 func (t *token) toString() string {
-	return fmt.Sprintf("%d %s %v", t.tt, t.lexeme, t.literal)
+	return fmt.Sprintf("%s %s %v", t.tt, t.lexeme, t.literal)
 }
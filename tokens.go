@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+)
+
+// tokenJSON is the JSON form of a token printed by "glox tokens -json".
+type tokenJSON struct {
+	Type    string      `json:"type"`
+	Lexeme  string      `json:"lexeme"`
+	Literal interface{} `json:"literal"`
+	Line    int         `json:"line"`
+	Column  int         `json:"column"`
+}
+
+// tokensCommand implements "glox tokens", which lists the tokens the
+// scanner produces for a script.
+func tokensCommand(args []string) int {
+	fs := flag.NewFlagSet("tokens", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the tokens as a JSON array")
+	comments := fs.Bool("comments", false, "include comment tokens")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: glox tokens [-json] [-comments] file.glox")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	name := fs.Arg(0)
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	sc := &scanner{source: string(bs), file: filepath.Base(name), keepComments: *comments}
+	ts := sc.scanTokens()
+	if hadParserError {
+		return 1
+	}
+	if *comments {
+		ts = mergeComments(ts, sc.comments)
+	}
+
+	if *asJSON {
+		out := make([]tokenJSON, len(ts))
+		for k, t := range ts {
+			out[k] = tokenJSON{t.tt.String(), t.lexeme, jsonValue(t.literal), t.line, t.column}
+		}
+		bs, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(bs))
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, t := range ts {
+		fmt.Fprintf(w, "%d:%d\t%s\t%s", t.line, t.column, t.tt, strconv.Quote(t.lexeme))
+		if str, ok := t.literal.(string); ok {
+			fmt.Fprintf(w, "\t%s", strconv.Quote(str))
+		} else if t.literal != nil {
+			fmt.Fprintf(w, "\t%s", stringify(t.literal))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	return 0
+}
+
+// mergeComments inserts comments into ts in source order.
+func mergeComments(ts, comments []token) []token {
+	ret := make([]token, 0, len(ts)+len(comments))
+	for _, t := range ts {
+		for len(comments) > 0 && (comments[0].line < t.line ||
+			(comments[0].line == t.line && comments[0].column < t.column)) {
+			ret = append(ret, comments[0])
+			comments = comments[1:]
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
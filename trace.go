@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tracer is a hook that logs every statement executed, every call with its
+// arguments and every return with its value to the diagnostics writer,
+// indented by call depth.
+type tracer struct{}
+
+var _ hook = &tracer{}
+
+func (t *tracer) statement(i *interpreter, s stmt) {
+	// Blocks are traced through their statements.
+	if _, ok := s.(stmtBlock); ok {
+		return
+	}
+	start := stmtStart(s)
+	t.log(i, 0, "%s: %s", lineLabel(start.file, start.line), traceStatement(s))
+}
+
+func (t *tracer) enter(i *interpreter, f loxFunction, args []interface{}) {
+	params := make([]string, len(args))
+	for k, a := range args {
+		params[k] = f.declaration.params[k].lexeme + "=" + debugValue(a)
+	}
+	t.log(i, -1, "call %s(%s)", f.declaration.name.lexeme, strings.Join(params, ", "))
+}
+
+func (t *tracer) leave(i *interpreter, f loxFunction, value interface{}, failed bool) {
+	if failed {
+		t.log(i, -1, "%s failed", f.declaration.name.lexeme)
+		return
+	}
+	t.log(i, -1, "%s returned %s", f.declaration.name.lexeme, debugValue(value))
+}
+
+// log logs a line indented by the call depth of i plus delta. Calls are
+// logged at the depth of their caller, while their frame is on the stack.
+func (t *tracer) log(i *interpreter, delta int, format string, args ...interface{}) {
+	depth := i.depth + len(i.frames) + delta
+	fmt.Fprintf(i.diagnostics, "%s%s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+}
+
+// traceStatement prints a statement on one line. Statements with a body
+// are shown without it, since the statements of the body are traced.
+func traceStatement(s stmt) string {
+	f := &formatter{}
+	switch s := s.(type) {
+	case stmtIf:
+		return "if (" + f.expr(s.condition) + ")"
+	case stmtWhile:
+		return "while (" + f.expr(s.condition) + ")"
+	case stmtForIn:
+		return "for (" + s.name.lexeme + " in " + f.expr(s.iterable) + ")"
+	case stmtFor:
+		header := "for (;"
+		if s.initializer != nil {
+			header = "for (" + traceStatement(s.initializer)
+		}
+		if s.condition != nil {
+			header += " " + f.expr(s.condition)
+		}
+		header += ";"
+		if s.increment != nil {
+			header += " " + f.expr(s.increment)
+		}
+		return header + ")"
+	case stmtFunction:
+		params := make([]string, len(s.params))
+		for k, p := range s.params {
+			params[k] = annotated(p, s.paramTypes[k])
+		}
+		fun := "fun "
+		if s.generator {
+			fun = "fun* "
+		}
+		return fun + s.name.lexeme + "(" + strings.Join(params, ", ") + ")"
+	case stmtClass:
+		if s.superClass != nil {
+			return "class " + s.name.lexeme + " < " + s.superClass.name.lexeme
+		}
+		return "class " + s.name.lexeme
+	}
+	f.stmt(s)
+	return f.b.String()
+}